@@ -1,18 +1,72 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"webRTCInfra/pkg/entry"
 )
 
 func main() {
-	httpAddr := ":8080" // HTTP服务地址
-	stunAddr := ":3478" // STUN服务地址
+	config := entry.DefaultConfig()
+	flag.StringVar(&config.HTTPAddr, "http", config.HTTPAddr, "HTTP服务地址")
+	flag.StringVar(&config.STUNAddr, "stun", config.STUNAddr, "STUN服务地址")
+	flag.StringVar(&config.STUNTCPAddr, "stun-tcp", config.STUNTCPAddr, "STUN over TCP的监听地址，为空表示不启用")
+	flag.StringVar(&config.STUNTLSAddr, "stun-tls", config.STUNTLSAddr, "STUN over TLS的监听地址")
+	flag.StringVar(&config.STUNTLSCertFile, "stun-tls-cert", config.STUNTLSCertFile, "STUN over TLS的证书文件，与-stun-tls-key同时指定时启用TLS")
+	flag.StringVar(&config.STUNTLSKeyFile, "stun-tls-key", config.STUNTLSKeyFile, "STUN over TLS的私钥文件")
+	flag.StringVar(&config.STUNAlternateIP, "stun-alternate-ip", config.STUNAlternateIP, "RFC 5780 NAT行为发现的备用IP")
+	flag.IntVar(&config.STUNAlternatePort, "stun-alternate-port", config.STUNAlternatePort, "RFC 5780 NAT行为发现的备用端口，0表示不启用")
+	flag.BoolVar(&config.STUNLegacy, "stun-legacy", config.STUNLegacy, "兼容没有magic cookie的RFC 3489 Binding请求")
+	flag.StringVar(&config.STUNICERole, "stun-ice-role", config.STUNICERole, "应答ICE连通性检查的角色（controlling或controlled），为空表示不启用，需通过STUN_CREDENTIALS环境变量配置短期凭证")
+	flag.Uint64Var(&config.STUNICETieBreaker, "stun-ice-tie-breaker", config.STUNICETieBreaker, "ICE角色冲突时比较的tie-breaker，0表示随机生成")
+	flag.StringVar(&config.STUNAlternateServer, "stun-alternate-server", config.STUNAlternateServer, "300 Try Alternate重定向的备用服务器地址，为空表示不启用")
+	flag.BoolVar(&config.STUNRedirectAlways, "stun-redirect-always", config.STUNRedirectAlways, "始终重定向到备用服务器")
+	flag.IntVar(&config.STUNRedirectRate, "stun-redirect-rate", config.STUNRedirectRate, "每秒超过该数量的请求重定向到备用服务器，0表示不限制")
+	flag.DurationVar(&config.STUNDrainTimeout, "stun-drain-timeout", config.STUNDrainTimeout, "关闭前将请求重定向到备用服务器的排空时间")
+	flag.StringVar(&config.TURNRealm, "turn-realm", config.TURNRealm, "TURN的realm，为空表示不启用TURN")
+	flag.StringVar(&config.TURNRelayIP, "turn-relay-ip", config.TURNRelayIP, "TURN中继地址的IP，为空时使用接收请求的本地IP")
+	flag.DurationVar(&config.TURNMaxLifetime, "turn-max-lifetime", config.TURNMaxLifetime, "TURN分配有效期的上限")
+	flag.IntVar(&config.TURNRelayPortMin, "turn-relay-port-min", config.TURNRelayPortMin, "TURN中继端口范围的下限，0表示由系统分配临时端口")
+	flag.IntVar(&config.TURNRelayPortMax, "turn-relay-port-max", config.TURNRelayPortMax, "TURN中继端口范围的上限")
+	flag.IntVar(&config.TURNUserQuota, "turn-user-quota", config.TURNUserQuota, "每个TURN用户同时持有的分配数上限，0表示不限制")
+	flag.IntVar(&config.TURNIPQuota, "turn-ip-quota", config.TURNIPQuota, "每个来源IP同时持有的TURN分配数上限，0表示不限制")
+	flag.IntVar(&config.TURNAllocationBandwidth, "turn-allocation-bandwidth", config.TURNAllocationBandwidth, "每个TURN分配的带宽上限（字节/秒），0表示不限制")
+	flag.IntVar(&config.TURNUserBandwidth, "turn-user-bandwidth", config.TURNUserBandwidth, "每个TURN用户所有分配合计的带宽上限（字节/秒），0表示不限制")
+	credentialBandwidth := flag.String("turn-credential-bandwidth", "", "按用户名指定的每个分配的带宽上限，格式为user:字节每秒，多个用户以逗号分隔")
+	flag.DurationVar(&config.TURNRESTTTL, "turn-rest-ttl", config.TURNRESTTTL, "临时TURN凭证的有效期")
+	turnURIs := flag.String("turn-uris", "", "临时TURN凭证接口返回的STUN和TURN服务器URI，多个URI以逗号分隔")
+	flag.Parse()
 
-	server := entry.NewServer(httpAddr, stunAddr)
+	// 凭证和密钥通过环境变量提供，避免出现在命令行参数中
+	stunCredentials, err := parseSTUNCredentials(os.Getenv("STUN_CREDENTIALS"))
+	if err != nil {
+		log.Fatalf("invalid STUN_CREDENTIALS: %v", err)
+	}
+	config.STUNCredentials = stunCredentials
+	users, err := parseTURNUsers(os.Getenv("TURN_USERS"))
+	if err != nil {
+		log.Fatalf("invalid TURN_USERS: %v", err)
+	}
+	config.TURNUsers = users
+	config.TURNSecret = os.Getenv("TURN_SECRET")
+	config.TURNRESTAPIKey = os.Getenv("TURN_REST_API_KEY")
+	config.MetricsAPIKey = os.Getenv("METRICS_API_KEY")
+	bandwidth, err := parseTURNBandwidth(*credentialBandwidth)
+	if err != nil {
+		log.Fatalf("invalid -turn-credential-bandwidth: %v", err)
+	}
+	config.TURNCredentialBandwidth = bandwidth
+	if *turnURIs != "" {
+		config.TURNURIs = strings.Split(*turnURIs, ",")
+	}
+
+	server := entry.NewServer(config)
 	if err := server.Start(); err != nil {
 		log.Fatalf("failed to start server：%v", err)
 	}
@@ -22,6 +76,60 @@ func main() {
 	<-sigChan
 
 	log.Println("shutting down server...")
+	server.Drain()
 	server.Close()
 	log.Println("server closed")
 }
+
+// parseTURNUsers 解析"user:password,user2:password2"格式的用户列表
+func parseTURNUsers(s string) (map[string]string, error) {
+	users := make(map[string]string)
+	if s == "" {
+		return users, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		username, password, ok := strings.Cut(entry, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf("expected user:password, got %q", entry)
+		}
+		users[username] = password
+	}
+	return users, nil
+}
+
+// parseSTUNCredentials 解析"username:password,username2:password2"格式的短期凭证。
+// ICE的用户名本身包含冒号（"ufrag:ufrag"），以最后一个冒号分隔用户名和密码
+func parseSTUNCredentials(s string) (map[string]string, error) {
+	credentials := make(map[string]string)
+	if s == "" {
+		return credentials, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		i := strings.LastIndex(entry, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("expected username:password, got %q", entry)
+		}
+		credentials[entry[:i]] = entry[i+1:]
+	}
+	return credentials, nil
+}
+
+// parseTURNBandwidth 解析"user:bytesPerSecond,user2:bytesPerSecond2"格式的带宽上限
+func parseTURNBandwidth(s string) (map[string]int, error) {
+	bandwidth := make(map[string]int)
+	if s == "" {
+		return bandwidth, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		username, rate, ok := strings.Cut(entry, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf("expected user:bytesPerSecond, got %q", entry)
+		}
+		n, err := strconv.Atoi(rate)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid bandwidth %q for user %s", rate, username)
+		}
+		bandwidth[username] = n
+	}
+	return bandwidth, nil
+}
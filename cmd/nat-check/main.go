@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+	stunclient "webRTCInfra/pkg/client/stun"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run 解析命令行参数，测试NAT行为并把结果写入stdout
+func run(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("nat-check", flag.ContinueOnError)
+	server := flags.String("server", "127.0.0.1:3478", "STUN服务器地址，需启用RFC 5780 NAT行为发现")
+	jsonOutput := flags.Bool("json", false, "以JSON格式输出结果")
+	rto := flags.Duration("rto", stunclient.DefaultRTO, "初始重传超时")
+	// 过滤测试依赖请求超时判断，使用较少的重传次数避免单项测试等待过久
+	rc := flags.Int("rc", 3, "每个请求最多发送次数")
+	rm := flags.Int("rm", 4, "最后一次发送后等待 rm*rto")
+	lifetimeStart := flags.Duration("lifetime-start", 5*time.Second, "绑定存活时间测试的初始空闲时间")
+	lifetimeMax := flags.Duration("lifetime-max", 0, "绑定存活时间测试的空闲时间上限，0表示跳过该测试")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	serverAddr, err := net.ResolveUDPAddr("udp", *server)
+	if err != nil {
+		return fmt.Errorf("invalid server address: %w", err)
+	}
+
+	// 绑定到具体的本地IP，才能通过比较反射地址判断是否经过NAT
+	localIP, err := localIPFor(serverAddr)
+	if err != nil {
+		return fmt.Errorf("failed to determine local address: %w", err)
+	}
+	listen := func() (net.PacketConn, error) {
+		return net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	}
+	conn, err := listen()
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	client := stunclient.NewClient(conn)
+	client.SetRetransmission(*rto, *rc, *rm)
+	defer client.Close()
+
+	report, err := client.DiscoverNAT(context.Background(), serverAddr, stunclient.NATOptions{
+		Listen:        listen,
+		LifetimeStart: *lifetimeStart,
+		LifetimeMax:   *lifetimeMax,
+	})
+	if err != nil {
+		return fmt.Errorf("binding request to %s failed: %w", serverAddr, err)
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return fmt.Errorf("failed to encode report: %w", err)
+		}
+		return nil
+	}
+	printReport(stdout, serverAddr, report)
+	return nil
+}
+
+// localIPFor 返回访问server时系统选择的本地IP（UDP的Dial不会发送数据）
+func localIPFor(server *net.UDPAddr) (net.IP, error) {
+	conn, err := net.DialUDP("udp", nil, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+func printReport(w io.Writer, server *net.UDPAddr, r *stunclient.NATReport) {
+	fmt.Fprintf(w, "STUN server:      %s\n", server)
+	fmt.Fprintf(w, "Local address:    %s\n", r.LocalAddr)
+	fmt.Fprintf(w, "Mapped address:   %s\n", r.MappedAddr)
+	if r.NAT {
+		fmt.Fprintln(w, "NAT detected:     yes")
+	} else {
+		fmt.Fprintln(w, "NAT detected:     no (mapped address equals local address)")
+	}
+	fmt.Fprintf(w, "Mapping:          %s\n", r.Mapping)
+	fmt.Fprintf(w, "Filtering:        %s\n", r.Filtering)
+
+	switch {
+	case r.Hairpinning == nil:
+		fmt.Fprintln(w, "Hairpinning:      unknown")
+	case *r.Hairpinning:
+		fmt.Fprintln(w, "Hairpinning:      supported")
+	default:
+		fmt.Fprintln(w, "Hairpinning:      not supported")
+	}
+
+	switch {
+	case !r.Lifetime.Tested:
+		fmt.Fprintln(w, "Binding lifetime: not tested")
+	case r.Lifetime.Max == 0:
+		fmt.Fprintf(w, "Binding lifetime: at least %s\n", r.Lifetime.Min)
+	default:
+		fmt.Fprintf(w, "Binding lifetime: between %s and %s\n", r.Lifetime.Min, r.Lifetime.Max)
+	}
+
+	for _, e := range r.Errors {
+		fmt.Fprintf(w, "Warning:          %s\n", e)
+	}
+}
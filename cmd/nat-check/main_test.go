@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"webRTCInfra/pkg/network/udp"
+	stunservice "webRTCInfra/pkg/service/stun"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startSTUNService 启动启用NAT行为发现的本地STUN服务，返回其地址
+func startSTUNService(t *testing.T) string {
+	t.Helper()
+	// Linux的回环网卡接管整个127.0.0.0/8，127.0.0.2无需额外配置即可使用
+	svc := stunservice.NewService(udp.NewService("127.0.0.1:0", nil))
+	require.NoError(t, svc.SetBehaviorDiscovery("127.0.0.2", 0))
+	require.NoError(t, svc.Start())
+	t.Cleanup(svc.Close)
+	return svc.LocalAddr().String()
+}
+
+func TestRun(t *testing.T) {
+	server := startSTUNService(t)
+	args := []string{"-server", server, "-rto", "20ms", "-rc", "2", "-rm", "2", "-lifetime-start", "10ms", "-lifetime-max", "20ms"}
+
+	t.Run("可读输出", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, run(args, &out))
+		for _, line := range []string{
+			"STUN server:      " + server,
+			"NAT detected:     no (mapped address equals local address)",
+			"Mapping:          endpoint-independent",
+			"Filtering:        endpoint-independent",
+			"Hairpinning:      supported",
+			"Binding lifetime: at least 20ms",
+		} {
+			assert.Contains(t, out.String(), line+"\n")
+		}
+		assert.NotContains(t, out.String(), "Warning:")
+	})
+
+	t.Run("JSON输出", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, run(append(args, "-json"), &out))
+		var report struct {
+			LocalAddress    string `json:"localAddress"`
+			MappedAddress   string `json:"mappedAddress"`
+			OtherAddress    string `json:"otherAddress"`
+			NAT             bool   `json:"nat"`
+			Mapping         string `json:"mapping"`
+			Filtering       string `json:"filtering"`
+			Hairpinning     *bool  `json:"hairpinning"`
+			BindingLifetime struct {
+				Tested bool   `json:"tested"`
+				Min    string `json:"min"`
+			} `json:"bindingLifetime"`
+			Errors []string `json:"errors"`
+		}
+		require.NoError(t, json.Unmarshal(out.Bytes(), &report), out.String())
+		assert.Equal(t, report.LocalAddress, report.MappedAddress)
+		assert.True(t, strings.HasPrefix(report.OtherAddress, "127.0.0.2:"))
+		assert.False(t, report.NAT)
+		assert.Equal(t, "endpoint-independent", report.Mapping)
+		assert.Equal(t, "endpoint-independent", report.Filtering)
+		require.NotNil(t, report.Hairpinning)
+		assert.True(t, *report.Hairpinning)
+		assert.True(t, report.BindingLifetime.Tested)
+		assert.Equal(t, "20ms", report.BindingLifetime.Min)
+		assert.Empty(t, report.Errors)
+	})
+
+	t.Run("参数错误", func(t *testing.T) {
+		var out bytes.Buffer
+		assert.Error(t, run([]string{"-rc", "many"}, &out))
+		assert.Error(t, run([]string{"-server", "not an address"}, &out))
+		assert.Empty(t, out.String())
+	})
+}
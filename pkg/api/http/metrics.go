@@ -0,0 +1,22 @@
+package http
+
+import (
+	"net/http"
+	"webRTCInfra/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetMetricsAPIKey 启用/debug/vars运行指标接口，调用需提供apiKey，需在Router.Run之前调用
+func (s *Handler) SetMetricsAPIKey(apiKey string) {
+	s.metricsAPIKey = apiKey
+}
+
+// MetricsHandler 导出运行指标，API密钥与临时TURN凭证接口一样通过Authorization: Bearer头或key参数提供
+func (s *Handler) MetricsHandler(c *gin.Context) {
+	if !validAPIKey(c.Request, s.metricsAPIKey) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+		return
+	}
+	metrics.Handler().ServeHTTP(c.Writer, c.Request)
+}
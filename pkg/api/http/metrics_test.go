@@ -0,0 +1,40 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	handler := NewHandler(nil)
+	handler.SetMetricsAPIKey("metrics-key")
+	g := newTestEngine(handler)
+	get := func(target, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/debug/vars", "Bearer metrics-key")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"webRTCInfra"`)
+	assert.Equal(t, http.StatusOK, get("/debug/vars?key=metrics-key", "").Code)
+
+	assert.Equal(t, http.StatusUnauthorized, get("/debug/vars", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, get("/debug/vars", "Bearer wrong").Code)
+}
+
+func TestMetricsHandler_Disabled(t *testing.T) {
+	g := newTestEngine(NewHandler(nil))
+	req := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -23,4 +23,10 @@ func (r *router) Run(addr string) error {
 func (r *router) registerRoutes(g *gin.Engine) {
 	g.GET("/ws/signaling", r.handler.WebsocketSignalHandler)
 	g.GET("/clients", r.handler.ListSignalClients)
+	if r.handler.metricsAPIKey != "" {
+		g.GET("/debug/vars", r.handler.MetricsHandler) // 运行指标
+	}
+	if r.handler.turnCredentials != nil {
+		g.GET("/turn/credentials", r.handler.TURNCredentialsHandler)
+	}
 }
@@ -11,8 +11,10 @@ import (
 )
 
 type Handler struct {
-	upGrader   websocket.Upgrader // 定义 WebSocket Upgrader，用于把普通 HTTP 请求升级为 WebSocket 连接
-	sdpService *sdp.Service
+	upGrader        websocket.Upgrader // 定义 WebSocket Upgrader，用于把普通 HTTP 请求升级为 WebSocket 连接
+	sdpService      *sdp.Service
+	turnCredentials *TURNCredentialConfig // 为nil时不提供临时TURN凭证接口
+	metricsAPIKey   string                // 为空时不提供运行指标接口
 }
 
 func NewHandler(sdpSvc *sdp.Service) *Handler {
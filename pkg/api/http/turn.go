@@ -0,0 +1,79 @@
+package http
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+	"webRTCInfra/pkg/common"
+	"webRTCInfra/pkg/service/turn"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TURNCredentialConfig 临时TURN凭证接口的配置
+type TURNCredentialConfig struct {
+	Secret string        // 与TURN服务共享的密钥
+	APIKey string        // 调用接口需要提供的密钥
+	TTL    time.Duration // 凭证的有效期
+	URIs   []string      // 返回给客户端的STUN和TURN服务器URI，如turn:example.com:3478?transport=udp
+}
+
+// SetTURNCredentials 启用临时TURN凭证接口，需在Router.Run之前调用
+func (s *Handler) SetTURNCredentials(config TURNCredentialConfig) {
+	if config.URIs == nil {
+		config.URIs = []string{}
+	}
+	s.turnCredentials = &config
+}
+
+// TURNCredentialsHandler 返回临时TURN凭证和iceServers（draft-uberti-behave-turn-rest）。
+// API密钥通过Authorization: Bearer头或key参数提供，username参数为凭证所属的用户ID
+func (s *Handler) TURNCredentialsHandler(c *gin.Context) {
+	config := s.turnCredentials
+	if !validAPIKey(c.Request, config.APIKey) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+		return
+	}
+	userID := c.Query("username")
+	if len(userID) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing username"})
+		return
+	}
+
+	username, password := turn.EphemeralCredentials(config.Secret, userID, time.Now().Add(config.TTL))
+	var stunURIs, turnURIs []string
+	for _, uri := range config.URIs {
+		if strings.HasPrefix(uri, "turn:") || strings.HasPrefix(uri, "turns:") {
+			turnURIs = append(turnURIs, uri)
+		} else {
+			stunURIs = append(stunURIs, uri)
+		}
+	}
+	iceServers := []common.ICEServer{}
+	if len(stunURIs) > 0 {
+		iceServers = append(iceServers, common.ICEServer{URLs: stunURIs})
+	}
+	if len(turnURIs) > 0 {
+		iceServers = append(iceServers, common.ICEServer{URLs: turnURIs, Username: username, Credential: password})
+	}
+
+	// 凭证不能被缓存
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, common.TURNCredentialsResponse{
+		Username:   username,
+		Password:   password,
+		TTL:        int(config.TTL / time.Second),
+		URIs:       config.URIs,
+		ICEServers: iceServers,
+	})
+}
+
+// validAPIKey 以固定时间比较请求中的API密钥
+func validAPIKey(r *http.Request, apiKey string) bool {
+	key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		key = r.URL.Query().Get("key")
+	}
+	return apiKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1
+}
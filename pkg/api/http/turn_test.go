@@ -0,0 +1,104 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"webRTCInfra/pkg/common"
+	"webRTCInfra/pkg/service/turn"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEngine(handler *Handler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	g := gin.New()
+	NewRouter(handler).registerRoutes(g)
+	return g
+}
+
+func TestTURNCredentialsHandler(t *testing.T) {
+	handler := NewHandler(nil)
+	handler.SetTURNCredentials(TURNCredentialConfig{
+		Secret: "north",
+		APIKey: "api-key",
+		TTL:    time.Hour,
+		URIs:   []string{"stun:turn.example.com:3478", "turn:turn.example.com:3478?transport=udp", "turns:turn.example.com:5349?transport=tcp"},
+	})
+	g := newTestEngine(handler)
+	get := func(target, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("签发临时凭证和iceServers", func(t *testing.T) {
+		w := get("/turn/credentials?username=alice", "Bearer api-key")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+		var resp common.TURNCredentialsResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		ts, userID, ok := strings.Cut(resp.Username, ":")
+		require.True(t, ok)
+		assert.Equal(t, "alice", userID)
+		expires, err := strconv.ParseInt(ts, 10, 64)
+		require.NoError(t, err)
+		assert.InDelta(t, time.Now().Add(time.Hour).Unix(), expires, 2)
+		assert.Equal(t, 3600, resp.TTL)
+		assert.Len(t, resp.URIs, 3)
+
+		// TURN服务能够校验签发的凭证
+		password, ok := turn.EphemeralCredentialFunc("north")(resp.Username)
+		require.True(t, ok)
+		assert.Equal(t, password, resp.Password)
+
+		assert.Equal(t, []common.ICEServer{
+			{URLs: []string{"stun:turn.example.com:3478"}},
+			{
+				URLs:       []string{"turn:turn.example.com:3478?transport=udp", "turns:turn.example.com:5349?transport=tcp"},
+				Username:   resp.Username,
+				Credential: resp.Password,
+			},
+		}, resp.ICEServers)
+	})
+
+	t.Run("通过key参数提供API密钥", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, get("/turn/credentials?username=alice&key=api-key", "").Code)
+	})
+
+	tests := []struct {
+		name          string
+		target        string
+		authorization string
+		code          int
+	}{
+		{"缺少API密钥", "/turn/credentials?username=alice", "", http.StatusUnauthorized},
+		{"API密钥错误", "/turn/credentials?username=alice", "Bearer wrong", http.StatusUnauthorized},
+		{"key参数错误", "/turn/credentials?username=alice&key=wrong", "", http.StatusUnauthorized},
+		{"缺少username", "/turn/credentials", "Bearer api-key", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.code, get(tt.target, tt.authorization).Code)
+		})
+	}
+}
+
+func TestTURNCredentialsHandler_Disabled(t *testing.T) {
+	g := newTestEngine(NewHandler(nil))
+	req := httptest.NewRequest(http.MethodGet, "/turn/credentials?username=alice", nil)
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
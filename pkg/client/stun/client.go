@@ -0,0 +1,260 @@
+package stun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+	"webRTCInfra/pkg/protocol/stun"
+)
+
+// RFC 8489 6.2.1 推荐的重传参数
+const (
+	DefaultRTO = 500 * time.Millisecond // 初始重传超时
+	DefaultRc  = 7                      // 最多发送次数
+	DefaultRm  = 16                     // 最后一次发送后等待 Rm*RTO
+)
+
+var (
+	ErrTimeout      = errors.New("stun client: transaction timed out")
+	ErrClientClosed = errors.New("stun client: closed")
+)
+
+// ResponseError 服务器返回的错误响应
+type ResponseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("stun client: error response %d %s", e.Code, e.Reason)
+}
+
+// Response 一次事务收到的响应
+type Response struct {
+	Message *stun.Message
+	From    net.Addr // 响应的来源地址，RFC 5780 测试中可能与请求的目标地址不同
+}
+
+// Client STUN客户端，在net.PacketConn上发送请求，按事务ID匹配响应，并按RFC 8489的RTO/Rc/Rm重传
+type Client struct {
+	conn net.PacketConn
+	rto  time.Duration
+	rc   int
+	rm   int
+
+	mu           sync.Mutex
+	transactions map[[12]byte]chan Response
+	onRequest    func(msg *stun.Message, from net.Addr)
+	closed       bool
+	done         chan struct{}
+}
+
+// NewClient 创建客户端并开始从conn读取响应，Close时会关闭conn
+func NewClient(conn net.PacketConn) *Client {
+	c := &Client{
+		conn:         conn,
+		rto:          DefaultRTO,
+		rc:           DefaultRc,
+		rm:           DefaultRm,
+		transactions: make(map[[12]byte]chan Response),
+		done:         make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+// SetRetransmission 设置重传参数，需在发送请求前调用
+func (c *Client) SetRetransmission(rto time.Duration, rc, rm int) {
+	c.rto = rto
+	c.rc = rc
+	c.rm = rm
+}
+
+// SetRequestHandler 设置收到STUN请求时的回调（如发夹测试中收到自己发出的请求），为nil时丢弃请求
+func (c *Client) SetRequestHandler(handler func(msg *stun.Message, from net.Addr)) {
+	c.mu.Lock()
+	c.onRequest = handler
+	c.mu.Unlock()
+}
+
+// LocalAddr 返回客户端使用的本地地址
+func (c *Client) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+// Do 发送请求并等待同一事务ID的响应（成功或错误响应均会返回），
+// 超时前按 RTO、2*RTO、4*RTO... 的间隔重传，最后一次发送后等待 Rm*RTO
+func (c *Client) Do(ctx context.Context, req *stun.Message, server net.Addr) (*Response, error) {
+	ch := make(chan Response, 1)
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClientClosed
+	}
+	c.transactions[req.TransactionID] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.transactions, req.TransactionID)
+		c.mu.Unlock()
+	}()
+
+	data := stun.Encode(req)
+	rto := c.rto
+	for attempt := 1; attempt <= c.rc; attempt++ {
+		if _, err := c.conn.WriteTo(data, server); err != nil {
+			return nil, err
+		}
+
+		wait := rto
+		if attempt == c.rc {
+			wait = c.rto * time.Duration(c.rm)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case resp := <-ch:
+			timer.Stop()
+			return &resp, nil
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-c.done:
+			timer.Stop()
+			return nil, ErrClientClosed
+		case <-timer.C:
+			rto *= 2
+		}
+	}
+	return nil, ErrTimeout
+}
+
+// expect 登记一个由其他套接字发出的事务，用于接收服务器按RESPONSE-PORT发往本套接字的响应。
+// 返回的函数取消登记
+func (c *Client) expect(transactionID [12]byte) (<-chan Response, func()) {
+	ch := make(chan Response, 1)
+	c.mu.Lock()
+	c.transactions[transactionID] = ch
+	c.mu.Unlock()
+	return ch, func() {
+		c.mu.Lock()
+		delete(c.transactions, transactionID)
+		c.mu.Unlock()
+	}
+}
+
+// Binding 发送Binding请求，返回服务器看到的反射地址。服务器回复300 Try Alternate时向ALTERNATE-SERVER重试一次
+func (c *Client) Binding(ctx context.Context, server net.Addr) (*net.UDPAddr, error) {
+	resp, err := c.Do(ctx, stun.NewMessage(stun.MessageTypeBindingRequest, stun.NewTransactionID()), server)
+	if err != nil {
+		return nil, err
+	}
+	if alternate := AlternateServer(resp.Message); alternate != nil {
+		if resp, err = c.Do(ctx, stun.NewMessage(stun.MessageTypeBindingRequest, stun.NewTransactionID()), alternate); err != nil {
+			return nil, err
+		}
+	}
+	return MappedAddress(resp.Message)
+}
+
+// AlternateServer 返回300 Try Alternate响应中的ALTERNATE-SERVER，其他响应返回nil
+func AlternateServer(msg *stun.Message) *net.UDPAddr {
+	if stun.ClassOf(msg.Type) != stun.ClassErrorResponse {
+		return nil
+	}
+	if code, _, err := msg.GetErrorCode(); err != nil || code != stun.ErrorCodeTryAlternate {
+		return nil
+	}
+	ip, port, err := msg.GetAlternateServer()
+	if err != nil {
+		return nil
+	}
+	return &net.UDPAddr{IP: ip, Port: port}
+}
+
+// MappedAddress 从Binding成功响应中取出反射地址，兼容只返回MAPPED-ADDRESS的旧服务器
+func MappedAddress(msg *stun.Message) (*net.UDPAddr, error) {
+	if stun.ClassOf(msg.Type) == stun.ClassErrorResponse {
+		code, reason, err := msg.GetErrorCode()
+		if err != nil {
+			return nil, err
+		}
+		return nil, &ResponseError{Code: code, Reason: reason}
+	}
+
+	ip, port, err := msg.GetXORMappedAddress()
+	if errors.Is(err, stun.ErrAttributeNotFound) {
+		ip, port, err = msg.GetMappedAddress()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: ip, Port: port}, nil
+}
+
+// readLoop 读取响应并分发给等待中的事务，请求交给onRequest，非STUN报文和未知事务的响应直接丢弃
+func (c *Client) readLoop() {
+	defer close(c.done)
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			c.mu.Lock()
+			closed := c.closed
+			c.mu.Unlock()
+			if !closed {
+				log.Printf("stun client read error: %v", err)
+			}
+			return
+		}
+
+		msg, err := stun.Decode(buf[:n])
+		if err != nil {
+			continue
+		}
+		switch stun.ClassOf(msg.Type) {
+		case stun.ClassSuccessResponse, stun.ClassErrorResponse:
+		case stun.ClassRequest:
+			c.mu.Lock()
+			handler := c.onRequest
+			c.mu.Unlock()
+			if handler != nil {
+				handler(msg, from)
+			}
+			continue
+		default:
+			continue
+		}
+
+		c.mu.Lock()
+		ch, ok := c.transactions[msg.TransactionID]
+		c.mu.Unlock()
+		if !ok {
+			continue
+		}
+		// 重传可能导致收到多个响应，只保留第一个
+		select {
+		case ch <- Response{Message: msg, From: from}:
+		default:
+		}
+	}
+}
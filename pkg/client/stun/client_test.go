@@ -0,0 +1,155 @@
+package stun
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+	"webRTCInfra/pkg/network/udp"
+	"webRTCInfra/pkg/protocol/stun"
+	stunservice "webRTCInfra/pkg/service/stun"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	client := NewClient(conn)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// startFakeServer 启动一个只响应第dropCount次之后的请求的服务器，返回其地址和收到的请求数
+func startFakeServer(t *testing.T, dropCount int32) (*net.UDPAddr, *atomic.Int32) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	var received atomic.Int32
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if received.Add(1) <= dropCount {
+				continue
+			}
+			req, err := stun.Decode(buf[:n])
+			if err != nil {
+				continue
+			}
+			resp := stun.NewMessage(stun.MessageTypeBindingResponse, req.TransactionID)
+			resp.SetXORMappedAddress(from.IP, from.Port)
+			conn.WriteToUDP(stun.Encode(resp), from)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr), &received
+}
+
+func TestClient_Binding(t *testing.T) {
+	svc := stunservice.NewService(udp.NewService("127.0.0.1:0", nil))
+	require.NoError(t, svc.Start())
+	t.Cleanup(svc.Close)
+
+	client := newTestClient(t)
+	addr, err := client.Binding(context.Background(), svc.LocalAddr())
+	require.NoError(t, err)
+
+	local := client.LocalAddr().(*net.UDPAddr)
+	assert.True(t, addr.IP.Equal(local.IP))
+	assert.Equal(t, local.Port, addr.Port)
+}
+
+func TestClient_Retransmission(t *testing.T) {
+	server, received := startFakeServer(t, 2)
+
+	client := newTestClient(t)
+	client.SetRetransmission(20*time.Millisecond, DefaultRc, DefaultRm)
+
+	start := time.Now()
+	_, err := client.Binding(context.Background(), server)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), received.Load())
+	// 第三次发送发生在 RTO + 2*RTO 之后
+	assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond)
+}
+
+func TestClient_Timeout(t *testing.T) {
+	server, received := startFakeServer(t, 100)
+
+	client := newTestClient(t)
+	client.SetRetransmission(10*time.Millisecond, 3, 4)
+
+	start := time.Now()
+	_, err := client.Binding(context.Background(), server)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Equal(t, int32(3), received.Load())
+	// 等待时间为 RTO + 2*RTO + Rm*RTO
+	assert.GreaterOrEqual(t, time.Since(start), 70*time.Millisecond)
+}
+
+func TestClient_ContextCancel(t *testing.T) {
+	server, _ := startFakeServer(t, 100)
+	client := newTestClient(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := client.Binding(ctx, server)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestClient_ErrorResponse(t *testing.T) {
+	svc := stunservice.NewService(udp.NewService("127.0.0.1:0", nil))
+	svc.SetCredentialFunc(func(string) (string, bool) { return "", false })
+	require.NoError(t, svc.Start())
+	t.Cleanup(svc.Close)
+
+	client := newTestClient(t)
+	req := stun.NewMessage(stun.MessageTypeBindingRequest, stun.NewTransactionID())
+	require.NoError(t, req.SetUsername("nobody"))
+	req.IntegrityKey = []byte("password")
+
+	resp, err := client.Do(context.Background(), req, svc.LocalAddr())
+	require.NoError(t, err)
+
+	_, err = MappedAddress(resp.Message)
+	var respErr *ResponseError
+	require.True(t, errors.As(err, &respErr))
+	assert.Equal(t, stun.ErrorCodeUnauthorized, respErr.Code)
+}
+
+func TestClient_Close(t *testing.T) {
+	server, _ := startFakeServer(t, 100)
+	client := newTestClient(t)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		client.Close()
+	}()
+	_, err := client.Binding(context.Background(), server)
+	assert.ErrorIs(t, err, ErrClientClosed)
+}
+
+func TestClient_BindingFollowsAlternateServer(t *testing.T) {
+	target := stunservice.NewService(udp.NewService("127.0.0.1:0", nil))
+	require.NoError(t, target.Start())
+	t.Cleanup(target.Close)
+
+	redirecting := stunservice.NewService(udp.NewService("127.0.0.1:0", nil))
+	require.NoError(t, redirecting.SetAlternateServer(target.LocalAddr().String(), stunservice.RedirectAlways))
+	require.NoError(t, redirecting.Start())
+	t.Cleanup(redirecting.Close)
+
+	client := newTestClient(t)
+	addr, err := client.Binding(context.Background(), redirecting.LocalAddr())
+	require.NoError(t, err)
+	assert.Equal(t, client.LocalAddr().String(), addr.String())
+}
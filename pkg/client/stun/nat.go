@@ -0,0 +1,359 @@
+package stun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+	"webRTCInfra/pkg/protocol/stun"
+)
+
+// Behavior RFC 5780 定义的NAT映射/过滤行为
+type Behavior int
+
+const (
+	BehaviorUnknown Behavior = iota
+	BehaviorEndpointIndependent
+	BehaviorAddressDependent
+	BehaviorAddressAndPortDependent
+)
+
+func (b Behavior) String() string {
+	switch b {
+	case BehaviorEndpointIndependent:
+		return "endpoint-independent"
+	case BehaviorAddressDependent:
+		return "address-dependent"
+	case BehaviorAddressAndPortDependent:
+		return "address-and-port-dependent"
+	default:
+		return "unknown"
+	}
+}
+
+func (b Behavior) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// NATReport NAT行为发现的结果
+type NATReport struct {
+	LocalAddr   *net.UDPAddr `json:"localAddress"`
+	MappedAddr  *net.UDPAddr `json:"mappedAddress"`
+	OtherAddr   *net.UDPAddr `json:"otherAddress,omitempty"` // 服务器未启用行为发现时为空
+	NAT         bool         `json:"nat"`                    // 反射地址与本地地址不同
+	Mapping     Behavior     `json:"mapping"`
+	Filtering   Behavior     `json:"filtering"`
+	Hairpinning *bool        `json:"hairpinning,omitempty"` // 未测试或测试出错时为空
+	Lifetime    Lifetime     `json:"bindingLifetime"`
+	Errors      []string     `json:"errors,omitempty"` // 各项测试中遇到的非致命错误
+}
+
+// Lifetime 绑定存活时间的测试结果：空闲Min后映射仍然存在，空闲Max后映射已过期，Max为0表示测试范围内未观察到过期
+type Lifetime struct {
+	Tested bool
+	Min    time.Duration
+	Max    time.Duration
+}
+
+// MarshalJSON 时长以 "30s" 这样的可读形式输出
+func (l Lifetime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Tested bool   `json:"tested"`
+		Min    string `json:"min,omitempty"`
+		Max    string `json:"max,omitempty"`
+	}{l.Tested, durationString(l.Min), durationString(l.Max)})
+}
+
+func durationString(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// NATOptions NAT行为发现的可选项
+type NATOptions struct {
+	// Listen 创建额外的本地套接字，用于发夹测试和绑定存活时间测试；为空时跳过这两项测试
+	Listen func() (net.PacketConn, error)
+	// LifetimeStart、LifetimeMax 绑定存活时间测试的初始空闲时间与上限，空闲时间逐次翻倍；LifetimeMax为0时跳过该测试
+	LifetimeStart time.Duration
+	LifetimeMax   time.Duration
+}
+
+// DiscoverNAT 按RFC 5780第4节的顺序测试映射行为、过滤行为、发夹与绑定存活时间。
+// 只有第一次Binding失败会返回错误，其余测试的错误记录在NATReport.Errors中
+func (c *Client) DiscoverNAT(ctx context.Context, server *net.UDPAddr, opts NATOptions) (*NATReport, error) {
+	report := &NATReport{}
+	if local, ok := c.LocalAddr().(*net.UDPAddr); ok {
+		report.LocalAddr = local
+	}
+
+	// 测试I：向主地址发送Binding请求
+	resp, err := c.Do(ctx, stun.NewMessage(stun.MessageTypeBindingRequest, stun.NewTransactionID()), server)
+	if err != nil {
+		return nil, err
+	}
+	mapped, err := MappedAddress(resp.Message)
+	if err != nil {
+		return nil, err
+	}
+	report.MappedAddr = mapped
+	report.NAT = !sameAddr(mapped, report.LocalAddr)
+	if ip, port, err := resp.Message.GetOtherAddress(); err == nil {
+		report.OtherAddr = &net.UDPAddr{IP: ip, Port: port}
+	}
+
+	if report.OtherAddr == nil {
+		report.addError(errors.New("server does not support NAT behavior discovery (no OTHER-ADDRESS)"))
+	} else {
+		c.discoverMapping(ctx, server, report)
+		c.discoverFiltering(ctx, server, report)
+	}
+	if opts.Listen != nil {
+		c.discoverHairpinning(ctx, opts.Listen, report)
+	}
+	if opts.LifetimeMax > 0 {
+		c.discoverLifetime(ctx, server, opts, report)
+	}
+	return report, nil
+}
+
+// MarshalJSON 地址以 "ip:port" 的形式输出
+func (r *NATReport) MarshalJSON() ([]byte, error) {
+	type report NATReport
+	return json.Marshal(struct {
+		*report
+		LocalAddr  string `json:"localAddress"`
+		MappedAddr string `json:"mappedAddress"`
+		OtherAddr  string `json:"otherAddress,omitempty"`
+	}{(*report)(r), addrString(r.LocalAddr), addrString(r.MappedAddr), addrString(r.OtherAddr)})
+}
+
+func addrString(addr *net.UDPAddr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+func (r *NATReport) addError(err error) {
+	r.Errors = append(r.Errors, err.Error())
+}
+
+// discoverMapping RFC 5780 4.3：依次向备用IP+主端口、备用IP+备用端口发送请求，比较反射地址
+func (c *Client) discoverMapping(ctx context.Context, server *net.UDPAddr, report *NATReport) {
+	if !report.NAT {
+		report.Mapping = BehaviorEndpointIndependent
+		return
+	}
+
+	// 测试II
+	x2, err := c.Binding(ctx, &net.UDPAddr{IP: report.OtherAddr.IP, Port: server.Port})
+	if err != nil {
+		report.addError(err)
+		return
+	}
+	if sameAddr(x2, report.MappedAddr) {
+		report.Mapping = BehaviorEndpointIndependent
+		return
+	}
+
+	// 测试III
+	x3, err := c.Binding(ctx, report.OtherAddr)
+	if err != nil {
+		report.addError(err)
+		return
+	}
+	if sameAddr(x3, x2) {
+		report.Mapping = BehaviorAddressDependent
+	} else {
+		report.Mapping = BehaviorAddressAndPortDependent
+	}
+}
+
+// discoverFiltering RFC 5780 4.4：请求服务器从备用地址/备用端口回复，根据能否收到响应判断过滤行为
+func (c *Client) discoverFiltering(ctx context.Context, server *net.UDPAddr, report *NATReport) {
+	// 测试II：同时改变IP和端口
+	ok, err := c.changeRequest(ctx, server, true, true)
+	if err != nil {
+		report.addError(err)
+		return
+	}
+	if ok {
+		report.Filtering = BehaviorEndpointIndependent
+		return
+	}
+
+	// 测试III：只改变端口
+	ok, err = c.changeRequest(ctx, server, false, true)
+	if err != nil {
+		report.addError(err)
+		return
+	}
+	if ok {
+		report.Filtering = BehaviorAddressDependent
+	} else {
+		report.Filtering = BehaviorAddressAndPortDependent
+	}
+}
+
+// changeRequest 发送带CHANGE-REQUEST的请求，超时返回false
+func (c *Client) changeRequest(ctx context.Context, server *net.UDPAddr, changeIP, changePort bool) (bool, error) {
+	req := stun.NewMessage(stun.MessageTypeBindingRequest, stun.NewTransactionID())
+	req.SetChangeRequest(changeIP, changePort)
+	resp, err := c.Do(ctx, req, server)
+	if errors.Is(err, ErrTimeout) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err := MappedAddress(resp.Message); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// discoverHairpinning RFC 5780 4.5：从另一个本地套接字向自己的反射地址发送Binding请求，能收到即支持发夹
+func (c *Client) discoverHairpinning(ctx context.Context, listen func() (net.PacketConn, error), report *NATReport) {
+	conn, err := listen()
+	if err != nil {
+		report.addError(err)
+		return
+	}
+	sender := NewClient(conn)
+	sender.SetRetransmission(c.rto, c.rc, c.rm)
+	defer sender.Close()
+
+	req := stun.NewMessage(stun.MessageTypeBindingRequest, stun.NewTransactionID())
+	received := make(chan struct{}, 1)
+	c.SetRequestHandler(func(msg *stun.Message, from net.Addr) {
+		if msg.TransactionID == req.TransactionID {
+			select {
+			case received <- struct{}{}:
+			default:
+			}
+		}
+	})
+	defer c.SetRequestHandler(nil)
+
+	// 发夹请求不会得到响应，收到请求后取消发送方的重传
+	sendCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		_, err := sender.Do(sendCtx, req, report.MappedAddr)
+		result <- err
+	}()
+
+	select {
+	case <-received:
+		supported := true
+		report.Hairpinning = &supported
+		cancel()
+		<-result
+	case err := <-result:
+		if errors.Is(err, ErrTimeout) {
+			supported := false
+			report.Hairpinning = &supported
+		} else {
+			report.addError(err)
+		}
+	}
+}
+
+// discoverLifetime RFC 5780 4.6：从原套接字发送Binding请求建立映射X，空闲T后从另一个套接字发送带RESPONSE-PORT的请求，
+// 要求服务器把响应发往X的端口。原套接字在此期间保持空闲，收到响应说明映射在空闲T后仍然存在。T逐次翻倍直到上限
+func (c *Client) discoverLifetime(ctx context.Context, server *net.UDPAddr, opts NATOptions, report *NATReport) {
+	if opts.Listen == nil {
+		report.addError(errors.New("binding lifetime test requires a second local socket"))
+		return
+	}
+	conn, err := opts.Listen()
+	if err != nil {
+		report.addError(err)
+		return
+	}
+	prober := NewClient(conn)
+	prober.SetRetransmission(c.rto, c.rc, c.rm)
+	defer prober.Close()
+
+	idle := opts.LifetimeStart
+	if idle <= 0 {
+		idle = time.Second
+	}
+	for idle <= opts.LifetimeMax {
+		// 每轮重新建立映射，上一轮探测之后映射可能已经过期
+		mapped, err := c.Binding(ctx, server)
+		if err != nil {
+			report.addError(err)
+			return
+		}
+
+		timer := time.NewTimer(idle)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			report.addError(ctx.Err())
+			return
+		case <-timer.C:
+		}
+
+		alive, err := c.probeMapping(ctx, prober, server, mapped.Port)
+		if err != nil {
+			report.addError(err)
+			return
+		}
+		report.Lifetime.Tested = true
+		if !alive {
+			report.Lifetime.Max = idle
+			return
+		}
+		report.Lifetime.Min = idle
+		idle *= 2
+	}
+}
+
+// probeMapping 通过prober发送带RESPONSE-PORT的Binding请求，原套接字在重传结束前收到响应时返回true。
+// 服务器不支持RESPONSE-PORT时错误响应发回prober，作为错误返回
+func (c *Client) probeMapping(ctx context.Context, prober *Client, server *net.UDPAddr, port int) (bool, error) {
+	req := stun.NewMessage(stun.MessageTypeBindingRequest, stun.NewTransactionID())
+	req.SetResponsePort(port)
+	received, cancelExpect := c.expect(req.TransactionID)
+	defer cancelExpect()
+
+	// 成功响应不会发回prober，收到响应后取消prober的重传
+	sendCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		resp, err := prober.Do(sendCtx, req, server)
+		if err == nil {
+			_, err = MappedAddress(resp.Message)
+			if err == nil {
+				err = errors.New("stun client: RESPONSE-PORT was ignored by the server")
+			}
+		}
+		result <- err
+	}()
+
+	select {
+	case <-received:
+		cancel()
+		<-result
+		return true, nil
+	case err := <-result:
+		if errors.Is(err, ErrTimeout) {
+			return false, nil
+		}
+		return false, err
+	}
+}
+
+func sameAddr(a, b *net.UDPAddr) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
@@ -0,0 +1,144 @@
+package stun
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+	"webRTCInfra/pkg/network/udp"
+	"webRTCInfra/pkg/protocol/stun"
+	stunservice "webRTCInfra/pkg/service/stun"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listenLoopback() (net.PacketConn, error) {
+	return net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+}
+
+func TestClient_DiscoverNAT(t *testing.T) {
+	// Linux的回环网卡接管整个127.0.0.0/8，127.0.0.2无需额外配置即可使用
+	svc := stunservice.NewService(udp.NewService("127.0.0.1:0", nil))
+	require.NoError(t, svc.SetBehaviorDiscovery("127.0.0.2", 0))
+	require.NoError(t, svc.Start())
+	t.Cleanup(svc.Close)
+
+	client := newTestClient(t)
+	client.SetRetransmission(20*time.Millisecond, 3, 4)
+	report, err := client.DiscoverNAT(context.Background(), svc.LocalAddr(), NATOptions{
+		Listen:        listenLoopback,
+		LifetimeStart: 10 * time.Millisecond,
+		LifetimeMax:   40 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	assert.Empty(t, report.Errors)
+	assert.False(t, report.NAT)
+	assert.Equal(t, client.LocalAddr().String(), report.MappedAddr.String())
+	assert.True(t, report.OtherAddr.IP.Equal(net.ParseIP("127.0.0.2")))
+	assert.Equal(t, BehaviorEndpointIndependent, report.Mapping)
+	assert.Equal(t, BehaviorEndpointIndependent, report.Filtering)
+	require.NotNil(t, report.Hairpinning)
+	assert.True(t, *report.Hairpinning)
+	assert.Equal(t, Lifetime{Tested: true, Min: 40 * time.Millisecond}, report.Lifetime)
+
+	data, err := json.Marshal(report)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"mappedAddress":"`+client.LocalAddr().String()+`"`)
+	assert.Contains(t, string(data), `"mapping":"endpoint-independent"`)
+	assert.Contains(t, string(data), `"bindingLifetime":{"tested":true,"min":"40ms"}`)
+}
+
+func TestClient_DiscoverNATWithoutBehaviorDiscovery(t *testing.T) {
+	svc := stunservice.NewService(udp.NewService("127.0.0.1:0", nil))
+	require.NoError(t, svc.Start())
+	t.Cleanup(svc.Close)
+
+	client := newTestClient(t)
+	report, err := client.DiscoverNAT(context.Background(), svc.LocalAddr(), NATOptions{})
+	require.NoError(t, err)
+
+	assert.Nil(t, report.OtherAddr)
+	assert.Equal(t, BehaviorUnknown, report.Mapping)
+	assert.Equal(t, BehaviorUnknown, report.Filtering)
+	assert.Nil(t, report.Hairpinning)
+	assert.False(t, report.Lifetime.Tested)
+	assert.Len(t, report.Errors, 1)
+}
+
+func TestClient_DiscoverNATFiltering(t *testing.T) {
+	// 模拟收不到备用地址响应的情况：服务器声明OTHER-ADDRESS，但忽略所有CHANGE-REQUEST
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			req, err := stun.Decode(buf[:n])
+			if err != nil || req.Attributes.Has(stun.AttributeTypeChangeRequest) {
+				continue
+			}
+			resp := stun.NewMessage(stun.MessageTypeBindingResponse, req.TransactionID)
+			resp.SetXORMappedAddress(from.IP, from.Port)
+			resp.SetOtherAddress(net.ParseIP("127.0.0.2"), 1)
+			conn.WriteToUDP(stun.Encode(resp), from)
+		}
+	}()
+
+	client := newTestClient(t)
+	client.SetRetransmission(10*time.Millisecond, 2, 2)
+	report, err := client.DiscoverNAT(context.Background(), conn.LocalAddr().(*net.UDPAddr), NATOptions{})
+	require.NoError(t, err)
+
+	assert.Empty(t, report.Errors)
+	assert.Equal(t, BehaviorEndpointIndependent, report.Mapping)
+	assert.Equal(t, BehaviorAddressAndPortDependent, report.Filtering)
+}
+
+func TestClient_DiscoverNATLifetime(t *testing.T) {
+	// 模拟映射在第二轮空闲后过期：服务器只把第一个RESPONSE-PORT请求的响应发往指定端口
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		probes := 0
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			req, err := stun.Decode(buf[:n])
+			if err != nil {
+				continue
+			}
+			resp := stun.NewMessage(stun.MessageTypeBindingResponse, req.TransactionID)
+			resp.SetXORMappedAddress(from.IP, from.Port)
+			dest := from
+			if port, err := req.GetResponsePort(); err == nil {
+				if probes++; probes > 1 {
+					continue
+				}
+				dest = &net.UDPAddr{IP: from.IP, Port: port}
+			}
+			conn.WriteToUDP(stun.Encode(resp), dest)
+		}
+	}()
+
+	client := newTestClient(t)
+	client.SetRetransmission(10*time.Millisecond, 2, 2)
+	report, err := client.DiscoverNAT(context.Background(), conn.LocalAddr().(*net.UDPAddr), NATOptions{
+		Listen:        listenLoopback,
+		LifetimeStart: 10 * time.Millisecond,
+		LifetimeMax:   time.Second,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, Lifetime{Tested: true, Min: 10 * time.Millisecond, Max: 20 * time.Millisecond}, report.Lifetime)
+}
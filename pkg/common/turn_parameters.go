@@ -0,0 +1,17 @@
+package common
+
+// ICEServer 与WebRTC的RTCIceServer格式相同，可直接传给RTCPeerConnection
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// TURNCredentialsResponse 临时TURN凭证，字段与draft-uberti-behave-turn-rest一致
+type TURNCredentialsResponse struct {
+	Username   string      `json:"username"`
+	Password   string      `json:"password"`
+	TTL        int         `json:"ttl"` // 有效期，单位秒
+	URIs       []string    `json:"uris"`
+	ICEServers []ICEServer `json:"iceServers"`
+}
@@ -0,0 +1,87 @@
+package entry
+
+import "time"
+
+// Config 服务配置
+type Config struct {
+	HTTPAddr string // HTTP服务地址
+	STUNAddr string // STUN服务地址
+	// STUNTCPAddr STUN over TCP的监听地址，为空时只提供UDP
+	STUNTCPAddr string
+	// STUN over TLS：STUNTLSCertFile和STUNTLSKeyFile都不为空时在STUNTLSAddr上启用
+	STUNTLSAddr     string
+	STUNTLSCertFile string
+	STUNTLSKeyFile  string
+
+	// RFC 5780 NAT行为发现：STUNAlternatePort不为0时启用。
+	// STUNAlternateIP为空时只支持改变端口，指定时STUNAddr必须是具体的IP
+	STUNAlternateIP   string
+	STUNAlternatePort int
+
+	// STUNLegacy 兼容没有magic cookie的RFC 3489 Binding请求
+	STUNLegacy bool
+
+	// STUNCredentials 短期凭证的用户名和密码（如ICE的"ufrag:ufrag"和ice-pwd），
+	// 为空时携带USERNAME和MESSAGE-INTEGRITY的Binding请求一律返回401
+	STUNCredentials map[string]string
+
+	// ICE连通性检查应答（RFC 8445）：STUNICERole为"controlling"或"controlled"时启用，需同时配置STUNCredentials；
+	// STUNICETieBreaker为0时随机生成
+	STUNICERole       string
+	STUNICETieBreaker uint64
+
+	// 300 Try Alternate重定向：STUNAlternateServer不为空时启用，关闭前排空STUNDrainTimeout，
+	// 排空期间的请求全部重定向；STUNRedirectAlways为true时始终重定向，
+	// STUNRedirectRate大于0时每秒超出该数量的请求被重定向
+	STUNAlternateServer string
+	STUNRedirectAlways  bool
+	STUNRedirectRate    int
+	STUNDrainTimeout    time.Duration
+
+	// TURN：TURNRealm不为空时在STUN的端口上启用，使用TURNUsers中的用户名和密码认证。
+	// TURNRelayIP为空时使用接收请求的本地IP，此时STUNAddr必须是具体的IP；
+	// 分配的有效期不超过TURNMaxLifetime
+	TURNRealm       string
+	TURNRelayIP     string
+	TURNUsers       map[string]string
+	TURNMaxLifetime time.Duration
+
+	// TURN中继端口范围，TURNRelayPortMin为0时由系统分配临时端口；
+	// TURNUserQuota和TURNIPQuota为每个用户和每个来源IP同时持有的分配数上限，0表示不限制
+	TURNRelayPortMin int
+	TURNRelayPortMax int
+	TURNUserQuota    int
+	TURNIPQuota      int
+
+	// TURN中继带宽上限（字节/秒，双向合计），0表示不限制：TURNAllocationBandwidth为每个分配的上限，
+	// TURNCredentialBandwidth按用户名（临时凭证为其中的用户ID）覆盖每个分配的上限；TURNUserBandwidth为每个用户所有分配合计的上限
+	TURNAllocationBandwidth int
+	TURNUserBandwidth       int
+	TURNCredentialBandwidth map[string]int
+
+	// 临时TURN凭证（draft-uberti-behave-turn-rest）：TURNSecret不为空时TURN同时接受以其签发的凭证，
+	// 并在HTTP的/turn/credentials上签发有效期为TURNRESTTTL的凭证，调用需提供TURNRESTAPIKey。
+	// TURNURIs为返回给客户端的STUN和TURN服务器URI
+	TURNSecret     string
+	TURNRESTAPIKey string
+	TURNRESTTTL    time.Duration
+	TURNURIs       []string
+
+	// MetricsAPIKey 访问HTTP的/debug/vars运行指标需要提供的密钥，为空时不提供该接口
+	MetricsAPIKey string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		HTTPAddr:         ":8080",
+		STUNAddr:         ":3478",
+		STUNTCPAddr:      ":3478",
+		STUNTLSAddr:      ":5349",
+		STUNDrainTimeout: 10 * time.Second,
+		TURNMaxLifetime:  time.Hour,
+		TURNRelayPortMin: 49152,
+		TURNRelayPortMax: 65535,
+		TURNUserQuota:    10,
+		TURNRESTTTL:      24 * time.Hour,
+	}
+}
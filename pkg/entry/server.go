@@ -1,13 +1,21 @@
 package entry
 
 import (
+	"crypto/tls"
+	"fmt"
 	"log"
+	"math/rand/v2"
+	"net"
 	"sync"
+	"time"
 	"webRTCInfra/pkg/api/http"
+	"webRTCInfra/pkg/network/tcp"
 	"webRTCInfra/pkg/network/udp"
 	"webRTCInfra/pkg/network/websocket"
+	stunprotocol "webRTCInfra/pkg/protocol/stun"
 	"webRTCInfra/pkg/service/sdp"
 	"webRTCInfra/pkg/service/stun"
+	"webRTCInfra/pkg/service/turn"
 )
 
 type Server struct {
@@ -16,13 +24,14 @@ type Server struct {
 	udpServer   *udp.Server
 	sdpService  *sdp.Service
 	stunService *stun.Service
-	stunAddr    string
-	httpAddr    string
+	turnService *turn.Service       // 未启用TURN时为nil
+	drain       *stun.DrainRedirect // 未配置备用服务器时为nil
+	config      Config
 
 	wg sync.WaitGroup
 }
 
-func NewServer(httpAddr, stunAddr string) *Server {
+func NewServer(config Config) *Server {
 	// 1. 初始化WebSocket连接管理器（SDP服务用）
 	wsManager := websocket.NewManager()
 
@@ -30,42 +39,214 @@ func NewServer(httpAddr, stunAddr string) *Server {
 	sdpService := sdp.NewService(wsManager)
 	apiHandler := http.NewHandler(sdpService)
 
-	// 3. 初始化UDP服务器和STUN服务
-	udpServer := udp.NewService(stunAddr, nil)
+	// 3. 初始化UDP/TCP服务器和STUN服务
+	udpServer := udp.NewService(config.STUNAddr, nil)
 	stunService := stun.NewService(udpServer)
+	if config.STUNTCPAddr != "" {
+		stunService.SetTCPServer(tcp.NewService(config.STUNTCPAddr, stunprotocol.SplitMessages, nil))
+	}
 	return &Server{
 		wsManager:   wsManager,
 		apiHandler:  apiHandler,
 		udpServer:   udpServer,
 		sdpService:  sdpService,
 		stunService: stunService,
-		stunAddr:    stunAddr,
-		httpAddr:    httpAddr,
+		config:      config,
 	}
 }
 
 func (s *Server) Start() error {
+	s.apiHandler.SetMetricsAPIKey(s.config.MetricsAPIKey)
+	s.stunService.SetLegacyCompatibility(s.config.STUNLegacy)
+	if credentials := s.config.STUNCredentials; len(credentials) > 0 {
+		s.stunService.SetCredentialFunc(func(username string) (string, bool) {
+			password, ok := credentials[username]
+			return password, ok
+		})
+	}
+	if s.config.STUNTLSCertFile != "" && s.config.STUNTLSKeyFile != "" {
+		if err := s.setupTLS(); err != nil {
+			return err
+		}
+	}
+	if s.config.STUNICERole != "" {
+		if err := s.setupICE(); err != nil {
+			return err
+		}
+	}
+	if s.config.STUNAlternateServer != "" {
+		if err := s.setupRedirect(); err != nil {
+			return err
+		}
+	}
+	if s.config.TURNSecret != "" {
+		if s.config.TURNRESTAPIKey == "" {
+			return fmt.Errorf("turn secret requires a rest api key")
+		}
+		s.apiHandler.SetTURNCredentials(http.TURNCredentialConfig{
+			Secret: s.config.TURNSecret,
+			APIKey: s.config.TURNRESTAPIKey,
+			TTL:    s.config.TURNRESTTTL,
+			URIs:   s.config.TURNURIs,
+		})
+	}
+	if s.config.TURNRealm != "" {
+		if err := s.setupTURN(); err != nil {
+			return err
+		}
+	}
+	if s.config.STUNAlternatePort != 0 {
+		if err := s.stunService.SetBehaviorDiscovery(s.config.STUNAlternateIP, s.config.STUNAlternatePort); err != nil {
+			return err
+		}
+	}
 	if err := s.stunService.Start(); err != nil {
 		return err
 	}
-	log.Println("stun service started at", s.stunAddr)
+	log.Println("stun service started at", s.config.STUNAddr)
+	if s.config.STUNTCPAddr != "" {
+		log.Println("stun tcp service started at", s.config.STUNTCPAddr)
+	}
+	if s.stunService.TLSAddr() != nil {
+		log.Println("stun tls service started at", s.config.STUNTLSAddr)
+	}
+	if s.turnService != nil {
+		s.turnService.Start()
+		log.Printf("turn service started with realm %s", s.config.TURNRealm)
+	}
 
 	s.wg.Add(1)
 	go s.startHttpServer()
 	return nil
 }
 
+// setupTLS 加载证书并创建STUN over TLS监听
+func (s *Server) setupTLS() error {
+	cert, err := tls.LoadX509KeyPair(s.config.STUNTLSCertFile, s.config.STUNTLSKeyFile)
+	if err != nil {
+		return fmt.Errorf("load stun tls certificate: %w", err)
+	}
+	tlsServer := tcp.NewService(s.config.STUNTLSAddr, stunprotocol.SplitMessages, nil)
+	tlsServer.SetTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}})
+	s.stunService.SetTLSServer(tlsServer)
+	return nil
+}
+
+// setupICE 以配置的角色应答ICE连通性检查，记录对端提名的候选对
+func (s *Server) setupICE() error {
+	var role stunprotocol.Role
+	switch s.config.STUNICERole {
+	case "controlling":
+		role = stunprotocol.RoleControlling
+	case "controlled":
+		role = stunprotocol.RoleControlled
+	default:
+		return fmt.Errorf("invalid ice role %q, expected controlling or controlled", s.config.STUNICERole)
+	}
+	if len(s.config.STUNCredentials) == 0 {
+		return fmt.Errorf("ice role requires stun credentials")
+	}
+	tieBreaker := s.config.STUNICETieBreaker
+	if tieBreaker == 0 {
+		tieBreaker = rand.Uint64()
+	}
+	s.stunService.SetICEAgent(role, tieBreaker)
+	s.stunService.SetICECheckHandler(func(check stun.ICECheck) {
+		if check.UseCandidate {
+			log.Printf("ICE candidate pair with %s nominated by %s", check.Remote, check.Username)
+		}
+	})
+	return nil
+}
+
+// setupRedirect 按配置组合重定向策略，排空策略始终启用
+func (s *Server) setupRedirect() error {
+	s.drain = stun.NewDrainRedirect()
+	policies := []stun.RedirectPolicy{s.drain}
+	if s.config.STUNRedirectAlways {
+		policies = append(policies, stun.RedirectAlways)
+	}
+	if s.config.STUNRedirectRate > 0 {
+		policies = append(policies, stun.NewRateRedirect(s.config.STUNRedirectRate))
+	}
+	return s.stunService.SetAlternateServer(s.config.STUNAlternateServer, stun.RedirectAny(policies...))
+}
+
+// setupTURN 在STUN服务上启用TURN，使用配置中的静态用户和临时凭证认证
+func (s *Server) setupTURN() error {
+	if s.config.TURNRelayIP == "" {
+		// UDP套接字监听未指定的IP时无法从请求得知本地IP，UDP分配都会失败
+		addr, err := net.ResolveUDPAddr("udp", s.config.STUNAddr)
+		if err != nil {
+			return err
+		}
+		if addr.IP == nil || addr.IP.IsUnspecified() {
+			return fmt.Errorf("turn relay ip is required when stun listens on unspecified address %s", s.config.STUNAddr)
+		}
+	}
+	turnService := turn.NewService(s.stunService, s.config.TURNRealm)
+	if s.config.TURNRelayIP != "" {
+		if err := turnService.SetRelayIP(s.config.TURNRelayIP); err != nil {
+			return err
+		}
+	}
+	if s.config.TURNRelayPortMin != 0 {
+		if err := turnService.SetRelayPortRange(s.config.TURNRelayPortMin, s.config.TURNRelayPortMax); err != nil {
+			return err
+		}
+	}
+	turnService.SetMaxLifetime(s.config.TURNMaxLifetime)
+	turnService.SetAllocationQuota(s.config.TURNUserQuota, s.config.TURNIPQuota)
+	turnService.SetBandwidthLimit(s.config.TURNAllocationBandwidth, s.config.TURNUserBandwidth)
+	if bandwidth := s.config.TURNCredentialBandwidth; len(bandwidth) > 0 {
+		turnService.SetBandwidthFunc(func(username string) (int, bool) {
+			rate, ok := bandwidth[username]
+			return rate, ok
+		})
+	}
+	users := s.config.TURNUsers
+	var ephemeral turn.CredentialFunc
+	if s.config.TURNSecret != "" {
+		ephemeral = turn.EphemeralCredentialFunc(s.config.TURNSecret)
+	}
+	turnService.SetCredentialFunc(func(username string) (string, bool) {
+		if password, ok := users[username]; ok {
+			return password, true
+		}
+		if ephemeral != nil {
+			return ephemeral(username)
+		}
+		return "", false
+	})
+	s.turnService = turnService
+	return nil
+}
+
+// Drain 将后续的STUN请求重定向到备用服务器并等待STUNDrainTimeout，使客户端在关闭前迁移。
+// 未配置备用服务器时直接返回
+func (s *Server) Drain() {
+	if s.drain == nil {
+		return
+	}
+	log.Printf("draining stun service to %s for %v", s.config.STUNAlternateServer, s.config.STUNDrainTimeout)
+	s.drain.SetDraining(true)
+	time.Sleep(s.config.STUNDrainTimeout)
+}
+
 func (s *Server) startHttpServer() {
 	defer s.wg.Done()
 	r := http.NewRouter(s.apiHandler)
-	if err := r.Run(s.httpAddr); err != nil {
+	if err := r.Run(s.config.HTTPAddr); err != nil {
 		log.Printf("http server start error: %v", err)
 	}
-	log.Printf("server started at %s", s.httpAddr)
+	log.Printf("server started at %s", s.config.HTTPAddr)
 
 }
 
 func (s *Server) Close() {
+	if s.turnService != nil {
+		s.turnService.Close()
+	}
 	s.stunService.Close()
 	s.wg.Wait()
 	log.Println("server closed")
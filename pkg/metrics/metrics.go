@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+	"sync"
+)
+
+// 所有计数器注册在expvar的"webRTCInfra"下，通过HTTP服务的 /debug/vars 导出（需配置API密钥）
+var (
+	mu       sync.Mutex
+	registry = expvar.NewMap("webRTCInfra")
+)
+
+// NewCounter 返回名为name的计数器，同名计数器只创建一次，可在包级变量中直接声明
+func NewCounter(name string) *expvar.Int {
+	mu.Lock()
+	defer mu.Unlock()
+	if v, ok := registry.Get(name).(*expvar.Int); ok {
+		return v
+	}
+	v := new(expvar.Int)
+	registry.Set(name, v)
+	return v
+}
+
+// Handler 以JSON导出"webRTCInfra"下的计数器。
+// 不使用expvar.Handler，它还会导出包含命令行参数的cmdline和memstats
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte("{\"webRTCInfra\": " + registry.String() + "}\n"))
+	})
+}
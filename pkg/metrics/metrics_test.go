@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	NewCounter("test_counter").Add(3)
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/vars", nil))
+
+	var vars map[string]map[string]int64
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("invalid json %q: %v", w.Body.String(), err)
+	}
+	if len(vars) != 1 {
+		t.Errorf("exported %d vars, want only webRTCInfra", len(vars))
+	}
+	if got := vars["webRTCInfra"]["test_counter"]; got != 3 {
+		t.Errorf("test_counter = %d, want 3", got)
+	}
+}
@@ -0,0 +1,45 @@
+package tcp
+
+import (
+	"net"
+	"sync"
+)
+
+// Connection 封装TCP客户端连接
+type Connection struct {
+	Conn net.Conn
+
+	mu     sync.Mutex // 保证并发写入的消息不会交错
+	hijack func(conn net.Conn, buffered []byte)
+}
+
+func NewTCPConnection(conn net.Conn) *Connection {
+	return &Connection{Conn: conn}
+}
+
+// Write 写入一条完整的消息
+func (c *Connection) Write(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.Conn.Write(data)
+	return err
+}
+
+// Hijack 接管连接，之后服务器不再切分和分发该连接上的消息。
+// 需在onMessage回调中调用；回调返回后fn在新的协程中运行，buffered为已读取但尚未切分的字节，由fn负责关闭连接
+func (c *Connection) Hijack(fn func(conn net.Conn, buffered []byte)) {
+	c.hijack = fn
+}
+
+func (c *Connection) GetRemoteAddr() *net.TCPAddr {
+	return c.Conn.RemoteAddr().(*net.TCPAddr)
+}
+
+// LocalAddr 返回接受该连接的本地地址
+func (c *Connection) LocalAddr() *net.TCPAddr {
+	return c.Conn.LocalAddr().(*net.TCPAddr)
+}
+
+func (c *Connection) Close() {
+	c.Conn.Close()
+}
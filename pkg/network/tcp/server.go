@@ -0,0 +1,217 @@
+package tcp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+	"webRTCInfra/pkg/metrics"
+)
+
+var (
+	TCPTimeOut          = time.Minute * 5
+	TLSHandshakeTimeout = time.Second * 10
+)
+
+// tlsHandshakeFailures TLS握手失败的连接数，包括超时、证书被拒绝和非TLS流量
+var tlsHandshakeFailures = metrics.NewCounter("tls_handshake_failures")
+
+// MaxMessageSize 单条消息的最大长度：20字节STUN头 + 16位长度字段能表示的最大属性长度
+const MaxMessageSize = 20 + 0xFFFF
+
+// Server TCP服务器，负责接受连接、按split切分字节流并把每条消息交给业务层
+type Server struct {
+	addr      string
+	listener  net.Listener
+	split     bufio.SplitFunc
+	onMessage func(*Connection, []byte)
+	onClose   func(*Connection) // 连接关闭时调用，被接管的连接不调用
+	tlsConfig *tls.Config       // 非nil时在TCP之上使用TLS
+
+	mu      sync.Mutex
+	clients map[*Connection]struct{}
+	close   bool
+	wg      sync.WaitGroup
+}
+
+// NewService 创建TCP服务器。split从字节流中切分出完整的消息（如stun.SplitMessages），
+// 需自行处理一个报文段包含多条消息和消息跨多次读取的情况
+func NewService(addr string, split bufio.SplitFunc, onMessage func(*Connection, []byte)) *Server {
+	return &Server{
+		addr:      addr,
+		split:     split,
+		onMessage: onMessage,
+		clients:   make(map[*Connection]struct{}),
+	}
+}
+
+func (s *Server) SetOnMessage(fn func(*Connection, []byte)) {
+	s.onMessage = fn
+}
+
+// SetOnClose 设置连接关闭时的回调，需在Start之前调用。连接被Hijack接管后不再回调
+func (s *Server) SetOnClose(fn func(*Connection)) {
+	s.onClose = fn
+}
+
+// SetTLSConfig 启用TLS，需在Start之前调用
+func (s *Server) SetTLSConfig(config *tls.Config) {
+	s.tlsConfig = config
+}
+
+// Start 开始监听，未指定IP时与udp.Server一样监听双栈套接字
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+	s.listener = listener
+	s.close = false
+
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return nil
+}
+
+// LocalAddr 返回实际监听的地址，需在Start之后调用
+func (s *Server) LocalAddr() *net.TCPAddr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr().(*net.TCPAddr)
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.close
+			s.mu.Unlock()
+			if !closed {
+				log.Printf("accept tcp connection error: %v", err)
+			}
+			return
+		}
+
+		conn := NewTCPConnection(c)
+		s.mu.Lock()
+		if s.close {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.clients[conn] = struct{}{}
+		s.mu.Unlock()
+
+		log.Printf("tcp client %s connected", conn.GetRemoteAddr())
+		s.wg.Add(1)
+		go s.handleConnection(conn)
+	}
+}
+
+// handleConnection 循环读取并切分消息，连接空闲超过TCPTimeOut或数据无法切分时关闭连接
+func (s *Server) handleConnection(conn *Connection) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, conn)
+		s.mu.Unlock()
+		if conn.hijack == nil {
+			conn.Close()
+			if s.onClose != nil {
+				s.onClose(conn)
+			}
+		}
+	}()
+
+	if tlsConn, ok := conn.Conn.(*tls.Conn); ok {
+		// 主动完成握手，以便统计失败并限制握手时间
+		tlsConn.SetDeadline(time.Now().Add(TLSHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			tlsHandshakeFailures.Add(1)
+			log.Printf("tls handshake with %s failed: %v", conn.GetRemoteAddr(), err)
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+	}
+
+	reader := &hijackReader{conn: conn}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 4096), MaxMessageSize)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if conn.hijack != nil {
+			// 被接管后不再切分，取出缓冲区中剩余的字节
+			if len(data) == 0 {
+				return 0, nil, nil
+			}
+			return len(data), data, nil
+		}
+		return s.split(data, atEOF)
+	})
+
+	conn.Conn.SetReadDeadline(time.Now().Add(TCPTimeOut))
+	for scanner.Scan() {
+		if s.onMessage != nil {
+			// 消息引用scanner的缓冲区，回调返回前有效
+			s.onMessage(conn, scanner.Bytes())
+		}
+		if conn.hijack != nil {
+			var buffered []byte
+			for scanner.Scan() {
+				buffered = append(buffered, scanner.Bytes()...)
+			}
+			conn.Conn.SetReadDeadline(time.Time{})
+			go conn.hijack(conn.Conn, buffered)
+			return
+		}
+		conn.Conn.SetReadDeadline(time.Now().Add(TCPTimeOut))
+	}
+
+	err := scanner.Err()
+	var netErr net.Error
+	switch {
+	case err == nil:
+		log.Printf("tcp client %s disconnected", conn.GetRemoteAddr())
+	case errors.As(err, &netErr) && netErr.Timeout():
+		log.Printf("tcp client %s timeout, close connection", conn.GetRemoteAddr())
+	case !errors.Is(err, net.ErrClosed):
+		log.Printf("tcp client %s error: %v", conn.GetRemoteAddr(), err)
+	}
+}
+
+// hijackReader 连接被接管后返回io.EOF，使scanner只交出缓冲区中已有的字节而不再读取连接
+type hijackReader struct {
+	conn *Connection
+}
+
+func (r *hijackReader) Read(p []byte) (int, error) {
+	if r.conn.hijack != nil {
+		return 0, io.EOF
+	}
+	return r.conn.Conn.Read(p)
+}
+
+// Close 停止监听并关闭所有连接，已被接管的连接除外
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.close = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for conn := range s.clients {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	log.Println("tcp service closed")
+}
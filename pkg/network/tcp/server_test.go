@@ -0,0 +1,199 @@
+package tcp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+	"webRTCInfra/pkg/internal/testutil"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startEchoServer 启动按行切分消息并原样回写的服务器
+func startEchoServer(t *testing.T) *Server {
+	t.Helper()
+	server := NewService("127.0.0.1:0", bufio.ScanLines, func(conn *Connection, data []byte) {
+		conn.Write(append(append([]byte(nil), data...), '\n'))
+	})
+	require.NoError(t, server.Start())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestServer_Framing(t *testing.T) {
+	server := startEchoServer(t)
+	conn, err := net.Dial("tcp", server.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	readLine := func() string {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		return line
+	}
+
+	t.Run("一个报文段包含多条消息", func(t *testing.T) {
+		_, err := conn.Write([]byte("first\nsecond\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "first\n", readLine())
+		assert.Equal(t, "second\n", readLine())
+	})
+
+	t.Run("一条消息分多次到达", func(t *testing.T) {
+		_, err := conn.Write([]byte("par"))
+		require.NoError(t, err)
+		time.Sleep(20 * time.Millisecond)
+		_, err = conn.Write([]byte("tial\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "partial\n", readLine())
+	})
+}
+
+func TestServer_Close(t *testing.T) {
+	server := NewService("127.0.0.1:0", bufio.ScanLines, nil)
+	require.NoError(t, server.Start())
+
+	conn, err := net.Dial("tcp", server.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// 等待服务器接受连接后关闭，客户端应读到EOF
+	time.Sleep(20 * time.Millisecond)
+	server.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+
+	_, err = net.Dial("tcp", server.LocalAddr().String())
+	assert.Error(t, err)
+}
+
+func TestServer_OnClose(t *testing.T) {
+	server := NewService("127.0.0.1:0", bufio.ScanLines, nil)
+	closed := make(chan net.Addr, 1)
+	server.SetOnClose(func(conn *Connection) {
+		closed <- conn.GetRemoteAddr()
+	})
+	require.NoError(t, server.Start())
+	t.Cleanup(server.Close)
+
+	conn, err := net.Dial("tcp", server.LocalAddr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("hello\n"))
+	require.NoError(t, err)
+	conn.Close()
+
+	select {
+	case addr := <-closed:
+		assert.Equal(t, conn.LocalAddr().String(), addr.String())
+	case <-time.After(time.Second):
+		t.Fatal("onClose was not called")
+	}
+}
+
+func TestServer_TLS(t *testing.T) {
+	cert, err := testutil.SelfSignedCert("127.0.0.1")
+	require.NoError(t, err)
+
+	server := NewService("127.0.0.1:0", bufio.ScanLines, func(conn *Connection, data []byte) {
+		conn.Write(append(append([]byte(nil), data...), '\n'))
+	})
+	server.SetTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}})
+	require.NoError(t, server.Start())
+	t.Cleanup(server.Close)
+	addr := server.LocalAddr().String()
+
+	t.Run("握手成功后按消息回写", func(t *testing.T) {
+		pool := x509.NewCertPool()
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		require.NoError(t, err)
+		pool.AddCert(leaf)
+
+		conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool})
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("hello\n"))
+		require.NoError(t, err)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "hello\n", line)
+	})
+
+	t.Run("握手失败计入指标", func(t *testing.T) {
+		before := tlsHandshakeFailures.Value()
+
+		// 非TLS流量
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		conn.Write([]byte("not a tls client hello\n"))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		conn.Read(make([]byte, 1024))
+		conn.Close()
+
+		// 客户端不信任自签名证书
+		_, err = tls.Dial("tcp", addr, &tls.Config{})
+		assert.Error(t, err)
+
+		assert.Eventually(t, func() bool {
+			return tlsHandshakeFailures.Value()-before == 2
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestServer_Hijack(t *testing.T) {
+	hijacked := make(chan []byte, 1)
+	server := NewService("127.0.0.1:0", bufio.ScanLines, func(conn *Connection, data []byte) {
+		if string(data) != "hijack" {
+			conn.Write(append(append([]byte(nil), data...), '\n'))
+			return
+		}
+		conn.Write([]byte("ok\n"))
+		conn.Hijack(func(c net.Conn, buffered []byte) {
+			defer c.Close()
+			hijacked <- buffered
+			// 接管后原样回写，不再按行切分
+			buf := make([]byte, 64)
+			n, _ := c.Read(buf)
+			c.Write(buf[:n])
+		})
+	})
+	require.NoError(t, server.Start())
+
+	conn, err := net.Dial("tcp", server.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	// 与接管请求同一报文段中的后续字节交给接管者
+	_, err = conn.Write([]byte("echo\nhijack\nraw"))
+	require.NoError(t, err)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo\n", line)
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "ok\n", line)
+	select {
+	case buffered := <-hijacked:
+		assert.Equal(t, []byte("raw"), buffered)
+	case <-time.After(time.Second):
+		t.Fatal("connection was not hijacked")
+	}
+
+	// 服务器关闭不影响已被接管的连接
+	server.Close()
+	_, err = conn.Write([]byte("no newline"))
+	require.NoError(t, err)
+	buf := make([]byte, 64)
+	n, err := reader.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "no newline", string(buf[:n]))
+}
@@ -34,7 +34,7 @@ func (c *Connection) SavePacket(packet []byte) {
 	select {
 	case c.recvChan <- packet:
 	default:
-		packetPool.Put(packet) // 释放内存
+		putPacket(packet) // 释放内存
 		log.Println("udp recv queue full")
 	}
 }
@@ -47,6 +47,11 @@ func (c *Connection) GetRemoteAddr() *net.UDPAddr {
 	return c.addr
 }
 
+// LocalAddr 返回接收该客户端数据包的本地监听地址
+func (c *Connection) LocalAddr() *net.UDPAddr {
+	return c.Conn.LocalAddr().(*net.UDPAddr)
+}
+
 func (c *Connection) Close() {
 	if !c.close {
 		c.close = true
@@ -0,0 +1,88 @@
+package udp
+
+import (
+	"webRTCInfra/pkg/metrics"
+)
+
+// demuxDropped 首字节不属于任何已注册协议而被丢弃的数据包数
+var demuxDropped = metrics.NewCounter("udp_demux_dropped")
+
+// Protocol 同一UDP端口上复用的协议，按RFC 7983由数据包首字节区分
+type Protocol uint8
+
+const (
+	ProtocolUnknown     Protocol = iota
+	ProtocolSTUN                 // 首字节0-3
+	ProtocolZRTP                 // 首字节16-19
+	ProtocolDTLS                 // 首字节20-63
+	ProtocolChannelData          // 首字节64-127，TURN ChannelData
+	ProtocolRTP                  // 首字节128-191，RTP和RTCP
+
+	protocolCount
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolSTUN:
+		return "STUN"
+	case ProtocolZRTP:
+		return "ZRTP"
+	case ProtocolDTLS:
+		return "DTLS"
+	case ProtocolChannelData:
+		return "ChannelData"
+	case ProtocolRTP:
+		return "RTP/RTCP"
+	default:
+		return "unknown"
+	}
+}
+
+// Classify 按RFC 7983 7节的首字节范围判断数据包所属协议。
+// ChannelData覆盖通道号0x4000-0x7FFF（RFC 5766），即首字节64-127
+func Classify(packet []byte) Protocol {
+	if len(packet) == 0 {
+		return ProtocolUnknown
+	}
+	switch b := packet[0]; {
+	case b <= 3:
+		return ProtocolSTUN
+	case b >= 16 && b <= 19:
+		return ProtocolZRTP
+	case b >= 20 && b <= 63:
+		return ProtocolDTLS
+	case b >= 64 && b <= 127:
+		return ProtocolChannelData
+	case b >= 128 && b <= 191:
+		return ProtocolRTP
+	default:
+		return ProtocolUnknown
+	}
+}
+
+// Demux 协议分发器，作为Server的onPacket回调，把数据包交给对应协议的处理函数。
+// 没有注册处理函数的协议直接丢弃并计数
+type Demux struct {
+	handlers [protocolCount]func(*Connection, []byte)
+}
+
+func NewDemux() *Demux {
+	return &Demux{}
+}
+
+// Handle 注册协议的处理函数，需在Server启动之前调用。
+// 与onPacket相同，packet在处理函数返回后会被回收，需要保留时应复制
+func (d *Demux) Handle(p Protocol, fn func(*Connection, []byte)) {
+	if p > ProtocolUnknown && p < protocolCount {
+		d.handlers[p] = fn
+	}
+}
+
+// OnPacket 分发数据包，可直接传给NewService或SetOnPacket
+func (d *Demux) OnPacket(conn *Connection, packet []byte) {
+	if fn := d.handlers[Classify(packet)]; fn != nil {
+		fn(conn, packet)
+		return
+	}
+	demuxDropped.Add(1)
+}
@@ -0,0 +1,66 @@
+package udp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		first byte
+		want  Protocol
+	}{
+		{0x00, ProtocolSTUN},
+		{0x01, ProtocolSTUN},
+		{3, ProtocolSTUN},
+		{4, ProtocolUnknown},
+		{16, ProtocolZRTP},
+		{19, ProtocolZRTP},
+		{20, ProtocolDTLS},
+		{22, ProtocolDTLS}, // handshake
+		{63, ProtocolDTLS},
+		{0x40, ProtocolChannelData},
+		{79, ProtocolChannelData},
+		{0x7F, ProtocolChannelData},
+		{0x80, ProtocolRTP},
+		{191, ProtocolRTP},
+		{192, ProtocolUnknown},
+		{255, ProtocolUnknown},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Classify([]byte{tt.first, 0, 0, 0}), "first byte %d", tt.first)
+	}
+	assert.Equal(t, ProtocolUnknown, Classify(nil))
+}
+
+func TestDemux_OnPacket(t *testing.T) {
+	got := make(map[Protocol][]byte)
+	demux := NewDemux()
+	for _, p := range []Protocol{ProtocolSTUN, ProtocolDTLS, ProtocolRTP} {
+		p := p
+		demux.Handle(p, func(_ *Connection, packet []byte) {
+			got[p] = packet
+		})
+	}
+
+	stunPacket := []byte{0x00, 0x01, 0x00, 0x00}
+	dtlsPacket := []byte{22, 0xfe, 0xfd}
+	rtpPacket := []byte{0x80, 0x60, 0x00, 0x01}
+	demux.OnPacket(nil, stunPacket)
+	demux.OnPacket(nil, dtlsPacket)
+	demux.OnPacket(nil, rtpPacket)
+	assert.Equal(t, map[Protocol][]byte{
+		ProtocolSTUN: stunPacket,
+		ProtocolDTLS: dtlsPacket,
+		ProtocolRTP:  rtpPacket,
+	}, got)
+
+	// 未注册的协议和无法识别的数据包被丢弃并计数
+	before := demuxDropped.Value()
+	demux.OnPacket(nil, []byte{0x40, 0x00, 0x00, 0x04})
+	demux.OnPacket(nil, []byte{0xff})
+	demux.OnPacket(nil, nil)
+	assert.Equal(t, int64(3), demuxDropped.Value()-before)
+	assert.Len(t, got, 3)
+}
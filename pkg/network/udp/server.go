@@ -31,11 +31,16 @@ func (s *Server) SetOnPacket(fn func(*Connection, []byte)) {
 	s.onPacket = fn
 }
 
+// Start 开始监听。地址未指定IP（如":3478"、"0.0.0.0:3478"、"[::]:3478"）时监听双栈套接字，
+// IPv4和IPv6客户端共用同一端口，IPv4客户端地址以IPv4形式呈现
 func (s *Server) Start() error {
 	udpAddr, err := net.ResolveUDPAddr("udp", s.addr)
 	if err != nil {
 		return err
 	}
+	if udpAddr.IP.IsUnspecified() {
+		udpAddr.IP = nil
+	}
 
 	conn, err := net.ListenUDP("udp", udpAddr)
 	if err != nil {
@@ -49,15 +54,43 @@ func (s *Server) Start() error {
 	return nil
 }
 
+// LocalAddr 返回实际监听的地址，需在Start之后调用
+func (s *Server) LocalAddr() *net.UDPAddr {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// WriteTo 通过监听套接字向指定地址发送数据，用于从非接收端口发送响应
+func (s *Server) WriteTo(data []byte, addr *net.UDPAddr) error {
+	_, err := s.conn.WriteToUDP(data, addr)
+	return err
+}
+
+const (
+	// maxPacketSize UDP数据报的最大长度，读取缓冲区按此分配，避免截断超过MTU的数据包
+	maxPacketSize = 65535
+	// packetSize 缓存池中数据包的大小，覆盖以太网MTU，更大的数据包单独分配
+	packetSize = 1500
+)
+
 // 定义数据包缓存池
 var packetPool = sync.Pool{
 	New: func() interface{} {
-		return make([]byte, 1024)
+		return make([]byte, packetSize)
 	},
 }
 
+// putPacket 将数据包放回缓存池，单独分配的大数据包交给GC回收
+func putPacket(packet []byte) {
+	if cap(packet) == packetSize {
+		packetPool.Put(packet[:packetSize])
+	}
+}
+
 func (s *Server) ListenLoop() {
-	buf := make([]byte, 1024)
+	buf := make([]byte, maxPacketSize)
 	for !s.close {
 		n, clientAddr, err := s.conn.ReadFromUDP(buf)
 		if err != nil {
@@ -68,7 +101,12 @@ func (s *Server) ListenLoop() {
 		}
 
 		// 从缓存池中获取内存
-		packet := packetPool.Get().([]byte)[:n]
+		var packet []byte
+		if n <= packetSize {
+			packet = packetPool.Get().([]byte)[:n]
+		} else {
+			packet = make([]byte, n)
+		}
 		copy(packet, buf[:n])
 
 		conn := s.getOrCreateClient(clientAddr)
@@ -113,7 +151,7 @@ func (s *Server) handlePackets(conn *Connection) {
 				s.onPacket(conn, pocket) // 处理数据包
 				ticker.Reset(UDPTimeOut) // 重置超时计时器
 			}
-			packetPool.Put(pocket) // 处理完成后将内存放回缓存池
+			putPacket(pocket) // 处理完成后将内存放回缓存池
 
 		case <-ticker.C:
 			log.Printf("client %s timeout, close connection", clientAddr)
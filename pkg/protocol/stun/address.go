@@ -0,0 +1,174 @@
+package stun
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// SetMappedAddress 设置MAPPED-ADDRESS属性（RFC 3489兼容，地址不做异或）
+func (m *Message) SetMappedAddress(ip net.IP, port int) {
+	m.Attributes.Set(AttributeTypeMappedAddress, encodeAddress(ip, port))
+}
+
+// GetMappedAddress 解析MAPPED-ADDRESS属性
+func (m *Message) GetMappedAddress() (net.IP, int, error) {
+	return m.getAddress(AttributeTypeMappedAddress)
+}
+
+func (m *Message) SetXORMappedAddress(ip net.IP, port int) {
+	m.setXORAddress(AttributeTypeXORMappedAddress, ip, port)
+}
+
+// GetXORMappedAddress 解析XOR-MAPPED-ADDRESS属性
+func (m *Message) GetXORMappedAddress() (net.IP, int, error) {
+	return m.getXORAddress(AttributeTypeXORMappedAddress)
+}
+
+func (m *Message) setXORAddress(attrType uint16, ip net.IP, port int) {
+	value := encodeAddress(ip, port)
+	m.xorAddress(value)
+	m.Attributes.Set(attrType, value)
+}
+
+func (m *Message) getXORAddress(attrType uint16) (net.IP, int, error) {
+	value, ok := m.Attributes.Get(attrType)
+	if !ok {
+		return nil, 0, attributeNotFound(attrType)
+	}
+	if err := checkAddress(attrType, value); err != nil {
+		return nil, 0, err
+	}
+
+	// 拷贝后再异或，避免修改原始属性值
+	value = append([]byte(nil), value...)
+	m.xorAddress(value)
+	ip, port := decodeAddress(value)
+	return ip, port, nil
+}
+
+// SetAlternateServer 设置ALTERNATE-SERVER属性，与300 Try Alternate错误配合使用
+func (m *Message) SetAlternateServer(ip net.IP, port int) {
+	m.Attributes.Set(AttributeTypeAlternateServer, encodeAddress(ip, port))
+}
+
+// GetAlternateServer 解析ALTERNATE-SERVER属性
+func (m *Message) GetAlternateServer() (net.IP, int, error) {
+	return m.getAddress(AttributeTypeAlternateServer)
+}
+
+func (m *Message) getAddress(attrType uint16) (net.IP, int, error) {
+	value, ok := m.Attributes.Get(attrType)
+	if !ok {
+		return nil, 0, attributeNotFound(attrType)
+	}
+	if err := checkAddress(attrType, value); err != nil {
+		return nil, 0, err
+	}
+	ip, port := decodeAddress(value)
+	return ip, port, nil
+}
+
+// encodeAddress 编码地址类属性：1字节保留 + 1字节地址族 + 2字节端口 + 4/16字节IP
+func encodeAddress(ip net.IP, port int) []byte {
+	var family byte
+	var ipBytes []byte
+	if ip.To4() != nil {
+		family = IPV4
+		ipBytes = ip.To4()
+	} else {
+		family = IPV6
+		ipBytes = ip.To16()
+	}
+
+	value := make([]byte, 4+len(ipBytes))
+	value[1] = family
+	binary.BigEndian.PutUint16(value[2:4], uint16(port))
+	copy(value[4:], ipBytes)
+	return value
+}
+
+// checkAddress 校验地址类属性的地址族和长度
+func checkAddress(attrType uint16, value []byte) error {
+	if len(value) < 4 {
+		return fmt.Errorf("stun: %s too short: %d bytes", attributeName(attrType), len(value))
+	}
+
+	switch family := value[1]; family {
+	case IPV4:
+		if len(value) != 4+net.IPv4len {
+			return fmt.Errorf("stun: %s with IPv4 family has invalid length %d", attributeName(attrType), len(value))
+		}
+	case IPV6:
+		if len(value) != 4+net.IPv6len {
+			return fmt.Errorf("stun: %s with IPv6 family has invalid length %d", attributeName(attrType), len(value))
+		}
+	default:
+		return fmt.Errorf("stun: %s has unknown address family 0x%02x", attributeName(attrType), family)
+	}
+	return nil
+}
+
+// decodeAddress 解码已通过 checkAddress 校验的地址类属性
+func decodeAddress(value []byte) (net.IP, int) {
+	ip := make(net.IP, len(value)-4)
+	copy(ip, value[4:])
+	return ip, int(binary.BigEndian.Uint16(value[2:4]))
+}
+
+// xorAddress 对编码后的地址做异或，编码与解码使用同一操作：
+// 端口与magic cookie的高16位异或；IPv4地址与magic cookie异或，
+// IPv6地址与magic cookie和12字节事务ID拼接后的16字节异或（RFC 8489 14.2）
+func (m *Message) xorAddress(value []byte) {
+	magic := binary.BigEndian.Uint16(magicCookie[:2])
+	binary.BigEndian.PutUint16(value[2:4], binary.BigEndian.Uint16(value[2:4])^magic)
+
+	var key [16]byte
+	copy(key[:4], magicCookie)
+	copy(key[4:], m.TransactionID[:])
+
+	ip := value[4:]
+	for i := 0; i < len(ip); i++ {
+		ip[i] ^= key[i]
+	}
+}
+
+// SetResponseOrigin 设置RESPONSE-ORIGIN属性，即发送响应的地址（RFC 5780）
+func (m *Message) SetResponseOrigin(ip net.IP, port int) {
+	m.Attributes.Set(AttributeTypeResponseOrigin, encodeAddress(ip, port))
+}
+
+// GetResponseOrigin 解析RESPONSE-ORIGIN属性
+func (m *Message) GetResponseOrigin() (net.IP, int, error) {
+	return m.getAddress(AttributeTypeResponseOrigin)
+}
+
+// SetOtherAddress 设置OTHER-ADDRESS属性，即IP和端口都不同的备用地址（RFC 5780）
+func (m *Message) SetOtherAddress(ip net.IP, port int) {
+	m.Attributes.Set(AttributeTypeOtherAddress, encodeAddress(ip, port))
+}
+
+// GetOtherAddress 解析OTHER-ADDRESS属性
+func (m *Message) GetOtherAddress() (net.IP, int, error) {
+	return m.getAddress(AttributeTypeOtherAddress)
+}
+
+// SetSourceAddress 设置SOURCE-ADDRESS属性，即发送响应的地址（RFC 3489，对应RESPONSE-ORIGIN）
+func (m *Message) SetSourceAddress(ip net.IP, port int) {
+	m.Attributes.Set(AttributeTypeSourceAddress, encodeAddress(ip, port))
+}
+
+// GetSourceAddress 解析SOURCE-ADDRESS属性
+func (m *Message) GetSourceAddress() (net.IP, int, error) {
+	return m.getAddress(AttributeTypeSourceAddress)
+}
+
+// SetChangedAddress 设置CHANGED-ADDRESS属性，即响应CHANGE-REQUEST的备用地址（RFC 3489，对应OTHER-ADDRESS）
+func (m *Message) SetChangedAddress(ip net.IP, port int) {
+	m.Attributes.Set(AttributeTypeChangedAddress, encodeAddress(ip, port))
+}
+
+// GetChangedAddress 解析CHANGED-ADDRESS属性
+func (m *Message) GetChangedAddress() (net.IP, int, error) {
+	return m.getAddress(AttributeTypeChangedAddress)
+}
@@ -0,0 +1,132 @@
+package stun
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Attribute STUN属性（TLV格式中的类型和值）
+type Attribute struct {
+	Type  uint16
+	Value []byte
+}
+
+// Attributes 按报文中出现的顺序保存属性，同一类型的属性可以出现多次
+type Attributes []Attribute
+
+// Get 返回第一个指定类型的属性值
+func (a Attributes) Get(attrType uint16) ([]byte, bool) {
+	for _, attr := range a {
+		if attr.Type == attrType {
+			return attr.Value, true
+		}
+	}
+	return nil, false
+}
+
+// GetAll 按顺序返回所有指定类型的属性值
+func (a Attributes) GetAll(attrType uint16) [][]byte {
+	var values [][]byte
+	for _, attr := range a {
+		if attr.Type == attrType {
+			values = append(values, attr.Value)
+		}
+	}
+	return values
+}
+
+// Has 判断是否存在指定类型的属性
+func (a Attributes) Has(attrType uint16) bool {
+	_, ok := a.Get(attrType)
+	return ok
+}
+
+// Add 在末尾追加属性
+func (a *Attributes) Add(attrType uint16, value []byte) {
+	*a = append(*a, Attribute{Type: attrType, Value: value})
+}
+
+// Set 替换第一个指定类型的属性值并删除其余同类型属性，不存在时追加到末尾
+func (a *Attributes) Set(attrType uint16, value []byte) {
+	for i, attr := range *a {
+		if attr.Type == attrType {
+			(*a)[i].Value = value
+			rest := (*a)[i+1:]
+			rest.Remove(attrType)
+			*a = (*a)[:i+1+len(rest)]
+			return
+		}
+	}
+	a.Add(attrType, value)
+}
+
+// Remove 删除所有指定类型的属性
+func (a *Attributes) Remove(attrType uint16) {
+	attrs := (*a)[:0]
+	for _, attr := range *a {
+		if attr.Type != attrType {
+			attrs = append(attrs, attr)
+		}
+	}
+	*a = attrs
+}
+
+// UnknownRequired 返回不在known中的必须理解属性（0x0000-0x7FFF），
+// 结果按首次出现的顺序去重，用于构造420错误响应的UNKNOWN-ATTRIBUTES
+func (a Attributes) UnknownRequired(known ...uint16) []uint16 {
+	var unknown []uint16
+	for _, attr := range a {
+		if attr.Type >= 0x8000 || slices.Contains(known, attr.Type) || slices.Contains(unknown, attr.Type) {
+			continue
+		}
+		unknown = append(unknown, attr.Type)
+	}
+	return unknown
+}
+
+// ErrAttributeNotFound 表示消息中不存在要读取的属性
+var ErrAttributeNotFound = errors.New("stun: attribute not found")
+
+var attributeNames = map[uint16]string{
+	AttributeTypeMappedAddress:      "MAPPED-ADDRESS",
+	AttributeTypeChangeRequest:      "CHANGE-REQUEST",
+	AttributeTypeSourceAddress:      "SOURCE-ADDRESS",
+	AttributeTypeChangedAddress:     "CHANGED-ADDRESS",
+	AttributeTypeUsername:           "USERNAME",
+	AttributeTypeMessageIntegrity:   "MESSAGE-INTEGRITY",
+	AttributeTypeErrorCode:          "ERROR-CODE",
+	AttributeTypeUnknownAttributes:  "UNKNOWN-ATTRIBUTES",
+	AttributeTypeChannelNumber:      "CHANNEL-NUMBER",
+	AttributeTypeLifetime:           "LIFETIME",
+	AttributeTypeXORPeerAddress:     "XOR-PEER-ADDRESS",
+	AttributeTypeData:               "DATA",
+	AttributeTypeRealm:              "REALM",
+	AttributeTypeNonce:              "NONCE",
+	AttributeTypeXORRelayedAddress:  "XOR-RELAYED-ADDRESS",
+	AttributeTypeRequestedTransport: "REQUESTED-TRANSPORT",
+	AttributeTypeConnectionID:       "CONNECTION-ID",
+	AttributeTypeXORMappedAddress:   "XOR-MAPPED-ADDRESS",
+	AttributeTypePriority:           "PRIORITY",
+	AttributeTypeUseCandidate:       "USE-CANDIDATE",
+	AttributeTypeResponsePort:       "RESPONSE-PORT",
+	AttributeTypeSoftware:           "SOFTWARE",
+	AttributeTypeAlternateServer:    "ALTERNATE-SERVER",
+	AttributeTypeFingerprint:        "FINGERPRINT",
+	AttributeTypeICEControlled:      "ICE-CONTROLLED",
+	AttributeTypeICEControlling:     "ICE-CONTROLLING",
+	AttributeTypeResponseOrigin:     "RESPONSE-ORIGIN",
+	AttributeTypeOtherAddress:       "OTHER-ADDRESS",
+}
+
+// attributeName 返回属性名称，用于错误信息
+func attributeName(attrType uint16) string {
+	if name, ok := attributeNames[attrType]; ok {
+		return name
+	}
+	return fmt.Sprintf("attribute 0x%04x", attrType)
+}
+
+func attributeNotFound(attrType uint16) error {
+	return fmt.Errorf("%w: %s", ErrAttributeNotFound, attributeName(attrType))
+}
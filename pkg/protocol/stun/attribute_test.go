@@ -0,0 +1,271 @@
+package stun
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestAttributes(t *testing.T) {
+	var attrs Attributes
+	attrs.Add(0x0001, []byte{0x01})
+	attrs.Add(0x0002, []byte{0x02})
+	attrs.Add(0x0001, []byte{0x03})
+
+	if value, ok := attrs.Get(0x0001); !ok || !bytes.Equal(value, []byte{0x01}) {
+		t.Errorf("Get() = %X, %v, want 01, true", value, ok)
+	}
+	if _, ok := attrs.Get(0x0003); ok {
+		t.Error("Get() found non-existent attribute")
+	}
+	if values := attrs.GetAll(0x0001); len(values) != 2 || values[1][0] != 0x03 {
+		t.Errorf("GetAll() = %X, want [01 03]", values)
+	}
+
+	attrs.Set(0x0001, []byte{0x04})
+	if len(attrs) != 2 || attrs[0].Type != 0x0001 || attrs[0].Value[0] != 0x04 || attrs[1].Type != 0x0002 {
+		t.Errorf("Set() = %v, want [{1 [4]} {2 [2]}]", attrs)
+	}
+	attrs.Set(0x0003, []byte{0x05})
+	if len(attrs) != 3 || attrs[2].Type != 0x0003 {
+		t.Errorf("Set() should append missing attribute, got %v", attrs)
+	}
+
+	attrs.Remove(0x0001)
+	if attrs.Has(0x0001) || len(attrs) != 2 {
+		t.Errorf("Remove() = %v", attrs)
+	}
+}
+
+func TestAttributesUnknownRequired(t *testing.T) {
+	attrs := Attributes{
+		{Type: AttributeTypeUsername},
+		{Type: 0x0003},
+		{Type: 0x8022},
+		{Type: 0x7FFF},
+		{Type: 0x0003},
+	}
+	unknown := attrs.UnknownRequired(AttributeTypeUsername)
+	if len(unknown) != 2 || unknown[0] != 0x0003 || unknown[1] != 0x7FFF {
+		t.Errorf("UnknownRequired() = %v, want [3 7fff]", unknown)
+	}
+	if unknown := attrs.UnknownRequired(AttributeTypeUsername, 0x0003, 0x7FFF); len(unknown) != 0 {
+		t.Errorf("UnknownRequired() = %v, want []", unknown)
+	}
+}
+
+func TestEncodeAttributeOrder(t *testing.T) {
+	msg := NewMessage(MessageTypeBindingRequest, [12]byte{1, 2, 3})
+	for i := uint16(1); i <= 8; i++ {
+		msg.Attributes.Add(0x8000+i, []byte{byte(i)})
+	}
+
+	first := Encode(msg)
+	for i := 0; i < 10; i++ {
+		if !bytes.Equal(first, Encode(msg)) {
+			t.Fatal("Encode() is not deterministic")
+		}
+	}
+
+	decoded, err := Decode(first)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	for i, attr := range decoded.Attributes {
+		if attr.Type != 0x8000+uint16(i+1) {
+			t.Errorf("attribute %d: expected type 0x%04x, got 0x%04x", i, 0x8000+i+1, attr.Type)
+		}
+	}
+}
+
+func TestDecodeRepeatedAttributes(t *testing.T) {
+	msg := NewMessage(MessageTypeBindingRequest, [12]byte{1, 2, 3})
+	msg.Attributes.Add(0x8022, []byte("first"))
+	msg.Attributes.Add(0x8022, []byte("second"))
+
+	decoded, err := Decode(Encode(msg))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	values := decoded.Attributes.GetAll(0x8022)
+	if len(values) != 2 || string(values[0]) != "first" || string(values[1]) != "second" {
+		t.Errorf("GetAll() = %q, want [first second]", values)
+	}
+}
+
+// RFC 5769 2.2 示例IPv4响应
+const rfc5769IPv4Response = "0101003c2112a442b7e7a701bc34d686fa87dfae" +
+	"8022000b7465737420766563746f7220" +
+	"002000080001a147e112a643" +
+	"000800142b91f599fd9e90c38c7489f92af9ba53f06be7d7" +
+	"80280004c07d4c96"
+
+// RFC 5769 2.3 示例IPv6响应
+const rfc5769IPv6Response = "010100482112a442b7e7a701bc34d686fa87dfae" +
+	"8022000b7465737420766563746f7220" +
+	"002000140002a1470113a9faa5d3f179bc25f4b5bed2b9d9" +
+	"00080014a382954e4be67bf11784c97c8292c275bfe3ed41" +
+	"80280004c8fb0b4c"
+
+func TestTypedAttributesRFC5769(t *testing.T) {
+	req, err := Decode(mustDecodeHex(t, rfc5769Request))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if username, err := req.GetUsername(); err != nil || username != "evtj:h6vY" {
+		t.Errorf("GetUsername() = %q, %v", username, err)
+	}
+	if software, err := req.GetSoftware(); err != nil || software != "STUN test client" {
+		t.Errorf("GetSoftware() = %q, %v", software, err)
+	}
+
+	resp, err := Decode(mustDecodeHex(t, rfc5769IPv4Response))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	ip, port, err := resp.GetXORMappedAddress()
+	if err != nil {
+		t.Fatalf("GetXORMappedAddress() error = %v", err)
+	}
+	if !ip.Equal(net.ParseIP("192.0.2.1")) || port != 32853 {
+		t.Errorf("GetXORMappedAddress() = %v:%d, want 192.0.2.1:32853", ip, port)
+	}
+	if software, err := resp.GetSoftware(); err != nil || software != "test vector" {
+		t.Errorf("GetSoftware() = %q, %v", software, err)
+	}
+
+	resp, err = Decode(mustDecodeHex(t, rfc5769IPv6Response))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	ip, port, err = resp.GetXORMappedAddress()
+	if err != nil {
+		t.Fatalf("GetXORMappedAddress() error = %v", err)
+	}
+	if !ip.Equal(net.ParseIP("2001:db8:1234:5678:11:2233:4455:6677")) || port != 32853 {
+		t.Errorf("GetXORMappedAddress() = %v:%d, want [2001:db8:1234:5678:11:2233:4455:6677]:32853", ip, port)
+	}
+	if err := resp.CheckIntegrity([]byte(rfc5769Password)); err != nil {
+		t.Errorf("CheckIntegrity() error = %v", err)
+	}
+}
+
+func TestSetXORMappedAddressIPv6(t *testing.T) {
+	// 使用RFC 5769 2.3中的事务ID和地址，编码结果应与示例一致
+	resp := NewMessage(MessageTypeBindingResponse, [12]byte{0xb7, 0xe7, 0xa7, 0x01, 0xbc, 0x34, 0xd6, 0x86, 0xfa, 0x87, 0xdf, 0xae})
+	resp.SetXORMappedAddress(net.ParseIP("2001:db8:1234:5678:11:2233:4455:6677"), 32853)
+
+	value, _ := resp.Attributes.Get(AttributeTypeXORMappedAddress)
+	want := mustDecodeHex(t, "0002a1470113a9faa5d3f179bc25f4b5bed2b9d9")
+	if !bytes.Equal(value, want) {
+		t.Errorf("XOR-MAPPED-ADDRESS = %X, want %X", value, want)
+	}
+}
+
+func TestTypedAttributesRoundTrip(t *testing.T) {
+	msg := NewMessage(MessageTypeBindingErrorResponse, [12]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
+	msg.SetMappedAddress(net.ParseIP("10.0.0.1"), 1234)
+	msg.SetXORMappedAddress(net.ParseIP("192.168.1.1"), 5678)
+	msg.SetAlternateServer(net.ParseIP("10.0.0.2"), 3478)
+	msg.SetResponseOrigin(net.ParseIP("10.0.0.3"), 3479)
+	msg.SetOtherAddress(net.ParseIP("::1"), 3480)
+	msg.SetSourceAddress(net.ParseIP("10.0.0.4"), 3481)
+	msg.SetChangedAddress(net.ParseIP("10.0.0.5"), 3482)
+	msg.SetChangeRequest(true, false)
+	msg.SetResponsePort(40000)
+	msg.SetErrorCode(ErrorCodeUnknownAttribute, "Unknown Attribute")
+	msg.SetUnknownAttributes([]uint16{0x0003, 0x7FFF})
+	for _, set := range []func(string) error{msg.SetUsername, msg.SetRealm, msg.SetNonce, msg.SetSoftware} {
+		if err := set("value"); err != nil {
+			t.Fatalf("set text attribute: %v", err)
+		}
+	}
+
+	decoded, err := Decode(Encode(msg))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		get  func() (net.IP, int, error)
+		ip   string
+		port int
+	}{
+		{"MAPPED-ADDRESS", decoded.GetMappedAddress, "10.0.0.1", 1234},
+		{"XOR-MAPPED-ADDRESS", decoded.GetXORMappedAddress, "192.168.1.1", 5678},
+		{"ALTERNATE-SERVER", decoded.GetAlternateServer, "10.0.0.2", 3478},
+		{"RESPONSE-ORIGIN", decoded.GetResponseOrigin, "10.0.0.3", 3479},
+		{"OTHER-ADDRESS", decoded.GetOtherAddress, "::1", 3480},
+		{"SOURCE-ADDRESS", decoded.GetSourceAddress, "10.0.0.4", 3481},
+		{"CHANGED-ADDRESS", decoded.GetChangedAddress, "10.0.0.5", 3482},
+	} {
+		ip, port, err := tc.get()
+		if err != nil || !ip.Equal(net.ParseIP(tc.ip)) || port != tc.port {
+			t.Errorf("%s = %v:%d, %v, want %s:%d", tc.name, ip, port, err, tc.ip, tc.port)
+		}
+	}
+
+	for _, get := range []func() (string, error){decoded.GetUsername, decoded.GetRealm, decoded.GetNonce, decoded.GetSoftware} {
+		if text, err := get(); err != nil || text != "value" {
+			t.Errorf("get text attribute = %q, %v", text, err)
+		}
+	}
+
+	if changeIP, changePort, err := decoded.GetChangeRequest(); err != nil || !changeIP || changePort {
+		t.Errorf("GetChangeRequest() = %v, %v, %v, want true, false", changeIP, changePort, err)
+	}
+	if port, err := decoded.GetResponsePort(); err != nil || port != 40000 {
+		t.Errorf("GetResponsePort() = %d, %v, want 40000", port, err)
+	}
+
+	code, reason, err := decoded.GetErrorCode()
+	if err != nil || code != ErrorCodeUnknownAttribute || reason != "Unknown Attribute" {
+		t.Errorf("GetErrorCode() = %d, %q, %v", code, reason, err)
+	}
+
+	unknown, err := decoded.GetUnknownAttributes()
+	if err != nil || len(unknown) != 2 || unknown[0] != 0x0003 || unknown[1] != 0x7FFF {
+		t.Errorf("GetUnknownAttributes() = %v, %v", unknown, err)
+	}
+}
+
+func TestTypedAttributesErrors(t *testing.T) {
+	msg := NewMessage(MessageTypeBindingRequest, [12]byte{})
+
+	if _, _, err := msg.GetXORMappedAddress(); !errors.Is(err, ErrAttributeNotFound) {
+		t.Errorf("expected ErrAttributeNotFound, got %v", err)
+	}
+	if _, err := msg.GetUsername(); !errors.Is(err, ErrAttributeNotFound) {
+		t.Errorf("expected ErrAttributeNotFound, got %v", err)
+	}
+
+	if err := msg.SetUsername(strings.Repeat("a", 513)); err == nil {
+		t.Error("expected error for USERNAME longer than 512 bytes")
+	}
+	if err := msg.SetRealm(strings.Repeat("域", 128)); err == nil {
+		t.Error("expected error for REALM with 128 characters")
+	}
+	if err := msg.SetSoftware(strings.Repeat("域", 127)); err != nil {
+		t.Errorf("SetSoftware() with 127 characters error = %v", err)
+	}
+
+	msg.Attributes.Add(AttributeTypeMappedAddress, []byte{0x00, IPV4, 0x00, 0x01, 0x7F})
+	if _, _, err := msg.GetMappedAddress(); err == nil {
+		t.Error("expected error for truncated IPv4 MAPPED-ADDRESS")
+	}
+	msg.Attributes.Add(AttributeTypeAlternateServer, []byte{0x00, 0x03, 0x00, 0x01, 0x7F, 0x00, 0x00, 0x01})
+	if _, _, err := msg.GetAlternateServer(); err == nil {
+		t.Error("expected error for unknown address family")
+	}
+	msg.Attributes.Add(AttributeTypeErrorCode, []byte{0x00, 0x00, 0x02, 0x00})
+	if _, _, err := msg.GetErrorCode(); err == nil {
+		t.Error("expected error for ERROR-CODE class 2")
+	}
+	msg.Attributes.Add(AttributeTypeUnknownAttributes, []byte{0x00, 0x01, 0x00})
+	if _, err := msg.GetUnknownAttributes(); err == nil {
+		t.Error("expected error for odd UNKNOWN-ATTRIBUTES length")
+	}
+}
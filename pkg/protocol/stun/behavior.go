@@ -0,0 +1,55 @@
+package stun
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// CHANGE-REQUEST 标志位（RFC 5780 7.2）
+const (
+	changeIPFlag   = 0x04
+	changePortFlag = 0x02
+)
+
+// SetChangeRequest 设置CHANGE-REQUEST属性，要求服务器从不同的IP和/或端口发送响应
+func (m *Message) SetChangeRequest(changeIP, changePort bool) {
+	var flags byte
+	if changeIP {
+		flags |= changeIPFlag
+	}
+	if changePort {
+		flags |= changePortFlag
+	}
+	m.Attributes.Set(AttributeTypeChangeRequest, []byte{0x00, 0x00, 0x00, flags})
+}
+
+// GetChangeRequest 解析CHANGE-REQUEST属性
+func (m *Message) GetChangeRequest() (changeIP, changePort bool, err error) {
+	value, ok := m.Attributes.Get(AttributeTypeChangeRequest)
+	if !ok {
+		return false, false, attributeNotFound(AttributeTypeChangeRequest)
+	}
+	if len(value) != 4 {
+		return false, false, fmt.Errorf("stun: CHANGE-REQUEST has invalid length %d", len(value))
+	}
+	return value[3]&changeIPFlag != 0, value[3]&changePortFlag != 0, nil
+}
+
+// SetResponsePort 设置RESPONSE-PORT属性，要求服务器把响应发往请求来源IP的该端口（RFC 5780 7.5）
+func (m *Message) SetResponsePort(port int) {
+	value := make([]byte, 4) // 端口之后是2字节填充
+	binary.BigEndian.PutUint16(value, uint16(port))
+	m.Attributes.Set(AttributeTypeResponsePort, value)
+}
+
+// GetResponsePort 解析RESPONSE-PORT属性
+func (m *Message) GetResponsePort() (int, error) {
+	value, ok := m.Attributes.Get(AttributeTypeResponsePort)
+	if !ok {
+		return 0, attributeNotFound(AttributeTypeResponsePort)
+	}
+	if len(value) != 4 {
+		return 0, fmt.Errorf("stun: RESPONSE-PORT has invalid length %d", len(value))
+	}
+	return int(binary.BigEndian.Uint16(value)), nil
+}
@@ -0,0 +1,65 @@
+package stun
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// 可以绑定的通道号范围，ChannelData消息的最高两位因此为01
+const (
+	MinChannelNumber uint16 = 0x4000
+	MaxChannelNumber uint16 = 0x7FFF
+)
+
+// ChannelData头部：2字节通道号 + 2字节数据长度
+const channelDataHeaderSize = 4
+
+// IsChannelData 判断数据包是否为TURN ChannelData消息（最高两位为01）
+func IsChannelData(b []byte) bool {
+	return len(b) > 0 && b[0]&0xC0 == 0x40
+}
+
+// AppendChannelData 将ChannelData消息追加到dst（RFC 8656 12.4）。
+// 数据按4字节填充，TCP上必须填充，UDP上接收方忽略填充
+func AppendChannelData(dst []byte, number uint16, data []byte) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, number)
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(data)))
+	dst = append(dst, data...)
+	for i := padding(len(data)); i > 0; i-- {
+		dst = append(dst, 0)
+	}
+	return dst
+}
+
+// DecodeChannelData 解析ChannelData消息，返回的data直接引用b
+func DecodeChannelData(b []byte) (number uint16, data []byte, err error) {
+	if len(b) < channelDataHeaderSize {
+		return 0, nil, ErrPacketTooShort
+	}
+	number = binary.BigEndian.Uint16(b[0:2])
+	if number < MinChannelNumber || number > MaxChannelNumber {
+		return 0, nil, fmt.Errorf("stun: invalid channel number 0x%04x", number)
+	}
+	n := int(binary.BigEndian.Uint16(b[2:4]))
+	if len(b) < channelDataHeaderSize+n {
+		return 0, nil, fmt.Errorf("stun: channel data length %d exceeds packet size %d", n, len(b)-channelDataHeaderSize)
+	}
+	return number, b[channelDataHeaderSize : channelDataHeaderSize+n], nil
+}
+
+// SetChannelNumber 设置CHANNEL-NUMBER属性：2字节通道号 + 2字节保留
+func (m *Message) SetChannelNumber(number uint16) {
+	m.Attributes.Set(AttributeTypeChannelNumber, binary.BigEndian.AppendUint32(nil, uint32(number)<<16))
+}
+
+// GetChannelNumber 解析CHANNEL-NUMBER属性，不检查通道号范围
+func (m *Message) GetChannelNumber() (uint16, error) {
+	value, ok := m.Attributes.Get(AttributeTypeChannelNumber)
+	if !ok {
+		return 0, attributeNotFound(AttributeTypeChannelNumber)
+	}
+	if len(value) != 4 {
+		return 0, fmt.Errorf("stun: CHANNEL-NUMBER has invalid length %d", len(value))
+	}
+	return binary.BigEndian.Uint16(value[0:2]), nil
+}
@@ -0,0 +1,90 @@
+package stun
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestChannelData(t *testing.T) {
+	encoded := AppendChannelData(nil, 0x4001, []byte("hello"))
+	want := []byte{0x40, 0x01, 0x00, 0x05, 'h', 'e', 'l', 'l', 'o', 0, 0, 0}
+	if !bytes.Equal(encoded, want) {
+		t.Fatalf("AppendChannelData() = %X, want %X", encoded, want)
+	}
+	if !IsChannelData(encoded) {
+		t.Error("IsChannelData() = false")
+	}
+	if IsChannelData(Encode(NewMessage(MessageTypeBindingRequest, [12]byte{}))) {
+		t.Error("IsChannelData() = true for STUN message")
+	}
+
+	// 带填充和不带填充都能解析
+	for _, b := range [][]byte{encoded, encoded[:9]} {
+		number, data, err := DecodeChannelData(b)
+		if err != nil || number != 0x4001 || string(data) != "hello" {
+			t.Errorf("DecodeChannelData(%X) = %x, %q, %v", b, number, data, err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"头部不完整", []byte{0x40, 0x00, 0x00}},
+		{"长度超出报文", []byte{0x40, 0x00, 0x00, 0x08, 1, 2, 3, 4}},
+		{"通道号超出范围", []byte{0x80, 0x00, 0x00, 0x00}},
+	}
+	for _, tt := range tests {
+		if _, _, err := DecodeChannelData(tt.data); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestChannelNumber(t *testing.T) {
+	msg := NewMessage(NewMessageType(MethodChannelBind, ClassRequest), [12]byte{1})
+	msg.SetChannelNumber(0x7FFF)
+	decoded, err := Decode(Encode(msg))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	value, _ := decoded.Attributes.Get(AttributeTypeChannelNumber)
+	if !bytes.Equal(value, []byte{0x7F, 0xFF, 0, 0}) {
+		t.Errorf("CHANNEL-NUMBER = %X", value)
+	}
+	if number, err := decoded.GetChannelNumber(); err != nil || number != 0x7FFF {
+		t.Errorf("GetChannelNumber() = %x, %v", number, err)
+	}
+
+	empty := NewMessage(NewMessageType(MethodChannelBind, ClassRequest), [12]byte{})
+	if _, err := empty.GetChannelNumber(); !errors.Is(err, ErrAttributeNotFound) {
+		t.Errorf("GetChannelNumber() error = %v, want ErrAttributeNotFound", err)
+	}
+}
+
+func TestSplitChannelData(t *testing.T) {
+	channel := AppendChannelData(nil, 0x4000, []byte("abcde"))
+	stun := Encode(NewMessage(MessageTypeBindingRequest, [12]byte{1}))
+	stream := append(append([]byte(nil), channel...), stun...)
+
+	// ChannelData按4字节填充后切分，token不含填充
+	advance, token, err := SplitMessages(stream, false)
+	if err != nil || advance != len(channel) || !bytes.Equal(token, channel[:9]) {
+		t.Fatalf("SplitMessages() = %d, %X, %v", advance, token, err)
+	}
+	advance, token, err = SplitMessages(stream[advance:], false)
+	if err != nil || advance != len(stun) || !bytes.Equal(token, stun) {
+		t.Fatalf("SplitMessages() = %d, %X, %v, want STUN message", advance, token, err)
+	}
+
+	// 填充尚未读完时等待更多数据
+	for _, n := range []int{2, 9, 11} {
+		if advance, token, err := SplitMessages(channel[:n], false); advance != 0 || token != nil || err != nil {
+			t.Errorf("SplitMessages(%d bytes) = %d, %X, %v, want need more data", n, advance, token, err)
+		}
+	}
+	if _, _, err := SplitMessages(channel[:9], true); err == nil {
+		t.Error("expected error for truncated channel data at EOF")
+	}
+}
@@ -3,100 +3,271 @@ package stun
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 )
 
 var magicCookie = []byte{0x21, 0x12, 0xa4, 0x42}
 
+// Encode 编码消息，返回新分配的缓冲区
 func Encode(msg *Message) []byte {
-	// 计算属性部分的长度
-	var attrBytes []byte
-	for attrType, value := range msg.Attributes {
-		// 属性长度
-		attrLen := len(value)
-		padLen := attrLen
-		if padLen%4 != 0 {
-			padLen += 4 - padLen%4
+	return AppendEncode(make([]byte, 0, msg.encodedLen()), msg)
+}
+
+// AppendEncode 将消息编码后追加到dst末尾，返回追加后的切片。
+// dst容量足够时不会分配内存，可配合缓冲池在高并发下复用缓冲区
+func AppendEncode(dst []byte, msg *Message) []byte {
+	start := len(dst)
+
+	// 消息头部，长度字段在属性写完后回填
+	dst = binary.BigEndian.AppendUint16(dst, msg.Type)
+	dst = append(dst, 0, 0)
+	if msg.Legacy {
+		dst = append(dst, msg.LegacyPrefix[:]...)
+	} else {
+		dst = append(dst, magicCookie...)
+	}
+	dst = append(dst, msg.TransactionID[:]...)
+
+	for _, attr := range msg.Attributes {
+		// MESSAGE-INTEGRITY 和 FINGERPRINT 需要在最后重新计算
+		if attr.Type == AttributeTypeMessageIntegrity && msg.IntegrityKey != nil {
+			continue
 		}
+		if attr.Type == AttributeTypeFingerprint && msg.Fingerprint {
+			continue
+		}
+		dst = appendAttribute(dst, attr.Type, attr.Value)
+	}
 
-		attrHeader := make([]byte, 4)
-		binary.BigEndian.PutUint16(attrHeader[:2], attrType)
-		binary.BigEndian.PutUint16(attrHeader[2:], uint16(attrLen))
-		attrBytes = append(attrBytes, attrHeader...)
-		attrBytes = append(attrBytes, value...)
+	if msg.IntegrityKey != nil {
+		b := dst[start:]
+		dst = appendAttributeHeader(dst, AttributeTypeMessageIntegrity, messageIntegritySize)
+		dst = messageIntegrity(dst, b, msg.IntegrityKey)
+	}
+	// FINGERPRINT 必须是最后一个属性，计算时长度字段需包含其自身
+	if msg.Fingerprint {
+		binary.BigEndian.PutUint16(dst[start+2:], uint16(len(dst)-start-20+4+fingerprintSize))
+		crc := fingerprint(dst[start:])
+		dst = appendAttributeHeader(dst, AttributeTypeFingerprint, fingerprintSize)
+		dst = binary.BigEndian.AppendUint32(dst, crc)
+	}
 
-		// 四字节对齐
-		if padLen > attrLen {
-			attrBytes = append(attrBytes, bytes.Repeat([]byte{0}, padLen-attrLen)...)
+	binary.BigEndian.PutUint16(dst[start+2:], uint16(len(dst)-start-20))
+	return dst
+}
+
+// encodedLen 计算编码后的消息长度，用于预分配缓冲区
+func (m *Message) encodedLen() int {
+	n := 20
+	for _, attr := range m.Attributes {
+		if attr.Type == AttributeTypeMessageIntegrity && m.IntegrityKey != nil {
+			continue
 		}
+		if attr.Type == AttributeTypeFingerprint && m.Fingerprint {
+			continue
+		}
+		n += 4 + len(attr.Value) + padding(len(attr.Value))
+	}
+	if m.IntegrityKey != nil {
+		n += 4 + messageIntegritySize
 	}
+	if m.Fingerprint {
+		n += 4 + fingerprintSize
+	}
+	return n
+}
+
+// padding 返回属性值按4字节对齐需要填充的字节数
+func padding(n int) int {
+	return (4 - n%4) % 4
+}
 
-	// 消息头部
-	header := make([]byte, 20)
-	binary.BigEndian.PutUint16(header[:2], msg.Type)
-	binary.BigEndian.PutUint16(header[2:4], uint16(len(attrBytes)))
-	copy(header[4:8], magicCookie)
-	copy(header[8:20], msg.TransactionID[:])
+var zeroPadding [3]byte
 
-	return append(header, attrBytes...)
+func appendAttributeHeader(b []byte, attrType uint16, attrLen int) []byte {
+	b = binary.BigEndian.AppendUint16(b, attrType)
+	return binary.BigEndian.AppendUint16(b, uint16(attrLen))
 }
 
-func Decode(date []byte) (*Message, error) {
-	if len(date) < 20 {
-		return nil, fmt.Errorf("stun: packet too short")
+// appendAttribute 按TLV格式追加属性，并按4字节对齐填充
+func appendAttribute(b []byte, attrType uint16, value []byte) []byte {
+	b = appendAttributeHeader(b, attrType, len(value))
+	b = append(b, value...)
+
+	// 四字节对齐
+	return append(b, zeroPadding[:padding(len(value))]...)
+}
+
+var (
+	ErrPacketTooShort      = errors.New("stun: packet too short")
+	ErrMagicCookieMismatch = errors.New("stun: magic cookie mismatch")
+)
+
+// DecodeHeader 解析并校验20字节的STUN消息头，不解析属性。
+// 可用于在属性解析失败时判断报文是否为需要回复错误响应的STUN请求
+func DecodeHeader(date []byte) (msgType uint16, transactionID [12]byte, err error) {
+	if err := checkHeader(date); err != nil {
+		return 0, transactionID, err
 	}
 
-	msgType := binary.BigEndian.Uint16(date[0:2])
-	msgLen := binary.BigEndian.Uint16(date[2:4])
-	cookie := date[4:8]
+	// 校验magicCookie
+	if !bytes.Equal(date[4:8], magicCookie) {
+		return 0, transactionID, ErrMagicCookieMismatch
+	}
 
 	// 获取事务ID，通过拷贝的方式，避免修改原始数据
-	var transactionID [12]byte
 	copy(transactionID[:], date[8:20])
+	return binary.BigEndian.Uint16(date[0:2]), transactionID, nil
+}
 
-	// 校验magicCookie
-	if !bytes.Equal(cookie, magicCookie) {
-		return nil, fmt.Errorf("stun: magic cookie mismatch")
+// checkHeader 校验头部长度和最高两位，不校验magic cookie
+func checkHeader(date []byte) error {
+	if len(date) < 20 {
+		return ErrPacketTooShort
 	}
 
-	// 校验消息长度
-	if int(msgLen)+20 != len(date) {
-		return nil, fmt.Errorf("stun: message length mismatch")
+	// STUN消息的最高两位必须为0
+	if date[0]&0xC0 != 0 {
+		return fmt.Errorf("stun: invalid leading bits 0x%02x", date[0]>>6)
 	}
+	return nil
+}
 
-	msg := &Message{
-		Type:          msgType,
-		TransactionID: transactionID,
-		Attributes:    make(map[uint16][]byte),
+// Decode 解码报文，解码结果不引用date
+func Decode(date []byte) (*Message, error) {
+	msg := &Message{}
+	// 拷贝一份原始报文，使解码结果不受调用方复用缓冲区的影响
+	if err := DecodeInto(msg, append([]byte(nil), date...)); err != nil {
+		return nil, err
 	}
+	return msg, nil
+}
+
+// DecodeInto 将报文解码到msg中，msg原有内容会被清空，属性列表的底层数组会被复用。
+// 解码出的属性值直接指向data而不做拷贝，data被修改或复用前需处理完毕。
+// 返回错误时msg的内容不确定
+func DecodeInto(msg *Message, data []byte) error {
+	return decodeInto(msg, data, false)
+}
+
+// DecodeLegacyInto 与DecodeInto相同，但同时接受没有magic cookie的RFC 3489消息，
+// 此时msg.Legacy为true，magic cookie位置的4字节保存在msg.LegacyPrefix中
+func DecodeLegacyInto(msg *Message, data []byte) error {
+	return decodeInto(msg, data, true)
+}
+
+func decodeInto(msg *Message, data []byte, allowLegacy bool) error {
+	if err := checkHeader(data); err != nil {
+		return err
+	}
+	legacy := !bytes.Equal(data[4:8], magicCookie)
+	if legacy && !allowLegacy {
+		return ErrMagicCookieMismatch
+	}
+	msgLen := binary.BigEndian.Uint16(data[2:4])
 
-	var attributeDate = make([]byte, msgLen)
-	copy(attributeDate, date[20:])
+	// 校验消息长度
+	if int(msgLen)+20 != len(data) {
+		return fmt.Errorf("stun: message length mismatch")
+	}
+
+	msg.Reset()
+	msg.Type = binary.BigEndian.Uint16(data[0:2])
+	copy(msg.TransactionID[:], data[8:20])
+	if legacy {
+		msg.Legacy = true
+		copy(msg.LegacyPrefix[:], data[4:8])
+	}
+	msg.raw = data
 
+	attributeDate := data[20:]
 	offset := 0
 	// 属性值可能存在一个或多个
 	for offset < len(attributeDate) {
 		if offset+4 > len(attributeDate) {
-			return nil, fmt.Errorf("stun: attribute too short")
+			return fmt.Errorf("stun: attribute too short")
 		}
 
 		attrType := binary.BigEndian.Uint16(attributeDate[offset : offset+2])
-		attrLen := binary.BigEndian.Uint16(attributeDate[offset+2 : offset+4])
+		attrLen := int(binary.BigEndian.Uint16(attributeDate[offset+2 : offset+4]))
 		offset += 4
 
-		if offset+int(attrLen) > len(attributeDate) {
-			return nil, fmt.Errorf("stun: attribute length mismatch")
+		if offset+attrLen > len(attributeDate) {
+			return fmt.Errorf("stun: attribute length mismatch")
 		}
+		// 限制容量，避免调用方追加数据时覆盖后续属性
+		value := attributeDate[offset : offset+attrLen : offset+attrLen]
 
-		var value = make([]byte, attrLen)
-		copy(value, attributeDate[offset:offset+int(attrLen)])
-		msg.Attributes[attrType] = value
+		if attrType == AttributeTypeFingerprint {
+			// FINGERPRINT 必须是最后一个属性
+			if end := offset + attrLen; end+padding(end) != len(attributeDate) {
+				return fmt.Errorf("stun: fingerprint is not the last attribute")
+			}
+			if err := checkFingerprint(data[:20+offset-4], value); err != nil {
+				return err
+			}
+		}
+
+		// MESSAGE-INTEGRITY 之后的属性（FINGERPRINT除外）不受完整性保护，直接忽略
+		if msg.integrityOffset == 0 || attrType == AttributeTypeFingerprint {
+			msg.Attributes.Add(attrType, value)
+			if attrType == AttributeTypeMessageIntegrity {
+				msg.integrityOffset = 20 + offset - 4
+			}
+		}
 
 		// 属性长度按4个字节对其
-		offset += int(attrLen)
-		if pad := offset % 4; pad != 0 {
-			offset += 4 - pad
+		offset += attrLen + padding(attrLen)
+	}
+	return nil
+}
+
+// SplitMessages 按消息头中的长度字段从TCP字节流中切分出完整的STUN消息（RFC 8489 6.2.2），
+// 以及最高两位为01的TURN ChannelData消息（RFC 8656 12.5，TCP上按4字节填充），
+// 可作为bufio.Scanner的SplitFunc。数据不足一条消息时等待更多数据；
+// 最高两位为10或11时字节流已无法重新同步，返回错误。ChannelData的token不含填充
+func SplitMessages(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+	if IsChannelData(data) {
+		return splitChannelData(data, atEOF)
+	}
+	if data[0]&0xC0 != 0 {
+		return 0, nil, fmt.Errorf("stun: invalid leading bits 0x%02x", data[0]>>6)
+	}
+	if len(data) < 20 {
+		if atEOF {
+			return 0, nil, ErrPacketTooShort
 		}
+		return 0, nil, nil
 	}
-	return msg, nil
+
+	n := 20 + int(binary.BigEndian.Uint16(data[2:4]))
+	if len(data) < n {
+		if atEOF {
+			return 0, nil, fmt.Errorf("stun: message truncated")
+		}
+		return 0, nil, nil
+	}
+	return n, data[:n:n], nil
+}
+
+func splitChannelData(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if len(data) < channelDataHeaderSize {
+		if atEOF {
+			return 0, nil, ErrPacketTooShort
+		}
+		return 0, nil, nil
+	}
+
+	n := channelDataHeaderSize + int(binary.BigEndian.Uint16(data[2:4]))
+	if len(data) < n+padding(n) {
+		if atEOF {
+			return 0, nil, fmt.Errorf("stun: channel data truncated")
+		}
+		return 0, nil, nil
+	}
+	return n + padding(n), data[:n:n], nil
 }
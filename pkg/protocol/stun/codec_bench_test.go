@@ -0,0 +1,83 @@
+package stun
+
+import (
+	"net"
+	"testing"
+)
+
+func newBenchmarkResponse() *Message {
+	msg := NewMessage(MessageTypeBindingResponse, [12]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
+	msg.SetXORMappedAddress(net.ParseIP("192.0.2.1"), 32853)
+	msg.SetSoftware("webRTCInfra")
+	return msg
+}
+
+func BenchmarkEncode(b *testing.B) {
+	msg := newBenchmarkResponse()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Encode(msg)
+	}
+}
+
+func BenchmarkAppendEncode(b *testing.B) {
+	msg := newBenchmarkResponse()
+	buf := make([]byte, 0, 1500)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = AppendEncode(buf[:0], msg)
+	}
+}
+
+func BenchmarkAppendEncodeFingerprint(b *testing.B) {
+	msg := newBenchmarkResponse()
+	msg.Fingerprint = true
+	buf := make([]byte, 0, 1500)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = AppendEncode(buf[:0], msg)
+	}
+}
+
+func BenchmarkAppendEncodeIntegrity(b *testing.B) {
+	msg := newBenchmarkResponse()
+	msg.IntegrityKey = []byte(rfc5769Password)
+	msg.Fingerprint = true
+	buf := make([]byte, 0, 1500)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = AppendEncode(buf[:0], msg)
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	data := Encode(newBenchmarkResponse())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Decode(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeInto(b *testing.B) {
+	data := Encode(newBenchmarkResponse())
+	msg := &Message{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := DecodeInto(msg, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeIntoRFC5769(b *testing.B) {
+	data := mustDecodeHex(b, rfc5769Request)
+	msg := &Message{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := DecodeInto(msg, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
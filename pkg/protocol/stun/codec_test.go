@@ -3,6 +3,7 @@ package stun
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"testing"
 )
 
@@ -48,7 +49,7 @@ func TestDecode(t *testing.T) {
 				if msg.Type != 0x0001 {
 					t.Errorf("expected type 0x0001, got %x", msg.Type)
 				}
-				attr, ok := msg.Attributes[0x0001]
+				attr, ok := msg.Attributes.Get(0x0001)
 				if !ok {
 					t.Fatalf("expected attribute 0x0001 not found")
 				}
@@ -150,7 +151,7 @@ func TestDecode(t *testing.T) {
 				}
 
 				// Check first attribute
-				attr1, ok := msg.Attributes[0x0001]
+				attr1, ok := msg.Attributes.Get(0x0001)
 				if !ok {
 					t.Errorf("expected attribute 0x0001 not found")
 				}
@@ -159,7 +160,7 @@ func TestDecode(t *testing.T) {
 				}
 
 				// Check second attribute
-				attr2, ok := msg.Attributes[0x0002]
+				attr2, ok := msg.Attributes.Get(0x0002)
 				if !ok {
 					t.Errorf("expected attribute 0x0002 not found")
 				}
@@ -183,12 +184,13 @@ func TestDecode(t *testing.T) {
 				binary.Write(&buf, binary.BigEndian, uint16(0x0001)) // Attribute type
 				binary.Write(&buf, binary.BigEndian, uint16(3))      // Length = 3 bytes
 				buf.Write([]byte{0x01, 0x02, 0x03})                  // Actual data
+				buf.Write([]byte{0x00})                              // Padding
 				// Padding should be added during encoding, but decoder should handle it correctly
 
 				return buf.Bytes()
 			}(),
 			check: func(t *testing.T, msg *Message) {
-				attr, ok := msg.Attributes[0x0001]
+				attr, ok := msg.Attributes.Get(0x0001)
 				if !ok {
 					t.Errorf("expected attribute 0x0001 not found")
 				}
@@ -242,7 +244,7 @@ func TestEncode(t *testing.T) {
 			msg: Message{
 				Type:          0x0001,
 				TransactionID: transactionID,
-				Attributes:    make(map[uint16][]byte),
+				Attributes:    Attributes{},
 			},
 			check: func(t *testing.T, encoded []byte) {
 				if len(encoded) < 20 {
@@ -284,8 +286,8 @@ func TestEncode(t *testing.T) {
 			msg: Message{
 				Type:          0x0001,
 				TransactionID: transactionID,
-				Attributes: map[uint16][]byte{
-					0x0001: {0xDE, 0xAD, 0xBE, 0xEF},
+				Attributes: Attributes{
+					{Type: 0x0001, Value: []byte{0xDE, 0xAD, 0xBE, 0xEF}},
 				},
 			},
 			check: func(t *testing.T, encoded []byte) {
@@ -342,8 +344,8 @@ func TestEncode(t *testing.T) {
 			msg: Message{
 				Type:          0x0001,
 				TransactionID: transactionID,
-				Attributes: map[uint16][]byte{
-					0x0001: {0x01, 0x02, 0x03}, // 3字节，需要填充到4字节边界
+				Attributes: Attributes{
+					{Type: 0x0001, Value: []byte{0x01, 0x02, 0x03}}, // 3字节，需要填充到4字节边界
 				},
 			},
 			check: func(t *testing.T, encoded []byte) {
@@ -372,9 +374,9 @@ func TestEncode(t *testing.T) {
 			msg: Message{
 				Type:          0x0001,
 				TransactionID: transactionID,
-				Attributes: map[uint16][]byte{
-					0x0001: {0x01, 0x02, 0x03, 0x04},
-					0x0002: {0x05, 0x06},
+				Attributes: Attributes{
+					{Type: 0x0001, Value: []byte{0x01, 0x02, 0x03, 0x04}},
+					{Type: 0x0002, Value: []byte{0x05, 0x06}},
 				},
 			},
 			check: func(t *testing.T, encoded []byte) {
@@ -398,9 +400,9 @@ func TestEncode(t *testing.T) {
 			msg: Message{
 				Type:          0x0101,
 				TransactionID: transactionID,
-				Attributes: map[uint16][]byte{
-					0x0001: {0xAA, 0xBB, 0xCC, 0xDD},
-					0x0002: {0xEE, 0xFF},
+				Attributes: Attributes{
+					{Type: 0x0001, Value: []byte{0xAA, 0xBB, 0xCC, 0xDD}},
+					{Type: 0x0002, Value: []byte{0xEE, 0xFF}},
 				},
 			},
 			check: func(t *testing.T, encoded []byte) {
@@ -418,14 +420,14 @@ func TestEncode(t *testing.T) {
 					t.Errorf("transaction ID mismatch")
 				}
 
-				attr1, ok := decoded.Attributes[0x0001]
+				attr1, ok := decoded.Attributes.Get(0x0001)
 				if !ok {
 					t.Error("missing attribute 0x0001")
 				} else if !bytes.Equal(attr1, []byte{0xAA, 0xBB, 0xCC, 0xDD}) {
 					t.Errorf("attribute 0x0001 value mismatch: %X", attr1)
 				}
 
-				attr2, ok := decoded.Attributes[0x0002]
+				attr2, ok := decoded.Attributes.Get(0x0002)
 				if !ok {
 					t.Error("missing attribute 0x0002")
 				} else if !bytes.Equal(attr2, []byte{0xEE, 0xFF}) {
@@ -444,3 +446,138 @@ func TestEncode(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeInto(t *testing.T) {
+	data := mustDecodeHex(t, rfc5769Request)
+	msg := &Message{}
+	if err := DecodeInto(msg, data); err != nil {
+		t.Fatalf("DecodeInto failed: %v", err)
+	}
+	if len(msg.Attributes) != 6 {
+		t.Fatalf("expected 6 attributes, got %d", len(msg.Attributes))
+	}
+
+	// 属性值直接引用报文
+	username, _ := msg.Attributes.Get(AttributeTypeUsername)
+	if &username[0] != &data[20+20+8+12+4] {
+		t.Error("expected USERNAME value to point into the packet")
+	}
+	if err := msg.CheckIntegrity([]byte(rfc5769Password)); err != nil {
+		t.Errorf("CheckIntegrity() error = %v", err)
+	}
+
+	// 复用同一个Message解码另一个报文，旧内容应被清空
+	other := Encode(NewMessage(MessageTypeBindingIndication, [12]byte{9}))
+	if err := DecodeInto(msg, other); err != nil {
+		t.Fatalf("DecodeInto failed: %v", err)
+	}
+	if msg.Type != MessageTypeBindingIndication || len(msg.Attributes) != 0 || msg.TransactionID[0] != 9 {
+		t.Errorf("unexpected message after reuse: %+v", msg)
+	}
+	if err := msg.CheckIntegrity([]byte(rfc5769Password)); !errors.Is(err, ErrIntegrityMissing) {
+		t.Errorf("expected ErrIntegrityMissing after reuse, got %v", err)
+	}
+}
+
+func TestDecodeLegacyInto(t *testing.T) {
+	// RFC 3489 Binding请求：magic cookie的位置是128位事务ID的前4字节
+	prefix := []byte{0x01, 0x02, 0x03, 0x04}
+	transactionID := []byte("legacy-txid!")
+	data := buildValidStunPacket(prefix, transactionID, AttributeTypeChangeRequest, []byte{0, 0, 0, 0x06})
+
+	if err := DecodeInto(&Message{}, data); !errors.Is(err, ErrMagicCookieMismatch) {
+		t.Fatalf("DecodeInto() error = %v, want ErrMagicCookieMismatch", err)
+	}
+
+	msg := &Message{}
+	if err := DecodeLegacyInto(msg, data); err != nil {
+		t.Fatalf("DecodeLegacyInto failed: %v", err)
+	}
+	if !msg.Legacy || !bytes.Equal(msg.LegacyPrefix[:], prefix) || !bytes.Equal(msg.TransactionID[:], transactionID) {
+		t.Errorf("unexpected legacy header: legacy=%v prefix=%X txid=%X", msg.Legacy, msg.LegacyPrefix, msg.TransactionID)
+	}
+	if changeIP, changePort, err := msg.GetChangeRequest(); err != nil || !changeIP || !changePort {
+		t.Errorf("GetChangeRequest() = %v, %v, %v", changeIP, changePort, err)
+	}
+
+	// 重新编码时写回原始的前4字节
+	if encoded := Encode(msg); !bytes.Equal(encoded, data) {
+		t.Errorf("Encode() = %X, want %X", encoded, data)
+	}
+
+	// 带magic cookie的报文按RFC 5389格式解码
+	if err := DecodeLegacyInto(msg, Encode(NewMessage(MessageTypeBindingRequest, [12]byte{1}))); err != nil || msg.Legacy {
+		t.Errorf("DecodeLegacyInto() on RFC 5389 message: legacy=%v, err=%v", msg.Legacy, err)
+	}
+}
+
+func TestAppendEncode(t *testing.T) {
+	msg := NewMessage(MessageTypeBindingResponse, [12]byte{1, 2, 3})
+	msg.Attributes.Add(AttributeTypeSoftware, []byte("abc"))
+	msg.Fingerprint = true
+
+	prefix := []byte{0xFF, 0xFF}
+	buf := AppendEncode(prefix, msg)
+	if !bytes.Equal(buf[:2], prefix) || !bytes.Equal(buf[2:], Encode(msg)) {
+		t.Errorf("AppendEncode() = %X, want prefix followed by %X", buf, Encode(msg))
+	}
+	if _, err := Decode(buf[2:]); err != nil {
+		t.Errorf("Decode failed: %v", err)
+	}
+}
+
+func TestZeroAllocation(t *testing.T) {
+	msg := NewMessage(MessageTypeBindingResponse, [12]byte{1, 2, 3})
+	msg.Attributes.Add(AttributeTypeXORMappedAddress, []byte{0x00, IPV4, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06})
+	msg.Fingerprint = true
+	buf := make([]byte, 0, 1500)
+	data := Encode(msg)
+	decoded := &Message{Attributes: make(Attributes, 0, 8)}
+
+	if allocs := testing.AllocsPerRun(100, func() {
+		buf = AppendEncode(buf[:0], msg)
+	}); allocs != 0 {
+		t.Errorf("AppendEncode allocations = %v, want 0", allocs)
+	}
+	if allocs := testing.AllocsPerRun(100, func() {
+		if err := DecodeInto(decoded, data); err != nil {
+			t.Fatal(err)
+		}
+	}); allocs != 0 {
+		t.Errorf("DecodeInto allocations = %v, want 0", allocs)
+	}
+}
+
+func TestSplitMessages(t *testing.T) {
+	first := Encode(NewMessage(MessageTypeBindingRequest, [12]byte{1}))
+	msg := NewMessage(MessageTypeBindingRequest, [12]byte{2})
+	msg.Attributes.Add(AttributeTypeSoftware, []byte("abc"))
+	second := Encode(msg)
+	stream := append(append([]byte(nil), first...), second...)
+
+	// 一次读取包含两条消息
+	advance, token, err := SplitMessages(stream, false)
+	if err != nil || advance != len(first) || !bytes.Equal(token, first) {
+		t.Fatalf("SplitMessages() = %d, %X, %v, want first message", advance, token, err)
+	}
+	advance, token, err = SplitMessages(stream[advance:], false)
+	if err != nil || advance != len(second) || !bytes.Equal(token, second) {
+		t.Fatalf("SplitMessages() = %d, %X, %v, want second message", advance, token, err)
+	}
+
+	// 头部或属性尚未读完时等待更多数据
+	for _, n := range []int{0, 10, 20, len(second) - 1} {
+		if advance, token, err := SplitMessages(second[:n], false); advance != 0 || token != nil || err != nil {
+			t.Errorf("SplitMessages(%d bytes) = %d, %X, %v, want need more data", n, advance, token, err)
+		}
+	}
+
+	// 连接关闭时剩余不完整的消息
+	if _, _, err := SplitMessages(second[:len(second)-1], true); err == nil {
+		t.Error("expected error for truncated message at EOF")
+	}
+	// 最高两位不为0，字节流无法重新同步
+	if _, _, err := SplitMessages([]byte{0xC0, 0x01}, false); err == nil {
+		t.Error("expected error for invalid leading bits")
+	}
+}
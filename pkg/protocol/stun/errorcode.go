@@ -0,0 +1,61 @@
+package stun
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// SetErrorCode 设置ERROR-CODE属性，code为300~699之间的错误码
+func (m *Message) SetErrorCode(code int, reason string) {
+	value := []byte{0x00, 0x00, byte(code / 100), byte(code % 100)}
+	value = append(value, reason...)
+	m.Attributes.Set(AttributeTypeErrorCode, value)
+}
+
+// GetErrorCode 解析ERROR-CODE属性，返回错误码和原因短语
+func (m *Message) GetErrorCode() (int, string, error) {
+	value, ok := m.Attributes.Get(AttributeTypeErrorCode)
+	if !ok {
+		return 0, "", attributeNotFound(AttributeTypeErrorCode)
+	}
+	if len(value) < 4 {
+		return 0, "", fmt.Errorf("stun: ERROR-CODE too short: %d bytes", len(value))
+	}
+
+	// 错误码 = class(3位) * 100 + number(0~99)
+	class := int(value[2] & 0x07)
+	number := int(value[3])
+	if class < 3 || class > 6 || number > 99 {
+		return 0, "", fmt.Errorf("stun: invalid ERROR-CODE class %d number %d", class, number)
+	}
+	if err := checkReason(AttributeTypeErrorCode, value[4:]); err != nil {
+		return 0, "", err
+	}
+	return class*100 + number, string(value[4:]), nil
+}
+
+// SetUnknownAttributes 设置UNKNOWN-ATTRIBUTES属性，与420错误配合使用
+func (m *Message) SetUnknownAttributes(attrTypes []uint16) {
+	value := make([]byte, 2*len(attrTypes))
+	for i, attrType := range attrTypes {
+		binary.BigEndian.PutUint16(value[2*i:], attrType)
+	}
+	m.Attributes.Set(AttributeTypeUnknownAttributes, value)
+}
+
+// GetUnknownAttributes 解析UNKNOWN-ATTRIBUTES属性
+func (m *Message) GetUnknownAttributes() ([]uint16, error) {
+	value, ok := m.Attributes.Get(AttributeTypeUnknownAttributes)
+	if !ok {
+		return nil, attributeNotFound(AttributeTypeUnknownAttributes)
+	}
+	if len(value)%2 != 0 {
+		return nil, fmt.Errorf("stun: UNKNOWN-ATTRIBUTES has odd length %d", len(value))
+	}
+
+	attrTypes := make([]uint16, len(value)/2)
+	for i := range attrTypes {
+		attrTypes[i] = binary.BigEndian.Uint16(value[2*i:])
+	}
+	return attrTypes, nil
+}
@@ -0,0 +1,40 @@
+package stun
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// FINGERPRINT 属性值为4字节的CRC-32
+const fingerprintSize = 4
+
+// fingerprintXOR 按RFC 8489 14.7，CRC-32结果需要与0x5354554e异或
+const fingerprintXOR uint32 = 0x5354554e
+
+// FingerprintMismatchError 表示FINGERPRINT校验失败，
+// 通常意味着收到的并不是STUN报文
+type FingerprintMismatchError struct {
+	Expected uint32
+	Actual   uint32
+}
+
+func (e *FingerprintMismatchError) Error() string {
+	return fmt.Sprintf("stun: fingerprint mismatch: expected 0x%08x, got 0x%08x", e.Expected, e.Actual)
+}
+
+// fingerprint 计算FINGERPRINT值，b为FINGERPRINT之前的报文，头部长度字段需已包含FINGERPRINT属性
+func fingerprint(b []byte) uint32 {
+	return crc32.ChecksumIEEE(b) ^ fingerprintXOR
+}
+
+func checkFingerprint(b []byte, value []byte) error {
+	if len(value) != fingerprintSize {
+		return fmt.Errorf("stun: invalid fingerprint length %d", len(value))
+	}
+	expected := fingerprint(b)
+	if actual := binary.BigEndian.Uint32(value); actual != expected {
+		return &FingerprintMismatchError{Expected: expected, Actual: actual}
+	}
+	return nil
+}
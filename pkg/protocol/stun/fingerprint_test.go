@@ -0,0 +1,67 @@
+package stun
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestDecodeFingerprint(t *testing.T) {
+	t.Run("RFC 5769 sample request", func(t *testing.T) {
+		msg, err := Decode(mustDecodeHex(t, rfc5769Request))
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if _, ok := msg.Attributes.Get(AttributeTypeFingerprint); !ok {
+			t.Error("expected FINGERPRINT attribute")
+		}
+	})
+
+	t.Run("fingerprint mismatch", func(t *testing.T) {
+		data := mustDecodeHex(t, rfc5769Request)
+		data[len(data)-1] ^= 0x01
+		_, err := Decode(data)
+		var mismatch *FingerprintMismatchError
+		if !errors.As(err, &mismatch) {
+			t.Fatalf("expected FingerprintMismatchError, got %v", err)
+		}
+		if mismatch.Expected != 0xe57a3bcf {
+			t.Errorf("expected fingerprint 0xe57a3bcf, got 0x%08x", mismatch.Expected)
+		}
+	})
+
+	t.Run("fingerprint not last", func(t *testing.T) {
+		msg := NewMessage(MessageTypeBindingRequest, [12]byte{1})
+		msg.Attributes.Add(AttributeTypeFingerprint, []byte{0, 0, 0, 0})
+		data := Encode(msg)
+		// 追加一个属性到FINGERPRINT之后
+		data = appendAttribute(data, AttributeTypeUsername, []byte("user"))
+		binary.BigEndian.PutUint16(data[2:4], uint16(len(data)-20))
+		if _, err := Decode(data); err == nil {
+			t.Error("expected error for fingerprint not being the last attribute")
+		}
+	})
+}
+
+func TestEncodeFingerprint(t *testing.T) {
+	msg := NewMessage(MessageTypeBindingRequest, [12]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
+	msg.Attributes.Add(AttributeTypeUsername, []byte("alice:bob"))
+	msg.IntegrityKey = []byte("secret")
+	msg.Fingerprint = true
+
+	encoded := Encode(msg)
+	if len(encoded) != 20+16+24+8 {
+		t.Fatalf("expected length 68, got %d", len(encoded))
+	}
+	if attrType := binary.BigEndian.Uint16(encoded[len(encoded)-8:]); attrType != AttributeTypeFingerprint {
+		t.Errorf("expected FINGERPRINT as last attribute, got 0x%04x", attrType)
+	}
+
+	decoded, err := Decode(encoded)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if err := decoded.CheckIntegrity([]byte("secret")); err != nil {
+		t.Errorf("CheckIntegrity() error = %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package stun
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Role ICE代理的角色（RFC 8445 6.1.1）
+type Role uint8
+
+const (
+	RoleControlling Role = iota + 1
+	RoleControlled
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleControlling:
+		return "controlling"
+	case RoleControlled:
+		return "controlled"
+	default:
+		return "unknown"
+	}
+}
+
+// SetPriority 设置PRIORITY属性，即该检查成功后生成的对端反射候选的优先级
+func (m *Message) SetPriority(priority uint32) {
+	m.Attributes.Set(AttributeTypePriority, binary.BigEndian.AppendUint32(nil, priority))
+}
+
+// GetPriority 解析PRIORITY属性
+func (m *Message) GetPriority() (uint32, error) {
+	value, ok := m.Attributes.Get(AttributeTypePriority)
+	if !ok {
+		return 0, attributeNotFound(AttributeTypePriority)
+	}
+	if len(value) != 4 {
+		return 0, fmt.Errorf("stun: PRIORITY has invalid length %d", len(value))
+	}
+	return binary.BigEndian.Uint32(value), nil
+}
+
+// SetUseCandidate 设置USE-CANDIDATE属性，controlling代理用它提名候选对
+func (m *Message) SetUseCandidate() {
+	m.Attributes.Set(AttributeTypeUseCandidate, nil)
+}
+
+// UseCandidate 返回消息是否携带USE-CANDIDATE属性
+func (m *Message) UseCandidate() bool {
+	return m.Attributes.Has(AttributeTypeUseCandidate)
+}
+
+// SetICEControlling 设置ICE-CONTROLLING属性，值为发送方的tie-breaker
+func (m *Message) SetICEControlling(tieBreaker uint64) {
+	m.Attributes.Set(AttributeTypeICEControlling, binary.BigEndian.AppendUint64(nil, tieBreaker))
+}
+
+// GetICEControlling 解析ICE-CONTROLLING属性
+func (m *Message) GetICEControlling() (uint64, error) {
+	return m.getTieBreaker(AttributeTypeICEControlling)
+}
+
+// SetICEControlled 设置ICE-CONTROLLED属性，值为发送方的tie-breaker
+func (m *Message) SetICEControlled(tieBreaker uint64) {
+	m.Attributes.Set(AttributeTypeICEControlled, binary.BigEndian.AppendUint64(nil, tieBreaker))
+}
+
+// GetICEControlled 解析ICE-CONTROLLED属性
+func (m *Message) GetICEControlled() (uint64, error) {
+	return m.getTieBreaker(AttributeTypeICEControlled)
+}
+
+func (m *Message) getTieBreaker(attrType uint16) (uint64, error) {
+	value, ok := m.Attributes.Get(attrType)
+	if !ok {
+		return 0, attributeNotFound(attrType)
+	}
+	if len(value) != 8 {
+		return 0, fmt.Errorf("stun: %s has invalid length %d", attributeName(attrType), len(value))
+	}
+	return binary.BigEndian.Uint64(value), nil
+}
+
+// ResolveRoleConflict 按RFC 8445 7.3.1.1处理收到的连通性检查中的角色冲突。
+// role和tieBreaker为本端当前的角色和tie-breaker；请求未携带角色属性或与本端不冲突时原样返回role。
+// 冲突时tie-breaker较大的一方成为controlling：本端应保持角色时返回conflict为true，需回复487；
+// 本端应切换角色时返回新角色，请求正常处理
+func ResolveRoleConflict(role Role, tieBreaker uint64, req *Message) (newRole Role, conflict bool, err error) {
+	switch role {
+	case RoleControlling:
+		if !req.Attributes.Has(AttributeTypeICEControlling) {
+			return role, false, nil
+		}
+		remote, err := req.GetICEControlling()
+		if err != nil {
+			return role, false, err
+		}
+		if tieBreaker >= remote {
+			return role, true, nil
+		}
+		return RoleControlled, false, nil
+	case RoleControlled:
+		if !req.Attributes.Has(AttributeTypeICEControlled) {
+			return role, false, nil
+		}
+		remote, err := req.GetICEControlled()
+		if err != nil {
+			return role, false, err
+		}
+		if tieBreaker >= remote {
+			return RoleControlling, false, nil
+		}
+		return role, true, nil
+	default:
+		return role, false, fmt.Errorf("stun: unknown ICE role %d", role)
+	}
+}
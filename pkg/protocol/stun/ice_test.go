@@ -0,0 +1,85 @@
+package stun
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestICEAttributesRoundTrip(t *testing.T) {
+	msg := NewMessage(MessageTypeBindingRequest, [12]byte{1})
+	msg.SetPriority(0x6e0001ff)
+	msg.SetUseCandidate()
+	msg.SetICEControlling(0x932ff9b151263b36)
+	msg.SetICEControlled(1)
+
+	decoded, err := Decode(Encode(msg))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if priority, err := decoded.GetPriority(); err != nil || priority != 0x6e0001ff {
+		t.Errorf("GetPriority() = %x, %v", priority, err)
+	}
+	if !decoded.UseCandidate() {
+		t.Error("expected USE-CANDIDATE")
+	}
+	if tb, err := decoded.GetICEControlling(); err != nil || tb != 0x932ff9b151263b36 {
+		t.Errorf("GetICEControlling() = %x, %v", tb, err)
+	}
+	if tb, err := decoded.GetICEControlled(); err != nil || tb != 1 {
+		t.Errorf("GetICEControlled() = %x, %v", tb, err)
+	}
+
+	empty := NewMessage(MessageTypeBindingRequest, [12]byte{})
+	if empty.UseCandidate() {
+		t.Error("unexpected USE-CANDIDATE")
+	}
+	if _, err := empty.GetPriority(); !errors.Is(err, ErrAttributeNotFound) {
+		t.Errorf("GetPriority() error = %v, want ErrAttributeNotFound", err)
+	}
+	empty.Attributes.Add(AttributeTypeICEControlled, []byte{1, 2, 3, 4})
+	if _, err := empty.GetICEControlled(); err == nil {
+		t.Error("expected error for short ICE-CONTROLLED")
+	}
+}
+
+func TestResolveRoleConflict(t *testing.T) {
+	controlling := func(tb uint64) *Message {
+		msg := NewMessage(MessageTypeBindingRequest, [12]byte{})
+		msg.SetICEControlling(tb)
+		return msg
+	}
+	controlled := func(tb uint64) *Message {
+		msg := NewMessage(MessageTypeBindingRequest, [12]byte{})
+		msg.SetICEControlled(tb)
+		return msg
+	}
+
+	tests := []struct {
+		name         string
+		role         Role
+		tieBreaker   uint64
+		req          *Message
+		wantRole     Role
+		wantConflict bool
+	}{
+		{"controlling收到ICE-CONTROLLED，无冲突", RoleControlling, 5, controlled(10), RoleControlling, false},
+		{"controlled收到ICE-CONTROLLING，无冲突", RoleControlled, 5, controlling(10), RoleControlled, false},
+		{"双方controlling，本端tie-breaker较大，回复487", RoleControlling, 10, controlling(5), RoleControlling, true},
+		{"双方controlling，tie-breaker相等，回复487", RoleControlling, 10, controlling(10), RoleControlling, true},
+		{"双方controlling，本端tie-breaker较小，切换为controlled", RoleControlling, 5, controlling(10), RoleControlled, false},
+		{"双方controlled，本端tie-breaker较大，切换为controlling", RoleControlled, 10, controlled(5), RoleControlling, false},
+		{"双方controlled，本端tie-breaker较小，回复487", RoleControlled, 5, controlled(10), RoleControlled, true},
+		{"请求不带角色属性", RoleControlled, 5, NewMessage(MessageTypeBindingRequest, [12]byte{}), RoleControlled, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role, conflict, err := ResolveRoleConflict(tt.role, tt.tieBreaker, tt.req)
+			if err != nil {
+				t.Fatalf("ResolveRoleConflict() error = %v", err)
+			}
+			if role != tt.wantRole || conflict != tt.wantConflict {
+				t.Errorf("ResolveRoleConflict() = %v, %v, want %v, %v", role, conflict, tt.wantRole, tt.wantConflict)
+			}
+		})
+	}
+}
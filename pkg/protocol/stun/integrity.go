@@ -0,0 +1,55 @@
+package stun
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+)
+
+// MESSAGE-INTEGRITY 属性值为20字节的HMAC-SHA1
+const messageIntegritySize = 20
+
+var (
+	ErrIntegrityMissing  = errors.New("stun: message integrity attribute not found")
+	ErrIntegrityMismatch = errors.New("stun: message integrity mismatch")
+)
+
+// messageIntegrity 计算HMAC-SHA1并追加到dst，b为MESSAGE-INTEGRITY之前的报文（含头部）。
+// 按RFC 8489 14.5，计算时头部长度字段需要包含MESSAGE-INTEGRITY属性本身（24字节）
+func messageIntegrity(dst []byte, b []byte, key []byte) []byte {
+	var header [20]byte
+	copy(header[:], b[:20])
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(b)-20+4+messageIntegritySize))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(header[:])
+	mac.Write(b[20:])
+	return mac.Sum(dst)
+}
+
+// CheckIntegrity 使用密钥校验Decode得到的消息的MESSAGE-INTEGRITY，
+// 短期凭证下密钥即为密码
+func (m *Message) CheckIntegrity(key []byte) error {
+	value, ok := m.Attributes.Get(AttributeTypeMessageIntegrity)
+	if !ok || m.integrityOffset == 0 {
+		return ErrIntegrityMissing
+	}
+	if len(value) != messageIntegritySize {
+		return ErrIntegrityMismatch
+	}
+
+	expected := messageIntegrity(nil, m.raw[:m.integrityOffset], key)
+	if !hmac.Equal(expected, value) {
+		return ErrIntegrityMismatch
+	}
+	return nil
+}
+
+// LongTermKey 计算长期凭证的密钥MD5(username ":" realm ":" password)（RFC 8489 9.2.2），
+// 用于MESSAGE-INTEGRITY的计算和校验
+func LongTermKey(username, realm, password string) []byte {
+	key := md5.Sum([]byte(username + ":" + realm + ":" + password))
+	return key[:]
+}
@@ -0,0 +1,114 @@
+package stun
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// RFC 5769 2.1 示例请求（含 SOFTWARE、PRIORITY、ICE-CONTROLLED、USERNAME、MESSAGE-INTEGRITY、FINGERPRINT）
+const rfc5769Request = "000100582112a442b7e7a701bc34d686fa87dfae" +
+	"802200105354554e207465737420636c69656e74" +
+	"002400046e0001ff" +
+	"80290008932ff9b151263b36" +
+	"000600096576746a3a68367659202020" +
+	"000800149aeaa70cbfd8cb56781ef2b5b2d3f249c1b571a2" +
+	"80280004e57a3bcf"
+
+const rfc5769Password = "VOkJxbRl1RmTxUk/WvJxBt"
+
+func mustDecodeHex(t testing.TB, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex: %v", err)
+	}
+	return b
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	t.Run("RFC 5769 sample request", func(t *testing.T) {
+		msg, err := Decode(mustDecodeHex(t, rfc5769Request))
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if err := msg.CheckIntegrity([]byte(rfc5769Password)); err != nil {
+			t.Errorf("CheckIntegrity() error = %v", err)
+		}
+		if err := msg.CheckIntegrity([]byte("wrong password")); !errors.Is(err, ErrIntegrityMismatch) {
+			t.Errorf("expected ErrIntegrityMismatch, got %v", err)
+		}
+	})
+
+	t.Run("tampered attribute", func(t *testing.T) {
+		data := mustDecodeHex(t, rfc5769Request)
+		data[24] ^= 0xFF // 修改SOFTWARE的第一个字节
+		// 重新计算FINGERPRINT，确保只有MESSAGE-INTEGRITY校验失败
+		binary.BigEndian.PutUint32(data[len(data)-4:], fingerprint(data[:len(data)-8]))
+		msg, err := Decode(data)
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if err := msg.CheckIntegrity([]byte(rfc5769Password)); !errors.Is(err, ErrIntegrityMismatch) {
+			t.Errorf("expected ErrIntegrityMismatch, got %v", err)
+		}
+	})
+
+	t.Run("missing attribute", func(t *testing.T) {
+		msg, err := Decode(Encode(NewMessage(MessageTypeBindingRequest, [12]byte{1, 2, 3})))
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if err := msg.CheckIntegrity([]byte(rfc5769Password)); !errors.Is(err, ErrIntegrityMissing) {
+			t.Errorf("expected ErrIntegrityMissing, got %v", err)
+		}
+	})
+}
+
+func TestEncodeWithIntegrity(t *testing.T) {
+	key := []byte("secret")
+	req := NewMessage(MessageTypeBindingRequest, [12]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
+	req.Attributes.Add(AttributeTypeUsername, []byte("alice:bob"))
+	req.IntegrityKey = key
+
+	encoded := Encode(req)
+	// 20字节头部 + USERNAME(4+9+3填充) + MESSAGE-INTEGRITY(4+20)
+	if len(encoded) != 20+16+24 {
+		t.Fatalf("expected length 60, got %d", len(encoded))
+	}
+
+	decoded, err := Decode(encoded)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if err := decoded.CheckIntegrity(key); err != nil {
+		t.Errorf("CheckIntegrity() error = %v", err)
+	}
+	if err := decoded.CheckIntegrity([]byte("other")); !errors.Is(err, ErrIntegrityMismatch) {
+		t.Errorf("expected ErrIntegrityMismatch, got %v", err)
+	}
+}
+
+func TestLongTermKey(t *testing.T) {
+	key := LongTermKey("user", "realm", "pass")
+	if got := hex.EncodeToString(key); got != "8493fbc53ba582fb4c044c456bdc40eb" {
+		t.Errorf("LongTermKey() = %s", got)
+	}
+
+	req := NewMessage(NewMessageType(MethodAllocate, ClassRequest), [12]byte{1})
+	req.SetUsername("user")
+	req.SetRealm("realm")
+	req.SetNonce("nonce")
+	req.IntegrityKey = key
+	decoded, err := Decode(Encode(req))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if err := decoded.CheckIntegrity(LongTermKey("user", "realm", "pass")); err != nil {
+		t.Errorf("CheckIntegrity() error = %v", err)
+	}
+	if err := decoded.CheckIntegrity(LongTermKey("user", "other", "pass")); !errors.Is(err, ErrIntegrityMismatch) {
+		t.Errorf("expected ErrIntegrityMismatch, got %v", err)
+	}
+}
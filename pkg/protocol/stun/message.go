@@ -1,20 +1,145 @@
 package stun
 
 import (
-	"encoding/binary"
-	"net"
+	"crypto/rand"
+	"fmt"
 )
 
+// 方法
+const (
+	MethodBinding           uint16 = 0x001
+	MethodAllocate          uint16 = 0x003 // TURN（RFC 8656）
+	MethodRefresh           uint16 = 0x004 // TURN
+	MethodSend              uint16 = 0x006 // TURN
+	MethodData              uint16 = 0x007 // TURN
+	MethodCreatePermission  uint16 = 0x008 // TURN
+	MethodChannelBind       uint16 = 0x009 // TURN
+	MethodConnect           uint16 = 0x00A // TURN over TCP（RFC 6062）
+	MethodConnectionBind    uint16 = 0x00B // TURN over TCP
+	MethodConnectionAttempt uint16 = 0x00C // TURN over TCP
+)
+
+// MessageClass 消息类别
+type MessageClass uint8
+
+const (
+	ClassRequest         MessageClass = 0b00
+	ClassIndication      MessageClass = 0b01
+	ClassSuccessResponse MessageClass = 0b10
+	ClassErrorResponse   MessageClass = 0b11
+)
+
+func (c MessageClass) String() string {
+	switch c {
+	case ClassRequest:
+		return "request"
+	case ClassIndication:
+		return "indication"
+	case ClassSuccessResponse:
+		return "success response"
+	case ClassErrorResponse:
+		return "error response"
+	default:
+		return "unknown"
+	}
+}
+
 // 消息类型
 const (
-	MessageTypeBindingRequest  uint16 = 0x0001
-	MessageTypeBindingResponse uint16 = 0x0101
+	MessageTypeBindingRequest       uint16 = 0x0001
+	MessageTypeBindingIndication    uint16 = 0x0011
+	MessageTypeBindingResponse      uint16 = 0x0101
+	MessageTypeBindingErrorResponse uint16 = 0x0111
 )
 
+// 消息类型的14位中，方法(M0-M11)与类别(C0、C1)交错排列：
+//
+//	 0                 1
+//	 2  3  4 5 6 7 8 9 0 1 2 3 4 5
+//	+--+--+-+-+-+-+-+-+-+-+-+-+-+-+
+//	|M |M |M|M|M|C|M|M|M|C|M|M|M|M|
+//	|11|10|9|8|7|1|6|5|4|0|3|2|1|0|
+//	+--+--+-+-+-+-+-+-+-+-+-+-+-+-+
+const (
+	classBit0 = 0x0010
+	classBit1 = 0x0100
+)
+
+// NewMessageType 由方法和类别组合出消息类型
+func NewMessageType(method uint16, class MessageClass) uint16 {
+	m := method & 0x000F         // M0-M3
+	m |= (method & 0x0070) << 1  // M4-M6
+	m |= (method & 0x0F80) << 2  // M7-M11
+	c := uint16(class&0b01) << 4 // C0
+	c |= uint16(class&0b10) << 7 // C1
+	return m | c
+}
+
+// MethodOf 从消息类型中取出方法
+func MethodOf(msgType uint16) uint16 {
+	m := msgType & 0x000F        // M0-M3
+	m |= (msgType >> 1) & 0x0070 // M4-M6
+	m |= (msgType >> 2) & 0x0F80 // M7-M11
+	return m
+}
+
+// ClassOf 从消息类型中取出类别
+func ClassOf(msgType uint16) MessageClass {
+	c0 := (msgType & classBit0) >> 4
+	c1 := (msgType & classBit1) >> 7
+	return MessageClass(c0 | c1)
+}
+
 // 属性类型
 const (
-	AttributeTypeXORMappedAddress uint16 = 0x0020
-	AttributeTypeErrorCode               = 0x0009
+	AttributeTypeMappedAddress      uint16 = 0x0001
+	AttributeTypeChangeRequest      uint16 = 0x0003
+	AttributeTypeSourceAddress      uint16 = 0x0004 // RFC 3489
+	AttributeTypeChangedAddress     uint16 = 0x0005 // RFC 3489
+	AttributeTypeUsername           uint16 = 0x0006
+	AttributeTypeMessageIntegrity   uint16 = 0x0008
+	AttributeTypeErrorCode          uint16 = 0x0009
+	AttributeTypeUnknownAttributes  uint16 = 0x000A
+	AttributeTypeChannelNumber      uint16 = 0x000C // TURN
+	AttributeTypeLifetime           uint16 = 0x000D // TURN
+	AttributeTypeXORPeerAddress     uint16 = 0x0012 // TURN
+	AttributeTypeData               uint16 = 0x0013 // TURN
+	AttributeTypeRealm              uint16 = 0x0014
+	AttributeTypeNonce              uint16 = 0x0015
+	AttributeTypeXORRelayedAddress  uint16 = 0x0016 // TURN
+	AttributeTypeRequestedTransport uint16 = 0x0019 // TURN
+	AttributeTypeXORMappedAddress   uint16 = 0x0020
+	AttributeTypeConnectionID       uint16 = 0x002A // TURN over TCP
+	AttributeTypePriority           uint16 = 0x0024
+	AttributeTypeUseCandidate       uint16 = 0x0025
+	AttributeTypeResponsePort       uint16 = 0x0027 // RFC 5780
+	AttributeTypeSoftware           uint16 = 0x8022
+	AttributeTypeAlternateServer    uint16 = 0x8023
+	AttributeTypeFingerprint        uint16 = 0x8028
+	AttributeTypeICEControlled      uint16 = 0x8029
+	AttributeTypeICEControlling     uint16 = 0x802A
+	AttributeTypeResponseOrigin     uint16 = 0x802B
+	AttributeTypeOtherAddress       uint16 = 0x802C
+)
+
+// 错误码
+const (
+	ErrorCodeTryAlternate               = 300
+	ErrorCodeBadRequest                 = 400
+	ErrorCodeUnauthorized               = 401
+	ErrorCodeForbidden                  = 403
+	ErrorCodeUnknownAttribute           = 420
+	ErrorCodeAllocationMismatch         = 437 // TURN
+	ErrorCodeStaleNonce                 = 438
+	ErrorCodeWrongCredentials           = 441 // TURN
+	ErrorCodePeerAddressFamilyMismatch  = 443 // TURN
+	ErrorCodeUnsupportedTransport       = 442 // TURN
+	ErrorCodeConnectionAlreadyExists    = 446 // TURN over TCP
+	ErrorCodeConnectionTimeoutOrFailure = 447 // TURN over TCP
+	ErrorCodeAllocationQuotaReached     = 486 // TURN
+	ErrorCodeRoleConflict               = 487
+	ErrorCodeServerError                = 500
+	ErrorCodeInsufficientCapacity       = 508 // TURN
 )
 
 const (
@@ -25,40 +150,40 @@ const (
 type Message struct {
 	Type          uint16
 	TransactionID [12]byte
-	Attributes    map[uint16][]byte
+	Attributes    Attributes // 按顺序保存的属性列表
+
+	// IntegrityKey 非空时，Encode 会用该密钥计算 MESSAGE-INTEGRITY 并追加到属性末尾
+	IntegrityKey []byte
+	// Fingerprint 为true时，Encode 会在最后追加 FINGERPRINT 属性
+	Fingerprint bool
+
+	// Legacy 为true表示RFC 3489格式的消息：没有magic cookie，128位事务ID的前4字节
+	// 位于magic cookie的位置，保存在LegacyPrefix中，其余12字节保存在TransactionID中
+	Legacy       bool
+	LegacyPrefix [4]byte
+
+	raw             []byte // Decode 时的原始报文，用于校验 MESSAGE-INTEGRITY
+	integrityOffset int    // MESSAGE-INTEGRITY 属性在原始报文中的偏移，0表示不存在
 }
 
 func NewMessage(type_ uint16, transactionID [12]byte) *Message {
 	return &Message{
 		Type:          type_,
 		TransactionID: transactionID,
-		Attributes:    make(map[uint16][]byte),
 	}
 }
 
-func (m *Message) SetXORMappedAddress(ip net.IP, port int) {
-	var family byte
-	var ipBytes []byte
-	if ip.To4() != nil {
-		family = IPV4
-		ipBytes = ip.To4()
-	} else {
-		family = IPV6
-		ipBytes = ip.To16()
-	}
-
-	// 编码端口
-	portBytes := make([]byte, 2)
-	magic := binary.BigEndian.Uint16(magicCookie[:2])
-	binary.BigEndian.PutUint16(portBytes, uint16(port^int(magic)))
+// Reset 清空消息以便复用，保留属性列表的底层数组
+func (m *Message) Reset() {
+	attrs := m.Attributes[:0]
+	*m = Message{Attributes: attrs}
+}
 
-	xorIP := make([]byte, len(ipBytes))
-	for i := 0; i < len(ipBytes); i++ {
-		xorIP[i] = ipBytes[i] ^ magicCookie[i%4]
+// NewTransactionID 生成随机的96位事务ID
+func NewTransactionID() [12]byte {
+	var id [12]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(fmt.Sprintf("stun: failed to generate transaction ID: %v", err))
 	}
-
-	value := []byte{0x00, family}
-	value = append(value, portBytes...)
-	value = append(value, xorIP...)
-	m.Attributes[AttributeTypeXORMappedAddress] = value
+	return id
 }
@@ -0,0 +1,49 @@
+package stun
+
+import "testing"
+
+func TestMessageTypeMethodClass(t *testing.T) {
+	tests := []struct {
+		msgType uint16
+		method  uint16
+		class   MessageClass
+	}{
+		{MessageTypeBindingRequest, MethodBinding, ClassRequest},
+		{MessageTypeBindingIndication, MethodBinding, ClassIndication},
+		{MessageTypeBindingResponse, MethodBinding, ClassSuccessResponse},
+		{MessageTypeBindingErrorResponse, MethodBinding, ClassErrorResponse},
+		{0x0003, 0x003, ClassRequest},       // Allocate请求
+		{0x0113, 0x003, ClassErrorResponse}, // Allocate错误响应
+		{0x0016, 0x006, ClassIndication},    // Send指示
+		{0x0017, 0x007, ClassIndication},    // Data指示
+		{0x3EEF, 0xFFF, ClassRequest},       // 方法位全为1
+		{0x3FFF, 0xFFF, ClassErrorResponse}, // 所有位全为1
+		{0x0110 | 0x0020, 0x010, ClassErrorResponse},
+	}
+
+	for _, tt := range tests {
+		if got := MethodOf(tt.msgType); got != tt.method {
+			t.Errorf("MethodOf(0x%04x) = 0x%03x, want 0x%03x", tt.msgType, got, tt.method)
+		}
+		if got := ClassOf(tt.msgType); got != tt.class {
+			t.Errorf("ClassOf(0x%04x) = %s, want %s", tt.msgType, got, tt.class)
+		}
+		if got := NewMessageType(tt.method, tt.class); got != tt.msgType {
+			t.Errorf("NewMessageType(0x%03x, %s) = 0x%04x, want 0x%04x", tt.method, tt.class, got, tt.msgType)
+		}
+	}
+}
+
+func TestMessageTypeRoundTrip(t *testing.T) {
+	for method := uint16(0); method <= 0xFFF; method++ {
+		for class := ClassRequest; class <= ClassErrorResponse; class++ {
+			msgType := NewMessageType(method, class)
+			if msgType&0xC000 != 0 {
+				t.Fatalf("NewMessageType(0x%03x, %s) sets leading bits: 0x%04x", method, class, msgType)
+			}
+			if MethodOf(msgType) != method || ClassOf(msgType) != class {
+				t.Fatalf("round trip failed for method 0x%03x class %s", method, class)
+			}
+		}
+	}
+}
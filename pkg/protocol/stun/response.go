@@ -0,0 +1,48 @@
+package stun
+
+var errorReasons = map[int]string{
+	ErrorCodeTryAlternate:               "Try Alternate",
+	ErrorCodeBadRequest:                 "Bad Request",
+	ErrorCodeUnauthorized:               "Unauthorized",
+	ErrorCodeForbidden:                  "Forbidden",
+	ErrorCodeUnknownAttribute:           "Unknown Attribute",
+	ErrorCodeAllocationMismatch:         "Allocation Mismatch",
+	ErrorCodeStaleNonce:                 "Stale Nonce",
+	ErrorCodeWrongCredentials:           "Wrong Credentials",
+	ErrorCodeUnsupportedTransport:       "Unsupported Transport Protocol",
+	ErrorCodePeerAddressFamilyMismatch:  "Peer Address Family Mismatch",
+	ErrorCodeConnectionAlreadyExists:    "Connection Already Exists",
+	ErrorCodeConnectionTimeoutOrFailure: "Connection Timeout or Failure",
+	ErrorCodeAllocationQuotaReached:     "Allocation Quota Reached",
+	ErrorCodeRoleConflict:               "Role Conflict",
+	ErrorCodeServerError:                "Server Error",
+	ErrorCodeInsufficientCapacity:       "Insufficient Capacity",
+}
+
+// ErrorReason 返回错误码的推荐原因短语，未知错误码返回空串
+func ErrorReason(code int) string {
+	return errorReasons[code]
+}
+
+// NewResponse 构造与请求同方法的响应：沿用请求的事务ID和RFC 3489格式，请求携带FINGERPRINT时响应也需要携带
+func NewResponse(req *Message, class MessageClass) *Message {
+	resp := NewMessage(NewMessageType(MethodOf(req.Type), class), req.TransactionID)
+	resp.Legacy = req.Legacy
+	resp.LegacyPrefix = req.LegacyPrefix
+	resp.Fingerprint = req.Attributes.Has(AttributeTypeFingerprint)
+	return resp
+}
+
+// NewErrorResponse 构造请求的错误响应，原因短语取ErrorReason
+func NewErrorResponse(req *Message, code int) *Message {
+	resp := NewResponse(req, ClassErrorResponse)
+	resp.SetErrorCode(code, ErrorReason(code))
+	return resp
+}
+
+// NewUnknownAttributesResponse 构造420错误响应，列出无法理解的必须理解属性
+func NewUnknownAttributesResponse(req *Message, unknown []uint16) *Message {
+	resp := NewErrorResponse(req, ErrorCodeUnknownAttribute)
+	resp.SetUnknownAttributes(unknown)
+	return resp
+}
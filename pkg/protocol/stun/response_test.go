@@ -0,0 +1,40 @@
+package stun
+
+import "testing"
+
+func TestNewErrorResponse(t *testing.T) {
+	req := NewMessage(NewMessageType(MethodAllocate, ClassRequest), [12]byte{1, 2, 3})
+	req.Attributes.Set(AttributeTypeFingerprint, []byte{0, 0, 0, 0})
+
+	resp := NewErrorResponse(req, ErrorCodeAllocationQuotaReached)
+	if resp.Type != NewMessageType(MethodAllocate, ClassErrorResponse) {
+		t.Errorf("type = 0x%04x, want Allocate error response", resp.Type)
+	}
+	if resp.TransactionID != req.TransactionID {
+		t.Errorf("transaction ID = %x, want %x", resp.TransactionID, req.TransactionID)
+	}
+	if !resp.Fingerprint {
+		t.Error("response should carry FINGERPRINT when the request does")
+	}
+	code, reason, err := resp.GetErrorCode()
+	if err != nil || code != ErrorCodeAllocationQuotaReached || reason != "Allocation Quota Reached" {
+		t.Errorf("GetErrorCode() = %d, %q, %v", code, reason, err)
+	}
+}
+
+func TestNewUnknownAttributesResponse(t *testing.T) {
+	req := NewMessage(MessageTypeBindingRequest, [12]byte{4})
+	req.Legacy = true
+
+	resp := NewUnknownAttributesResponse(req, []uint16{0x0001, 0x0002})
+	if resp.Type != MessageTypeBindingErrorResponse || !resp.Legacy || resp.Fingerprint {
+		t.Errorf("type = 0x%04x legacy = %v fingerprint = %v", resp.Type, resp.Legacy, resp.Fingerprint)
+	}
+	if code, _, _ := resp.GetErrorCode(); code != ErrorCodeUnknownAttribute {
+		t.Errorf("error code = %d, want %d", code, ErrorCodeUnknownAttribute)
+	}
+	unknown, err := resp.GetUnknownAttributes()
+	if err != nil || len(unknown) != 2 || unknown[0] != 0x0001 || unknown[1] != 0x0002 {
+		t.Errorf("GetUnknownAttributes() = %v, %v", unknown, err)
+	}
+}
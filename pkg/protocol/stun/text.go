@@ -0,0 +1,86 @@
+package stun
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// RFC 8489 第14节对文本类属性的长度限制
+const (
+	maxUsernameBytes = 512 // USERNAME 少于513字节
+	maxTextChars     = 127 // REALM/NONCE/SOFTWARE/错误原因 少于128个字符
+	maxTextBytes     = 763 // 且不超过763字节
+)
+
+func (m *Message) SetUsername(username string) error {
+	return m.setText(AttributeTypeUsername, username)
+}
+
+func (m *Message) GetUsername() (string, error) {
+	return m.getText(AttributeTypeUsername)
+}
+
+func (m *Message) SetRealm(realm string) error {
+	return m.setText(AttributeTypeRealm, realm)
+}
+
+func (m *Message) GetRealm() (string, error) {
+	return m.getText(AttributeTypeRealm)
+}
+
+func (m *Message) SetNonce(nonce string) error {
+	return m.setText(AttributeTypeNonce, nonce)
+}
+
+func (m *Message) GetNonce() (string, error) {
+	return m.getText(AttributeTypeNonce)
+}
+
+func (m *Message) SetSoftware(software string) error {
+	return m.setText(AttributeTypeSoftware, software)
+}
+
+func (m *Message) GetSoftware() (string, error) {
+	return m.getText(AttributeTypeSoftware)
+}
+
+func (m *Message) setText(attrType uint16, text string) error {
+	if err := checkText(attrType, []byte(text)); err != nil {
+		return err
+	}
+	m.Attributes.Set(attrType, []byte(text))
+	return nil
+}
+
+func (m *Message) getText(attrType uint16) (string, error) {
+	value, ok := m.Attributes.Get(attrType)
+	if !ok {
+		return "", attributeNotFound(attrType)
+	}
+	if err := checkText(attrType, value); err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// checkText 校验文本类属性的长度
+func checkText(attrType uint16, value []byte) error {
+	if attrType == AttributeTypeUsername {
+		if len(value) > maxUsernameBytes {
+			return fmt.Errorf("stun: %s too long: %d bytes (max %d)", attributeName(attrType), len(value), maxUsernameBytes)
+		}
+		return nil
+	}
+	return checkReason(attrType, value)
+}
+
+// checkReason 校验少于128个字符的文本，REALM/NONCE/SOFTWARE和ERROR-CODE的原因短语共用
+func checkReason(attrType uint16, value []byte) error {
+	if len(value) > maxTextBytes {
+		return fmt.Errorf("stun: %s too long: %d bytes (max %d)", attributeName(attrType), len(value), maxTextBytes)
+	}
+	if n := utf8.RuneCount(value); n > maxTextChars {
+		return fmt.Errorf("stun: %s too long: %d characters (max %d)", attributeName(attrType), n, maxTextChars)
+	}
+	return nil
+}
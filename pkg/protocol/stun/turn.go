@@ -0,0 +1,125 @@
+package stun
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// REQUESTED-TRANSPORT中的协议号（IANA）
+const (
+	TransportTCP byte = 6 // RFC 6062
+	TransportUDP byte = 17
+)
+
+// SetRequestedTransport 设置REQUESTED-TRANSPORT属性，即中继使用的传输协议
+func (m *Message) SetRequestedTransport(protocol byte) {
+	m.Attributes.Set(AttributeTypeRequestedTransport, []byte{protocol, 0, 0, 0})
+}
+
+// GetRequestedTransport 解析REQUESTED-TRANSPORT属性
+func (m *Message) GetRequestedTransport() (byte, error) {
+	value, ok := m.Attributes.Get(AttributeTypeRequestedTransport)
+	if !ok {
+		return 0, attributeNotFound(AttributeTypeRequestedTransport)
+	}
+	if len(value) != 4 {
+		return 0, fmt.Errorf("stun: REQUESTED-TRANSPORT has invalid length %d", len(value))
+	}
+	return value[0], nil
+}
+
+// SetLifetime 设置LIFETIME属性，精度为秒
+func (m *Message) SetLifetime(lifetime time.Duration) {
+	m.Attributes.Set(AttributeTypeLifetime, binary.BigEndian.AppendUint32(nil, uint32(lifetime/time.Second)))
+}
+
+// GetLifetime 解析LIFETIME属性
+func (m *Message) GetLifetime() (time.Duration, error) {
+	value, ok := m.Attributes.Get(AttributeTypeLifetime)
+	if !ok {
+		return 0, attributeNotFound(AttributeTypeLifetime)
+	}
+	if len(value) != 4 {
+		return 0, fmt.Errorf("stun: LIFETIME has invalid length %d", len(value))
+	}
+	return time.Duration(binary.BigEndian.Uint32(value)) * time.Second, nil
+}
+
+// SetXORRelayedAddress 设置XOR-RELAYED-ADDRESS属性，即服务器为分配的中继地址
+func (m *Message) SetXORRelayedAddress(ip net.IP, port int) {
+	m.setXORAddress(AttributeTypeXORRelayedAddress, ip, port)
+}
+
+// GetXORRelayedAddress 解析XOR-RELAYED-ADDRESS属性
+func (m *Message) GetXORRelayedAddress() (net.IP, int, error) {
+	return m.getXORAddress(AttributeTypeXORRelayedAddress)
+}
+
+// SetXORPeerAddress 设置XOR-PEER-ADDRESS属性，即对端的地址
+func (m *Message) SetXORPeerAddress(ip net.IP, port int) {
+	m.setXORAddress(AttributeTypeXORPeerAddress, ip, port)
+}
+
+// AddXORPeerAddress 追加一个XOR-PEER-ADDRESS属性，CreatePermission可以携带多个
+func (m *Message) AddXORPeerAddress(ip net.IP, port int) {
+	value := encodeAddress(ip, port)
+	m.xorAddress(value)
+	m.Attributes.Add(AttributeTypeXORPeerAddress, value)
+}
+
+// GetXORPeerAddress 解析第一个XOR-PEER-ADDRESS属性
+func (m *Message) GetXORPeerAddress() (net.IP, int, error) {
+	return m.getXORAddress(AttributeTypeXORPeerAddress)
+}
+
+// GetXORPeerAddresses 按顺序解析所有XOR-PEER-ADDRESS属性
+func (m *Message) GetXORPeerAddresses() ([]*net.UDPAddr, error) {
+	values := m.Attributes.GetAll(AttributeTypeXORPeerAddress)
+	if len(values) == 0 {
+		return nil, attributeNotFound(AttributeTypeXORPeerAddress)
+	}
+	addrs := make([]*net.UDPAddr, 0, len(values))
+	for _, value := range values {
+		if err := checkAddress(AttributeTypeXORPeerAddress, value); err != nil {
+			return nil, err
+		}
+		value = append([]byte(nil), value...)
+		m.xorAddress(value)
+		ip, port := decodeAddress(value)
+		addrs = append(addrs, &net.UDPAddr{IP: ip, Port: port})
+	}
+	return addrs, nil
+}
+
+// SetData 设置DATA属性，即Send和Data指示中转发的应用数据
+func (m *Message) SetData(data []byte) {
+	m.Attributes.Set(AttributeTypeData, data)
+}
+
+// GetData 返回DATA属性的值，直接引用消息的属性值
+func (m *Message) GetData() ([]byte, error) {
+	value, ok := m.Attributes.Get(AttributeTypeData)
+	if !ok {
+		return nil, attributeNotFound(AttributeTypeData)
+	}
+	return value, nil
+}
+
+// SetConnectionID 设置CONNECTION-ID属性，标识TCP分配中与对端的一条连接（RFC 6062 6.2.1）
+func (m *Message) SetConnectionID(id uint32) {
+	m.Attributes.Set(AttributeTypeConnectionID, binary.BigEndian.AppendUint32(nil, id))
+}
+
+// GetConnectionID 解析CONNECTION-ID属性
+func (m *Message) GetConnectionID() (uint32, error) {
+	value, ok := m.Attributes.Get(AttributeTypeConnectionID)
+	if !ok {
+		return 0, attributeNotFound(AttributeTypeConnectionID)
+	}
+	if len(value) != 4 {
+		return 0, fmt.Errorf("stun: CONNECTION-ID has invalid length %d", len(value))
+	}
+	return binary.BigEndian.Uint32(value), nil
+}
@@ -0,0 +1,123 @@
+package stun
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTURNAttributesRoundTrip(t *testing.T) {
+	msg := NewMessage(NewMessageType(MethodAllocate, ClassSuccessResponse), [12]byte{1, 2, 3})
+	msg.SetRequestedTransport(TransportUDP)
+	msg.SetLifetime(10 * time.Minute)
+	msg.SetXORRelayedAddress(net.ParseIP("192.0.2.15"), 50000)
+
+	encoded := Encode(msg)
+	decoded, err := Decode(encoded)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if proto, err := decoded.GetRequestedTransport(); err != nil || proto != TransportUDP {
+		t.Errorf("GetRequestedTransport() = %d, %v", proto, err)
+	}
+	if lifetime, err := decoded.GetLifetime(); err != nil || lifetime != 10*time.Minute {
+		t.Errorf("GetLifetime() = %v, %v", lifetime, err)
+	}
+	ip, port, err := decoded.GetXORRelayedAddress()
+	if err != nil || !ip.Equal(net.ParseIP("192.0.2.15")) || port != 50000 {
+		t.Errorf("GetXORRelayedAddress() = %v, %d, %v", ip, port, err)
+	}
+	// 报文中的中继地址经过异或
+	if value, _ := decoded.Attributes.Get(AttributeTypeXORRelayedAddress); net.IP(value[4:]).Equal(net.ParseIP("192.0.2.15")) {
+		t.Error("XOR-RELAYED-ADDRESS is not obfuscated")
+	}
+
+	v6 := NewMessage(NewMessageType(MethodAllocate, ClassSuccessResponse), [12]byte{9})
+	v6.SetXORRelayedAddress(net.ParseIP("2001:db8::1"), 3479)
+	if ip, port, err := v6.GetXORRelayedAddress(); err != nil || !ip.Equal(net.ParseIP("2001:db8::1")) || port != 3479 {
+		t.Errorf("GetXORRelayedAddress() IPv6 = %v, %d, %v", ip, port, err)
+	}
+}
+
+func TestTURNAttributesErrors(t *testing.T) {
+	msg := NewMessage(NewMessageType(MethodAllocate, ClassRequest), [12]byte{})
+	if _, err := msg.GetRequestedTransport(); !errors.Is(err, ErrAttributeNotFound) {
+		t.Errorf("GetRequestedTransport() error = %v, want ErrAttributeNotFound", err)
+	}
+	if _, err := msg.GetLifetime(); !errors.Is(err, ErrAttributeNotFound) {
+		t.Errorf("GetLifetime() error = %v, want ErrAttributeNotFound", err)
+	}
+
+	msg.Attributes.Add(AttributeTypeRequestedTransport, []byte{17})
+	if _, err := msg.GetRequestedTransport(); err == nil {
+		t.Error("expected error for short REQUESTED-TRANSPORT")
+	}
+	msg.Attributes.Add(AttributeTypeLifetime, []byte{0, 0, 1})
+	if _, err := msg.GetLifetime(); err == nil {
+		t.Error("expected error for short LIFETIME")
+	}
+}
+
+func TestPeerAddressAndData(t *testing.T) {
+	msg := NewMessage(NewMessageType(MethodCreatePermission, ClassRequest), [12]byte{4, 5, 6})
+	msg.AddXORPeerAddress(net.ParseIP("192.0.2.1"), 1000)
+	msg.AddXORPeerAddress(net.ParseIP("2001:db8::2"), 2000)
+	decoded, err := Decode(Encode(msg))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	addrs, err := decoded.GetXORPeerAddresses()
+	if err != nil {
+		t.Fatalf("GetXORPeerAddresses() error = %v", err)
+	}
+	if len(addrs) != 2 || addrs[0].String() != "192.0.2.1:1000" || addrs[1].String() != "[2001:db8::2]:2000" {
+		t.Errorf("GetXORPeerAddresses() = %v", addrs)
+	}
+	if ip, port, err := decoded.GetXORPeerAddress(); err != nil || !ip.Equal(net.ParseIP("192.0.2.1")) || port != 1000 {
+		t.Errorf("GetXORPeerAddress() = %v, %d, %v", ip, port, err)
+	}
+
+	send := NewMessage(NewMessageType(MethodSend, ClassIndication), [12]byte{7})
+	send.SetXORPeerAddress(net.ParseIP("192.0.2.1"), 1000)
+	send.SetData([]byte("hello"))
+	decoded, err = Decode(Encode(send))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if data, err := decoded.GetData(); err != nil || string(data) != "hello" {
+		t.Errorf("GetData() = %q, %v", data, err)
+	}
+
+	empty := NewMessage(NewMessageType(MethodSend, ClassIndication), [12]byte{})
+	if _, err := empty.GetData(); !errors.Is(err, ErrAttributeNotFound) {
+		t.Errorf("GetData() error = %v, want ErrAttributeNotFound", err)
+	}
+	if _, err := empty.GetXORPeerAddresses(); !errors.Is(err, ErrAttributeNotFound) {
+		t.Errorf("GetXORPeerAddresses() error = %v, want ErrAttributeNotFound", err)
+	}
+	empty.Attributes.Add(AttributeTypeXORPeerAddress, []byte{0, 9, 0, 0})
+	if _, err := empty.GetXORPeerAddresses(); err == nil {
+		t.Error("expected error for invalid XOR-PEER-ADDRESS family")
+	}
+}
+
+func TestConnectionID(t *testing.T) {
+	msg := NewMessage(NewMessageType(MethodConnectionAttempt, ClassIndication), [12]byte{4})
+	if _, err := msg.GetConnectionID(); !errors.Is(err, ErrAttributeNotFound) {
+		t.Errorf("GetConnectionID() error = %v, want ErrAttributeNotFound", err)
+	}
+	msg.SetConnectionID(0xDEADBEEF)
+	decoded, err := Decode(Encode(msg))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if id, err := decoded.GetConnectionID(); err != nil || id != 0xDEADBEEF {
+		t.Errorf("GetConnectionID() = %x, %v", id, err)
+	}
+
+	msg.Attributes.Set(AttributeTypeConnectionID, []byte{1, 2})
+	if _, err := msg.GetConnectionID(); err == nil {
+		t.Error("expected error for short CONNECTION-ID")
+	}
+}
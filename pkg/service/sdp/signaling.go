@@ -2,6 +2,7 @@ package sdp
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"webRTCInfra/pkg/common"
 	"webRTCInfra/pkg/network/websocket"
@@ -87,7 +88,7 @@ func (s *Signaler) handleClose(userID string) {
 
 // 发送错误响应
 func (s *Signaler) sendError(userID, msg string) {
-	errMsg, _ := common.NewWebsocketServiceResponse("", common.SignallingTypeError, fmt.Errorf(msg))
+	errMsg, _ := common.NewWebsocketServiceResponse("", common.SignallingTypeError, errors.New(msg))
 	if conn, ok := s.connMgr.GetClient(userID); ok {
 		if !conn.Send(errMsg) {
 			conn.Conn.Close()
@@ -0,0 +1,37 @@
+package stun
+
+import (
+	"webRTCInfra/pkg/protocol/stun"
+)
+
+// authenticate 按RFC 8489 9.1.3校验短期凭证。
+// 返回用于响应签名的密钥；校验失败时返回对应的错误码。
+// 未携带USERNAME和MESSAGE-INTEGRITY的请求视为匿名请求，直接放行；
+// 未设置凭证查找函数时无法校验签名，携带签名的请求一律返回401
+func (s *Service) authenticate(msg *stun.Message) ([]byte, int) {
+	hasUsername := msg.Attributes.Has(stun.AttributeTypeUsername)
+	hasIntegrity := msg.Attributes.Has(stun.AttributeTypeMessageIntegrity)
+	if !hasUsername && !hasIntegrity {
+		return nil, 0
+	}
+	if !hasUsername || !hasIntegrity {
+		return nil, stun.ErrorCodeBadRequest
+	}
+	if s.credentials == nil {
+		return nil, stun.ErrorCodeUnauthorized
+	}
+
+	username, err := msg.GetUsername()
+	if err != nil {
+		return nil, stun.ErrorCodeBadRequest
+	}
+	password, ok := s.credentials(username)
+	if !ok {
+		return nil, stun.ErrorCodeUnauthorized
+	}
+	key := []byte(password)
+	if err := msg.CheckIntegrity(key); err != nil {
+		return nil, stun.ErrorCodeUnauthorized
+	}
+	return key, 0
+}
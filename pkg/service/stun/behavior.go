@@ -0,0 +1,166 @@
+package stun
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"webRTCInfra/pkg/network/udp"
+	"webRTCInfra/pkg/protocol/stun"
+)
+
+// behaviorDiscovery RFC 5780 NAT行为发现：服务在 主/备用IP × 主/备用端口 的组合上监听，
+// 客户端通过CHANGE-REQUEST要求从其他地址发送响应，以此判断NAT的映射和过滤行为
+type behaviorDiscovery struct {
+	alternateIP   net.IP
+	alternatePort int
+
+	// mu 保护start期间的初始化：主地址先于其余地址开始收包，请求需等待全部地址就绪
+	mu sync.RWMutex
+	// servers[ip][port]，下标0为主IP/主端口，1为备用；没有备用IP时servers[1]为空
+	servers [2][2]*udp.Server
+}
+
+// SetBehaviorDiscovery 启用RFC 5780 NAT行为发现，需在Start之前调用。
+// 主地址为NewService传入的udp.Server的监听地址，Start时会额外监听备用IP和备用端口的组合。
+// alternateIP为空时只支持改变端口；指定备用IP时主地址必须是具体的IP。
+// alternatePort为0时由系统分配
+func (s *Service) SetBehaviorDiscovery(alternateIP string, alternatePort int) error {
+	var ip net.IP
+	if alternateIP != "" {
+		if ip = net.ParseIP(alternateIP); ip == nil {
+			return fmt.Errorf("stun: invalid alternate IP %q", alternateIP)
+		}
+	}
+	s.behavior = &behaviorDiscovery{
+		alternateIP:   ip,
+		alternatePort: alternatePort,
+	}
+	return nil
+}
+
+// start 在主地址启动后监听其余地址
+func (b *behaviorDiscovery) start(primary *udp.Server, onPacket func(*udp.Connection, []byte)) error {
+	primaryAddr := primary.LocalAddr()
+	if b.alternateIP != nil && primaryAddr.IP.IsUnspecified() {
+		return fmt.Errorf("stun: behavior discovery with alternate IP requires a specific primary IP, got %s", primaryAddr)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.servers[0][0] = primary
+
+	// 先监听主IP的备用端口，确定备用端口后再监听备用IP
+	var err error
+	if b.servers[0][1], err = listen(primaryAddr.IP, b.alternatePort, onPacket); err != nil {
+		return err
+	}
+	b.alternatePort = b.servers[0][1].LocalAddr().Port
+
+	if b.alternateIP != nil {
+		if b.servers[1][0], err = listen(b.alternateIP, primaryAddr.Port, onPacket); err != nil {
+			return err
+		}
+		if b.servers[1][1], err = listen(b.alternateIP, b.alternatePort, onPacket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func listen(ip net.IP, port int, onPacket func(*udp.Connection, []byte)) (*udp.Server, error) {
+	srv := udp.NewService(net.JoinHostPort(ip.String(), strconv.Itoa(port)), onPacket)
+	if err := srv.Start(); err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// close 关闭除主地址外的监听
+func (b *behaviorDiscovery) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range b.servers {
+		for j := range b.servers[i] {
+			if (i != 0 || j != 0) && b.servers[i][j] != nil {
+				b.servers[i][j].Close()
+			}
+		}
+	}
+}
+
+// locate 返回接收请求的本地地址在servers中的下标
+func (b *behaviorDiscovery) locate(local *net.UDPAddr) (ipIndex, portIndex int) {
+	if b.alternateIP != nil && local.IP.Equal(b.alternateIP) {
+		ipIndex = 1
+	}
+	if local.Port == b.alternatePort {
+		portIndex = 1
+	}
+	return ipIndex, portIndex
+}
+
+// prepare 处理CHANGE-REQUEST和RESPONSE-PORT并设置RESPONSE-ORIGIN和OTHER-ADDRESS，返回用于发送响应的套接字。
+// 处理失败时返回对应的错误码
+func (b *behaviorDiscovery) prepare(conn *udp.Connection, req, resp *stun.Message) (writer, int) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	recvIP, recvPort := b.locate(conn.LocalAddr())
+	ipIndex, portIndex := recvIP, recvPort
+
+	if req.Attributes.Has(stun.AttributeTypeChangeRequest) {
+		changeIP, changePort, err := req.GetChangeRequest()
+		if err != nil {
+			return nil, stun.ErrorCodeBadRequest
+		}
+		// 没有备用IP时无法改变IP
+		if changeIP && b.alternateIP == nil {
+			return nil, stun.ErrorCodeUnknownAttribute
+		}
+		if changeIP {
+			ipIndex ^= 1
+		}
+		if changePort {
+			portIndex ^= 1
+		}
+	}
+
+	// RESPONSE-PORT：响应发往请求来源IP的指定端口，用于测试绑定存活时间（RFC 5780 4.6）
+	dest := conn.GetRemoteAddr()
+	if req.Attributes.Has(stun.AttributeTypeResponsePort) {
+		port, err := req.GetResponsePort()
+		if err != nil {
+			return nil, stun.ErrorCodeBadRequest
+		}
+		dest = &net.UDPAddr{IP: dest.IP, Port: port}
+	}
+
+	server := b.servers[ipIndex][portIndex]
+	origin := server.LocalAddr()
+	setOrigin, setOther := resp.SetResponseOrigin, resp.SetOtherAddress
+	if resp.Legacy {
+		// RFC 3489中对应的属性为SOURCE-ADDRESS和CHANGED-ADDRESS
+		setOrigin, setOther = resp.SetSourceAddress, resp.SetChangedAddress
+	}
+	// 监听未指定的IP时不知道响应从哪个本地IP发出，与SOURCE-ADDRESS一样不设置
+	if !origin.IP.IsUnspecified() {
+		setOrigin(origin.IP, origin.Port)
+	}
+
+	// OTHER-ADDRESS 为与接收地址IP和端口都不同的地址
+	if b.alternateIP != nil {
+		other := b.servers[recvIP^1][recvPort^1].LocalAddr()
+		setOther(other.IP, other.Port)
+	}
+
+	return endpointWriter{server: server, addr: dest}, 0
+}
+
+// endpointWriter 通过指定的监听套接字向客户端发送数据
+type endpointWriter struct {
+	server *udp.Server
+	addr   *net.UDPAddr
+}
+
+func (w endpointWriter) Write(data []byte) error {
+	return w.server.WriteTo(data, w.addr)
+}
@@ -0,0 +1,47 @@
+package stun
+
+import (
+	"log"
+	"net"
+	"webRTCInfra/pkg/protocol/stun"
+)
+
+// sendErrorResponse 发送错误响应
+func (s *Service) sendErrorResponse(p peer, req *stun.Message, code int) {
+	s.sendMessage(p, stun.NewErrorResponse(req, code))
+}
+
+// sendUnknownAttributes 发送420错误响应，列出无法理解的必须理解属性
+func (s *Service) sendUnknownAttributes(p peer, req *stun.Message, unknown []uint16, key []byte) {
+	resp := stun.NewUnknownAttributesResponse(req, unknown)
+	resp.IntegrityKey = key
+	s.sendMessage(p, resp)
+}
+
+// sendTryAlternate 发送300错误响应，ALTERNATE-SERVER指向备用服务器
+func (s *Service) sendTryAlternate(p peer, req *stun.Message, alternate *net.UDPAddr, key []byte) {
+	resp := stun.NewErrorResponse(req, stun.ErrorCodeTryAlternate)
+	resp.SetAlternateServer(alternate.IP, alternate.Port)
+	resp.IntegrityKey = key
+	s.sendMessage(p, resp)
+}
+
+func (s *Service) sendMessage(p peer, msg *stun.Message) {
+	if err := writeMessage(p, msg); err != nil {
+		log.Printf("failed to send STUN message: %v", err)
+	}
+}
+
+// writer 发送响应的目标，udp.Connection从接收请求的套接字发送
+type writer interface {
+	Write(data []byte) error
+}
+
+// writeMessage 使用池化的缓冲区编码并发送消息
+func writeMessage(w writer, msg *stun.Message) error {
+	buf := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(buf)
+
+	*buf = stun.AppendEncode((*buf)[:0], msg)
+	return w.Write(*buf)
+}
@@ -0,0 +1,99 @@
+package stun
+
+import (
+	"net"
+	"sync"
+	"webRTCInfra/pkg/protocol/stun"
+)
+
+// iceAttributes 应答ICE连通性检查时能够理解的必须理解属性
+var iceAttributes = []uint16{
+	stun.AttributeTypePriority,
+	stun.AttributeTypeUseCandidate,
+}
+
+// ICECheck 一次通过校验的ICE连通性检查
+type ICECheck struct {
+	Username     string
+	Remote       net.Addr
+	Priority     uint32
+	UseCandidate bool // 对端（controlling）提名了该候选对
+}
+
+// iceAgent 应答连通性检查的ICE代理状态，角色切换对之后的所有检查生效
+type iceAgent struct {
+	mu         sync.Mutex
+	role       stun.Role
+	tieBreaker uint64
+
+	onCheck func(ICECheck)
+}
+
+// SetICEAgent 以ICE代理的身份应答连通性检查（RFC 8445 7.3），需在Start之前调用。
+// 携带ICE-CONTROLLING或ICE-CONTROLLED的Binding请求必须通过SetCredentialFunc设置的短期凭证认证，不会被重定向，
+// 与本端角色冲突时按tie-breaker切换角色或回复487 Role Conflict
+func (s *Service) SetICEAgent(role stun.Role, tieBreaker uint64) {
+	s.ice = &iceAgent{role: role, tieBreaker: tieBreaker}
+}
+
+// SetICECheckHandler 设置连通性检查成功应答后的回调，需在SetICEAgent之后、Start之前调用
+func (s *Service) SetICECheckHandler(fn func(ICECheck)) {
+	if s.ice != nil {
+		s.ice.onCheck = fn
+	}
+}
+
+// ICERole 返回ICE代理的当前角色，未启用时返回0
+func (s *Service) ICERole() stun.Role {
+	if s.ice == nil {
+		return 0
+	}
+	s.ice.mu.Lock()
+	defer s.ice.mu.Unlock()
+	return s.ice.role
+}
+
+// isICECheck 判断请求是否为ICE连通性检查
+func isICECheck(msg *stun.Message) bool {
+	return msg.Attributes.Has(stun.AttributeTypeICEControlling) || msg.Attributes.Has(stun.AttributeTypeICEControlled)
+}
+
+// check 校验连通性检查并处理角色冲突，返回需要回复的错误码
+func (a *iceAgent) check(msg *stun.Message, key []byte) int {
+	// 连通性检查必须经过短期凭证认证
+	if key == nil {
+		return stun.ErrorCodeBadRequest
+	}
+	if msg.Attributes.Has(stun.AttributeTypePriority) {
+		if _, err := msg.GetPriority(); err != nil {
+			return stun.ErrorCodeBadRequest
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	role, conflict, err := stun.ResolveRoleConflict(a.role, a.tieBreaker, msg)
+	if err != nil {
+		return stun.ErrorCodeBadRequest
+	}
+	if conflict {
+		return stun.ErrorCodeRoleConflict
+	}
+	a.role = role
+	return 0
+}
+
+// notify 通知连通性检查已成功应答
+func (a *iceAgent) notify(msg *stun.Message, remote net.Addr) {
+	if a.onCheck == nil {
+		return
+	}
+	username, _ := msg.GetUsername()
+	priority, _ := msg.GetPriority()
+	a.onCheck(ICECheck{
+		Username:     username,
+		Remote:       remote,
+		Priority:     priority,
+		UseCandidate: msg.UseCandidate(),
+	})
+}
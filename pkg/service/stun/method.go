@@ -0,0 +1,65 @@
+package stun
+
+import (
+	"net"
+	"webRTCInfra/pkg/network/tcp"
+	"webRTCInfra/pkg/network/udp"
+	"webRTCInfra/pkg/protocol/stun"
+)
+
+// Request 交给方法处理函数的请求或指示
+type Request struct {
+	// Message 已解码的消息，属性值引用接收缓冲区，只在处理函数返回前有效
+	Message *stun.Message
+	Remote  net.Addr
+	Local   net.Addr
+	UDP     *udp.Connection // 通过UDP收到时非空
+	TCP     *tcp.Connection // 通过TCP或TLS收到时非空
+
+	w writer
+}
+
+func newRequest(p peer, msg *stun.Message) *Request {
+	return &Request{Message: msg, Remote: p.remote, Local: p.local, UDP: p.udp, TCP: p.tcp, w: p.writer}
+}
+
+// Write 编码消息并发回请求的来源
+func (r *Request) Write(msg *stun.Message) error {
+	return writeMessage(r.w, msg)
+}
+
+// MethodHandler 处理某个方法的请求和指示，需要自行认证并回复响应
+type MethodHandler func(r *Request)
+
+// HandleMethod 注册Binding以外方法的处理函数，使TURN等基于STUN的协议与STUN共用监听端口，需在Start之前调用。
+// 注册的方法不经过短期凭证认证；RFC 3489格式的消息不会交给处理函数
+func (s *Service) HandleMethod(method uint16, h MethodHandler) {
+	if method == stun.MethodBinding {
+		return
+	}
+	if s.methods == nil {
+		s.methods = make(map[uint16]MethodHandler)
+	}
+	s.methods[method] = h
+}
+
+// ChannelDataHandler 处理TURN ChannelData消息，remote和local标识消息来源的5元组，data在返回后会被回收
+type ChannelDataHandler func(remote, local net.Addr, data []byte)
+
+// HandleChannelData 注册TURN ChannelData的处理函数，需在Start之前调用。
+// UDP上由Demux按首字节分发，TCP和TLS上由stun.SplitMessages切分后分发
+func (s *Service) HandleChannelData(h ChannelDataHandler) {
+	s.channelData = h
+	s.demux.Handle(udp.ProtocolChannelData, func(conn *udp.Connection, data []byte) {
+		h(conn.GetRemoteAddr(), conn.LocalAddr(), data)
+	})
+}
+
+// StreamCloseHandler 处理TCP或TLS连接的关闭，remote和local标识连接的5元组
+type StreamCloseHandler func(remote, local net.Addr)
+
+// HandleStreamClose 注册TCP和TLS连接关闭时的处理函数，需在Start之前调用。
+// TURN据此在控制连接关闭时释放分配；被ConnectionBind接管的数据连接不回调
+func (s *Service) HandleStreamClose(h StreamCloseHandler) {
+	s.streamClose = h
+}
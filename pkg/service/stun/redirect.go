@@ -0,0 +1,118 @@
+package stun
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RedirectPolicy 决定是否以300 Try Alternate将客户端重定向到备用服务器
+type RedirectPolicy interface {
+	ShouldRedirect(client net.Addr) bool
+}
+
+// RedirectFunc 函数形式的RedirectPolicy
+type RedirectFunc func(client net.Addr) bool
+
+func (f RedirectFunc) ShouldRedirect(client net.Addr) bool {
+	return f(client)
+}
+
+// RedirectAlways 重定向所有请求
+var RedirectAlways RedirectPolicy = RedirectFunc(func(net.Addr) bool { return true })
+
+// RedirectAny 任一策略要求重定向时即重定向。所有策略都会被调用，以便限速策略统计全部请求
+func RedirectAny(policies ...RedirectPolicy) RedirectPolicy {
+	return RedirectFunc(func(client net.Addr) bool {
+		redirect := false
+		for _, p := range policies {
+			if p.ShouldRedirect(client) {
+				redirect = true
+			}
+		}
+		return redirect
+	})
+}
+
+// RateRedirect 每秒请求数超过上限时，将超出部分的请求重定向
+type RateRedirect struct {
+	limit int
+
+	mu          sync.Mutex
+	windowStart time.Time
+	count       int
+}
+
+func NewRateRedirect(limit int) *RateRedirect {
+	return &RateRedirect{limit: limit}
+}
+
+func (r *RateRedirect) ShouldRedirect(net.Addr) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// 按1秒的固定窗口计数
+	now := time.Now()
+	if now.Sub(r.windowStart) >= time.Second {
+		r.windowStart = now
+		r.count = 0
+	}
+	r.count++
+	return r.count > r.limit
+}
+
+// DrainRedirect 节点下线前进入排空状态，期间重定向所有请求
+type DrainRedirect struct {
+	draining atomic.Bool
+}
+
+func NewDrainRedirect() *DrainRedirect {
+	return &DrainRedirect{}
+}
+
+// SetDraining 开始或结束排空
+func (d *DrainRedirect) SetDraining(draining bool) {
+	d.draining.Store(draining)
+}
+
+func (d *DrainRedirect) Draining() bool {
+	return d.draining.Load()
+}
+
+func (d *DrainRedirect) ShouldRedirect(net.Addr) bool {
+	return d.draining.Load()
+}
+
+// alternateServer 重定向的目标和策略
+type alternateServer struct {
+	addr   *net.UDPAddr
+	policy RedirectPolicy
+}
+
+// SetAlternateServer 设置备用服务器地址和重定向策略，需在Start之前调用。
+// 策略要求重定向时，Binding请求在认证通过后收到带ALTERNATE-SERVER的300响应。
+// 备用服务器与客户端地址族不同时不做重定向；RFC 3489客户端不支持300，也不会被重定向
+func (s *Service) SetAlternateServer(addr string, policy RedirectPolicy) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("stun: invalid alternate server %q: %w", addr, err)
+	}
+	if udpAddr.IP == nil || udpAddr.IP.IsUnspecified() {
+		return fmt.Errorf("stun: alternate server %q must have a specific IP", addr)
+	}
+	s.alternate = &alternateServer{addr: udpAddr, policy: policy}
+	return nil
+}
+
+// redirect 返回需要重定向到的地址，不重定向时返回nil
+func (a *alternateServer) redirect(client net.Addr) *net.UDPAddr {
+	if ip, _ := IPPort(client); (a.addr.IP.To4() != nil) != (ip.To4() != nil) {
+		return nil
+	}
+	if !a.policy.ShouldRedirect(client) {
+		return nil
+	}
+	return a.addr
+}
@@ -1,66 +1,270 @@
 package stun
 
 import (
+	"errors"
 	"log"
+	"net"
+	"sync"
+	"webRTCInfra/pkg/network/tcp"
 	"webRTCInfra/pkg/network/udp"
 	"webRTCInfra/pkg/protocol/stun"
 )
 
+// CredentialFunc 根据USERNAME查找短期凭证的密码，用户不存在时返回false
+type CredentialFunc func(username string) (password string, ok bool)
+
+// bindingAttributes Binding请求中能够理解的必须理解属性
+var bindingAttributes = []uint16{
+	stun.AttributeTypeUsername,
+	stun.AttributeTypeMessageIntegrity,
+}
+
 type Service struct {
-	udpSvc *udp.Server
+	udpSvc      *udp.Server
+	demux       *udp.Demux  // 按首字节把UDP数据包分发给STUN和共用端口的其他协议
+	tcpSvc      *tcp.Server // 未启用TCP时为nil
+	tlsSvc      *tcp.Server // 未启用TLS时为nil
+	credentials CredentialFunc
+	behavior    *behaviorDiscovery       // RFC 5780 NAT行为发现，未启用时为nil
+	legacy      bool                     // 兼容没有magic cookie的RFC 3489请求
+	alternate   *alternateServer         // 300 Try Alternate重定向，未设置时为nil
+	ice         *iceAgent                // ICE连通性检查应答，未启用时为nil
+	methods     map[uint16]MethodHandler // 其他方法（如TURN）的处理函数
+	channelData ChannelDataHandler       // TURN ChannelData的处理函数，未注册时为nil
+	streamClose StreamCloseHandler       // TCP和TLS连接关闭的处理函数，未注册时为nil
+
+	known []uint16 // Binding请求中能够理解的必须理解属性，Start时按启用的功能确定
 }
 
 func NewService(udpSvc *udp.Server) *Service {
 	service := &Service{
 		udpSvc: udpSvc,
+		demux:  udp.NewDemux(),
 	}
-	udpSvc.SetOnPacket(service.handlePacket)
+	service.demux.Handle(udp.ProtocolSTUN, service.handlePacket)
+	udpSvc.SetOnPacket(service.demux.OnPacket)
 	return service
 }
 
+// Demux 返回主地址上的协议分发器，用于注册DTLS、RTP/RTCP和ChannelData等与STUN共用端口的协议的处理函数，
+// 需在Start之前注册。未注册的协议直接丢弃
+func (s *Service) Demux() *udp.Demux {
+	return s.demux
+}
+
+// SetCredentialFunc 设置短期凭证查找函数，携带USERNAME或MESSAGE-INTEGRITY的请求必须通过校验。
+// 未设置时这类请求一律返回401，只接受匿名请求
+func (s *Service) SetCredentialFunc(fn CredentialFunc) {
+	s.credentials = fn
+}
+
+// SetLegacyCompatibility 启用后接受没有magic cookie的RFC 3489 Binding请求，
+// 并按RFC 3489的格式回复MAPPED-ADDRESS、SOURCE-ADDRESS和CHANGED-ADDRESS，需在Start之前调用
+func (s *Service) SetLegacyCompatibility(enabled bool) {
+	s.legacy = enabled
+}
+
 func (s *Service) Start() error {
-	return s.udpSvc.Start()
+	s.known = s.knownAttributes()
+	if err := s.udpSvc.Start(); err != nil {
+		return err
+	}
+	if s.behavior != nil {
+		if err := s.behavior.start(s.udpSvc, s.handlePacket); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	for _, srv := range s.streamServers() {
+		if err := srv.Start(); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// knownAttributes 汇总已启用功能能够理解的必须理解属性
+func (s *Service) knownAttributes() []uint16 {
+	known := append([]uint16(nil), bindingAttributes...)
+	if s.behavior != nil {
+		known = append(known, stun.AttributeTypeChangeRequest, stun.AttributeTypeResponsePort)
+	}
+	if s.ice != nil {
+		known = append(known, iceAttributes...)
+	}
+	return known
+}
+
+// LocalAddr 返回主地址的监听地址，需在Start之后调用
+func (s *Service) LocalAddr() *net.UDPAddr {
+	return s.udpSvc.LocalAddr()
 }
 
 func (s *Service) Close() {
+	for _, srv := range s.streamServers() {
+		srv.Close()
+	}
+	if s.behavior != nil {
+		s.behavior.close()
+	}
 	s.udpSvc.Close()
 }
 
-func (s *Service) handlePacket(conn *udp.Connection, data []byte) {
-	msg, err := stun.Decode(data)
+// 复用请求消息和编码缓冲区，减少高并发下的内存分配
+var (
+	messagePool = sync.Pool{
+		New: func() interface{} {
+			return &stun.Message{}
+		},
+	}
+	bufferPool = sync.Pool{
+		New: func() interface{} {
+			buf := make([]byte, 0, 1500)
+			return &buf
+		},
+	}
+)
+
+func (s *Service) handleMessage(p peer, data []byte) {
+	// 请求消息的属性值直接引用data，data在回调返回后才会被回收
+	msg := messagePool.Get().(*stun.Message)
+	defer messagePool.Put(msg)
+
+	var err error
+	if s.legacy {
+		err = stun.DecodeLegacyInto(msg, data)
+	} else {
+		err = stun.DecodeInto(msg, data)
+	}
 	if err != nil {
 		log.Printf("failed to decode STUN message: %v", err)
+		s.rejectMalformed(p, data, err)
 		return
 	}
 
-	switch msg.Type {
-	case stun.MessageTypeBindingRequest:
-		s.handleBindingRequest(conn, msg)
+	method, class := stun.MethodOf(msg.Type), stun.ClassOf(msg.Type)
+	if h := s.methods[method]; h != nil && !msg.Legacy && (class == stun.ClassRequest || class == stun.ClassIndication) {
+		h(newRequest(p, msg))
+		return
+	}
+	switch {
+	case method == stun.MethodBinding && class == stun.ClassRequest:
+		s.handleBindingRequest(p, msg)
+	case method == stun.MethodBinding && class == stun.ClassIndication:
+		// Binding指示用于保活NAT绑定，无需响应
+	case class == stun.ClassRequest:
+		// 未知方法的请求回复400
+		log.Printf("unknown STUN method 0x%03x from %s", method, p.remote)
+		s.sendErrorResponse(p, msg, stun.ErrorCodeBadRequest)
 	default:
-		log.Printf("unknown STUN message type: 0x%x", msg.Type)
+		// 其余指示和响应直接丢弃
+		log.Printf("unexpected STUN %s 0x%03x from %s", class, method, p.remote)
+	}
+}
+
+// rejectMalformed 对头部合法但无法完整解析的请求回复400，
+// 非STUN报文和FINGERPRINT校验失败的报文直接丢弃
+func (s *Service) rejectMalformed(p peer, data []byte, err error) {
+	var mismatch *stun.FingerprintMismatchError
+	if errors.As(err, &mismatch) {
 		return
 	}
+
+	msgType, transactionID, err := stun.DecodeHeader(data)
+	if err != nil || stun.ClassOf(msgType) != stun.ClassRequest {
+		return
+	}
+	s.sendErrorResponse(p, stun.NewMessage(msgType, transactionID), stun.ErrorCodeBadRequest)
 }
 
-func (s *Service) handleBindingRequest(conn *udp.Connection, msg *stun.Message) {
-	clientAddr := conn.GetRemoteAddr()
-	clientIP := clientAddr.IP
-	clientPort := clientAddr.Port
+func (s *Service) handleBindingRequest(p peer, msg *stun.Message) {
+	clientAddr := p.remote
+	clientIP, clientPort := IPPort(clientAddr)
+
+	// 校验短期凭证
+	key, code := s.authenticate(msg)
+	if code != 0 {
+		log.Printf("STUN request from %s rejected: %d", clientAddr, code)
+		s.sendErrorResponse(p, msg, code)
+		return
+	}
+
+	// 按重定向策略将客户端引导到备用服务器，ICE连通性检查的目标是本机，不做重定向
+	iceCheck := s.ice != nil && isICECheck(msg)
+	if s.alternate != nil && !msg.Legacy && !iceCheck {
+		if addr := s.alternate.redirect(clientAddr); addr != nil {
+			log.Printf("redirect STUN request from %s to %s", clientAddr, addr)
+			s.sendTryAlternate(p, msg, addr, key)
+			return
+		}
+	}
+
+	// 存在无法理解的必须理解属性时回复420
+	if unknown := msg.Attributes.UnknownRequired(s.known...); len(unknown) > 0 {
+		log.Printf("STUN request from %s has unknown attributes: %v", clientAddr, unknown)
+		s.sendUnknownAttributes(p, msg, unknown, key)
+		return
+	}
+
+	// ICE连通性检查：校验凭证并处理角色冲突
+	if iceCheck {
+		if code = s.ice.check(msg, key); code != 0 {
+			log.Printf("ICE check from %s rejected: %d", clientAddr, code)
+			resp := stun.NewErrorResponse(msg, code)
+			resp.IntegrityKey = key
+			s.sendMessage(p, resp)
+			return
+		}
+	}
 
 	// 创建响应消息
-	resp := stun.NewMessage(stun.MessageTypeBindingResponse, msg.TransactionID)
+	resp := stun.NewResponse(msg, stun.ClassSuccessResponse)
 
-	// 设置XOR-MAPPED-ADDRESS
-	resp.SetXORMappedAddress(clientIP, clientPort)
+	if msg.Legacy {
+		// RFC 3489客户端不认识XOR-MAPPED-ADDRESS。
+		// UDP上启用NAT行为发现时SOURCE-ADDRESS由prepare设置，否则只在监听具体IP时才有意义
+		resp.SetMappedAddress(clientIP, clientPort)
+		if ip, port := IPPort(p.local); (s.behavior == nil || p.udp == nil) && !ip.IsUnspecified() {
+			resp.SetSourceAddress(ip, port)
+		}
+	} else {
+		// 设置XOR-MAPPED-ADDRESS
+		resp.SetXORMappedAddress(clientIP, clientPort)
+	}
+
+	// 请求经过认证时，响应需使用相同的密钥签名
+	resp.IntegrityKey = key
 
-	// 编码响应消息
-	data := stun.Encode(resp)
+	// 启用NAT行为发现时，按CHANGE-REQUEST和RESPONSE-PORT选择发送响应的地址；TCP上两者都不支持
+	var w writer = p
+	unsupported := stun.AttributeTypeChangeRequest
+	switch {
+	case s.behavior != nil && p.udp != nil:
+		w, code = s.behavior.prepare(p.udp, msg, resp)
+	case msg.Attributes.Has(stun.AttributeTypeChangeRequest):
+		code = stun.ErrorCodeUnknownAttribute
+	case msg.Attributes.Has(stun.AttributeTypeResponsePort):
+		code, unsupported = stun.ErrorCodeUnknownAttribute, stun.AttributeTypeResponsePort
+	}
+	if code != 0 {
+		log.Printf("STUN request from %s rejected: %d", clientAddr, code)
+		if code == stun.ErrorCodeUnknownAttribute {
+			s.sendUnknownAttributes(p, msg, []uint16{unsupported}, key)
+		} else {
+			s.sendErrorResponse(p, msg, code)
+		}
+		return
+	}
 
-	// 发送响应
-	if err := conn.Write(data); err != nil {
+	// 编码并发送响应
+	if err := writeMessage(w, resp); err != nil {
 		log.Printf("failed to send STUN response: %v", err)
 		return
 	} else {
-		log.Printf("send STUN response to %s:%d", clientIP, clientPort)
+		log.Printf("send STUN response to %s", clientAddr)
+	}
+	if iceCheck {
+		s.ice.notify(msg, clientAddr)
 	}
 }
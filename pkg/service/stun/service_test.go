@@ -0,0 +1,822 @@
+package stun
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+	"webRTCInfra/pkg/internal/testutil"
+	"webRTCInfra/pkg/network/tcp"
+	"webRTCInfra/pkg/network/udp"
+	"webRTCInfra/pkg/protocol/stun"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestService 在回环地址的随机端口上启动STUN服务
+func startTestService(t *testing.T) (*Service, *net.UDPAddr) {
+	t.Helper()
+	svc := NewService(udp.NewService("127.0.0.1:0", nil))
+	require.NoError(t, svc.Start())
+	t.Cleanup(svc.Close)
+	return svc, svc.udpSvc.LocalAddr()
+}
+
+// roundTrip 发送请求并等待一个响应
+func roundTrip(t *testing.T, server *net.UDPAddr, req *stun.Message) *stun.Message {
+	t.Helper()
+	resp := exchange(t, server, stun.Encode(req), 2*time.Second)
+	require.NotNil(t, resp, "no response received")
+	assert.Equal(t, req.TransactionID, resp.TransactionID)
+	return resp
+}
+
+// exchange 发送原始报文，在超时时间内未收到响应时返回nil
+func exchange(t *testing.T, server *net.UDPAddr, data []byte, timeout time.Duration) *stun.Message {
+	t.Helper()
+	conn, err := net.DialUDP("udp", nil, server)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write(data)
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return nil
+	}
+	require.NoError(t, err)
+
+	resp, err := stun.Decode(buf[:n])
+	require.NoError(t, err)
+	return resp
+}
+
+func errorCode(msg *stun.Message) int {
+	code, _, _ := msg.GetErrorCode()
+	return code
+}
+
+func TestService_ShortTermCredentials(t *testing.T) {
+	svc, addr := startTestService(t)
+	svc.SetCredentialFunc(func(username string) (string, bool) {
+		if username == "alice:bob" {
+			return "password", true
+		}
+		return "", false
+	})
+
+	newRequest := func(username, password string) *stun.Message {
+		req := stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
+		if username != "" {
+			req.Attributes.Add(stun.AttributeTypeUsername, []byte(username))
+		}
+		if password != "" {
+			req.IntegrityKey = []byte(password)
+		}
+		return req
+	}
+
+	t.Run("签名正确的请求返回签名的成功响应", func(t *testing.T) {
+		resp := roundTrip(t, addr, newRequest("alice:bob", "password"))
+		assert.Equal(t, stun.MessageTypeBindingResponse, resp.Type)
+		assert.NoError(t, resp.CheckIntegrity([]byte("password")))
+	})
+
+	t.Run("携带FINGERPRINT的请求，响应也携带FINGERPRINT", func(t *testing.T) {
+		req := newRequest("alice:bob", "password")
+		req.Fingerprint = true
+		resp := roundTrip(t, addr, req)
+		assert.True(t, resp.Attributes.Has(stun.AttributeTypeFingerprint))
+		assert.NoError(t, resp.CheckIntegrity([]byte("password")))
+	})
+
+	t.Run("签名错误的请求返回401", func(t *testing.T) {
+		resp := roundTrip(t, addr, newRequest("alice:bob", "wrong"))
+		assert.Equal(t, stun.MessageTypeBindingErrorResponse, resp.Type)
+		assert.Equal(t, stun.ErrorCodeUnauthorized, errorCode(resp))
+	})
+
+	t.Run("未知用户返回401", func(t *testing.T) {
+		resp := roundTrip(t, addr, newRequest("mallory", "password"))
+		assert.Equal(t, stun.ErrorCodeUnauthorized, errorCode(resp))
+	})
+
+	t.Run("缺少USERNAME返回400", func(t *testing.T) {
+		resp := roundTrip(t, addr, newRequest("", "password"))
+		assert.Equal(t, stun.ErrorCodeBadRequest, errorCode(resp))
+	})
+
+	t.Run("匿名请求正常响应", func(t *testing.T) {
+		resp := roundTrip(t, addr, newRequest("", ""))
+		assert.Equal(t, stun.MessageTypeBindingResponse, resp.Type)
+		assert.False(t, resp.Attributes.Has(stun.AttributeTypeMessageIntegrity))
+	})
+
+	t.Run("未设置凭证时签名的请求返回401", func(t *testing.T) {
+		_, addr := startTestService(t)
+		resp := roundTrip(t, addr, newRequest("alice:bob", "password"))
+		assert.Equal(t, stun.ErrorCodeUnauthorized, errorCode(resp))
+		assert.False(t, resp.Attributes.Has(stun.AttributeTypeMessageIntegrity))
+
+		resp = roundTrip(t, addr, newRequest("", ""))
+		assert.Equal(t, stun.MessageTypeBindingResponse, resp.Type)
+	})
+}
+
+func TestService_DualStack(t *testing.T) {
+	svc := NewService(udp.NewService("[::]:0", nil))
+	require.NoError(t, svc.Start())
+	t.Cleanup(svc.Close)
+	port := svc.udpSvc.LocalAddr().Port
+
+	for _, ip := range []string{"127.0.0.1", "::1"} {
+		t.Run(ip, func(t *testing.T) {
+			conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP(ip), Port: port})
+			require.NoError(t, err)
+			defer conn.Close()
+
+			req := stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
+			_, err = conn.Write(stun.Encode(req))
+			require.NoError(t, err)
+
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			buf := make([]byte, 1024)
+			n, err := conn.Read(buf)
+			require.NoError(t, err)
+			resp, err := stun.Decode(buf[:n])
+			require.NoError(t, err)
+
+			mappedIP, mappedPort, err := resp.GetXORMappedAddress()
+			require.NoError(t, err)
+			localAddr := conn.LocalAddr().(*net.UDPAddr)
+			assert.True(t, mappedIP.Equal(localAddr.IP), "mapped %v, local %v", mappedIP, localAddr.IP)
+			assert.Equal(t, localAddr.Port, mappedPort)
+		})
+	}
+}
+
+func TestService_ErrorResponses(t *testing.T) {
+	_, addr := startTestService(t)
+	transactionID := [12]byte{12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+
+	t.Run("未知的必须理解属性返回420", func(t *testing.T) {
+		req := stun.NewMessage(stun.MessageTypeBindingRequest, transactionID)
+		req.Attributes.Add(0x0003, []byte{0, 0, 0, 0})
+		req.Attributes.Add(0x8001, []byte{0, 0, 0, 0}) // 可选理解属性，应被忽略
+		req.Attributes.Add(0x7F00, []byte{0, 0, 0, 0})
+
+		resp := roundTrip(t, addr, req)
+		assert.Equal(t, stun.MessageTypeBindingErrorResponse, resp.Type)
+		assert.Equal(t, stun.ErrorCodeUnknownAttribute, errorCode(resp))
+		unknown, err := resp.GetUnknownAttributes()
+		require.NoError(t, err)
+		assert.Equal(t, []uint16{0x0003, 0x7F00}, unknown)
+	})
+
+	t.Run("未知方法的请求返回400", func(t *testing.T) {
+		req := stun.NewMessage(0x0002, transactionID)
+		resp := roundTrip(t, addr, req)
+		assert.Equal(t, uint16(0x0112), resp.Type)
+		assert.Equal(t, stun.ErrorCodeBadRequest, errorCode(resp))
+	})
+
+	t.Run("属性格式错误的请求返回400", func(t *testing.T) {
+		data := stun.Encode(stun.NewMessage(stun.MessageTypeBindingRequest, transactionID))
+		data = append(data, 0x00, 0x06, 0x00, 0x10) // 属性长度超出报文
+		binary.BigEndian.PutUint16(data[2:4], 4)
+
+		resp := exchange(t, addr, data, 2*time.Second)
+		require.NotNil(t, resp)
+		assert.Equal(t, transactionID, resp.TransactionID)
+		assert.Equal(t, stun.ErrorCodeBadRequest, errorCode(resp))
+	})
+
+	t.Run("Binding指示作为保活不回复", func(t *testing.T) {
+		data := stun.Encode(stun.NewMessage(stun.MessageTypeBindingIndication, transactionID))
+		assert.Nil(t, exchange(t, addr, data, 300*time.Millisecond))
+	})
+
+	t.Run("非STUN报文和未知的指示不回复", func(t *testing.T) {
+		assert.Nil(t, exchange(t, addr, []byte("this is not a stun packet"), 300*time.Millisecond))
+
+		data := stun.Encode(stun.NewMessage(0x0012, transactionID))
+		assert.Nil(t, exchange(t, addr, data, 300*time.Millisecond))
+	})
+
+	t.Run("FINGERPRINT错误的报文不回复", func(t *testing.T) {
+		req := stun.NewMessage(stun.MessageTypeBindingRequest, transactionID)
+		req.Fingerprint = true
+		data := stun.Encode(req)
+		data[len(data)-1] ^= 0xFF
+		assert.Nil(t, exchange(t, addr, data, 300*time.Millisecond))
+	})
+}
+
+// bindingFrom 从同一个客户端套接字发送请求，返回响应及其来源地址
+func bindingFrom(t *testing.T, conn *net.UDPConn, server *net.UDPAddr, req *stun.Message) (*stun.Message, *net.UDPAddr) {
+	t.Helper()
+	_, err := conn.WriteToUDP(stun.Encode(req), server)
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	n, from, err := conn.ReadFromUDP(buf)
+	require.NoError(t, err)
+	resp, err := stun.Decode(buf[:n])
+	require.NoError(t, err)
+	return resp, from
+}
+
+func TestService_BehaviorDiscovery(t *testing.T) {
+	// Linux的回环网卡接管整个127.0.0.0/8，127.0.0.2无需额外配置即可使用
+	svc := NewService(udp.NewService("127.0.0.1:0", nil))
+	require.NoError(t, svc.SetBehaviorDiscovery("127.0.0.2", 0))
+	require.NoError(t, svc.Start())
+	t.Cleanup(svc.Close)
+
+	primary := svc.udpSvc.LocalAddr()
+	alternatePort := svc.behavior.alternatePort
+	require.NotEqual(t, primary.Port, alternatePort)
+
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer client.Close()
+
+	tests := []struct {
+		name       string
+		changeIP   bool
+		changePort bool
+		wantIP     string
+		wantPort   int
+	}{
+		{"不改变地址", false, false, "127.0.0.1", primary.Port},
+		{"改变端口", false, true, "127.0.0.1", alternatePort},
+		{"改变IP", true, false, "127.0.0.2", primary.Port},
+		{"改变IP和端口", true, true, "127.0.0.2", alternatePort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{1, 2, 3})
+			req.SetChangeRequest(tt.changeIP, tt.changePort)
+
+			resp, from := bindingFrom(t, client, primary, req)
+			require.Equal(t, stun.MessageTypeBindingResponse, resp.Type)
+			assert.True(t, from.IP.Equal(net.ParseIP(tt.wantIP)), "response from %s", from)
+			assert.Equal(t, tt.wantPort, from.Port)
+
+			originIP, originPort, err := resp.GetResponseOrigin()
+			require.NoError(t, err)
+			assert.True(t, originIP.Equal(from.IP))
+			assert.Equal(t, from.Port, originPort)
+
+			otherIP, otherPort, err := resp.GetOtherAddress()
+			require.NoError(t, err)
+			assert.True(t, otherIP.Equal(net.ParseIP("127.0.0.2")))
+			assert.Equal(t, alternatePort, otherPort)
+		})
+	}
+
+	t.Run("备用地址收到的请求，OTHER-ADDRESS指向主地址", func(t *testing.T) {
+		alternate := &net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: alternatePort}
+		resp, from := bindingFrom(t, client, alternate, stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{4}))
+		assert.Equal(t, alternate.String(), from.String())
+
+		otherIP, otherPort, err := resp.GetOtherAddress()
+		require.NoError(t, err)
+		assert.True(t, otherIP.Equal(primary.IP))
+		assert.Equal(t, primary.Port, otherPort)
+	})
+}
+
+func TestService_ResponsePort(t *testing.T) {
+	svc := NewService(udp.NewService("127.0.0.1:0", nil))
+	require.NoError(t, svc.SetBehaviorDiscovery("", 0))
+	require.NoError(t, svc.Start())
+	t.Cleanup(svc.Close)
+
+	sender, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer sender.Close()
+	receiver, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer receiver.Close()
+
+	// 响应发往来源IP上RESPONSE-PORT指定的端口，XOR-MAPPED-ADDRESS仍是请求的来源
+	req := stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{5})
+	req.SetResponsePort(receiver.LocalAddr().(*net.UDPAddr).Port)
+	_, err = sender.WriteToUDP(stun.Encode(req), svc.LocalAddr())
+	require.NoError(t, err)
+
+	receiver.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	n, err := receiver.Read(buf)
+	require.NoError(t, err)
+	resp, err := stun.Decode(buf[:n])
+	require.NoError(t, err)
+	assert.Equal(t, req.TransactionID, resp.TransactionID)
+	ip, port, err := resp.GetXORMappedAddress()
+	require.NoError(t, err)
+	assert.Equal(t, sender.LocalAddr().String(), (&net.UDPAddr{IP: ip, Port: port}).String())
+
+	t.Run("RESPONSE-PORT长度错误返回400", func(t *testing.T) {
+		req := stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{6})
+		req.Attributes.Set(stun.AttributeTypeResponsePort, []byte{0x01})
+		resp, _ := bindingFrom(t, sender, svc.LocalAddr(), req)
+		assert.Equal(t, stun.ErrorCodeBadRequest, errorCode(resp))
+	})
+
+	t.Run("未启用NAT行为发现时返回420", func(t *testing.T) {
+		_, addr := startTestService(t)
+		resp, _ := bindingFrom(t, sender, addr, req)
+		assert.Equal(t, stun.ErrorCodeUnknownAttribute, errorCode(resp))
+	})
+}
+
+func TestService_BehaviorDiscoveryWithoutAlternateIP(t *testing.T) {
+	svc := NewService(udp.NewService("127.0.0.1:0", nil))
+	require.NoError(t, svc.SetBehaviorDiscovery("", 0))
+	require.NoError(t, svc.Start())
+	t.Cleanup(svc.Close)
+
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer client.Close()
+
+	req := stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{1})
+	req.SetChangeRequest(false, true)
+	resp, from := bindingFrom(t, client, svc.udpSvc.LocalAddr(), req)
+	assert.Equal(t, stun.MessageTypeBindingResponse, resp.Type)
+	assert.Equal(t, svc.behavior.alternatePort, from.Port)
+	assert.False(t, resp.Attributes.Has(stun.AttributeTypeOtherAddress))
+
+	// 没有备用IP时不支持改变IP
+	req.SetChangeRequest(true, false)
+	resp, _ = bindingFrom(t, client, svc.udpSvc.LocalAddr(), req)
+	assert.Equal(t, stun.ErrorCodeUnknownAttribute, errorCode(resp))
+	unknown, err := resp.GetUnknownAttributes()
+	require.NoError(t, err)
+	assert.Equal(t, []uint16{stun.AttributeTypeChangeRequest}, unknown)
+}
+
+func TestService_BehaviorDiscoveryUnspecifiedAddress(t *testing.T) {
+	svc := NewService(udp.NewService(":0", nil))
+	require.NoError(t, svc.SetBehaviorDiscovery("", 0))
+	require.NoError(t, svc.Start())
+	t.Cleanup(svc.Close)
+
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer client.Close()
+	server := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: svc.udpSvc.LocalAddr().Port}
+
+	// 监听未指定的IP时不能公布[::]作为RESPONSE-ORIGIN
+	for _, changePort := range []bool{false, true} {
+		req := stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{1})
+		req.SetChangeRequest(false, changePort)
+		resp, _ := bindingFrom(t, client, server, req)
+		assert.Equal(t, stun.MessageTypeBindingResponse, resp.Type)
+		assert.False(t, resp.Attributes.Has(stun.AttributeTypeResponseOrigin))
+	}
+}
+
+// legacyExchange 发送RFC 3489请求并按兼容格式解码响应，未收到响应时返回nil
+func legacyExchange(t *testing.T, conn *net.UDPConn, server *net.UDPAddr, req *stun.Message) (*stun.Message, *net.UDPAddr) {
+	t.Helper()
+	_, err := conn.WriteToUDP(stun.Encode(req), server)
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	buf := make([]byte, 1024)
+	n, from, err := conn.ReadFromUDP(buf)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return nil, nil
+	}
+	require.NoError(t, err)
+
+	resp := &stun.Message{}
+	require.NoError(t, stun.DecodeLegacyInto(resp, buf[:n]))
+	return resp, from
+}
+
+func newLegacyRequest() *stun.Message {
+	req := stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{1, 2, 3})
+	req.Legacy = true
+	req.LegacyPrefix = [4]byte{0xde, 0xad, 0xbe, 0xef}
+	return req
+}
+
+func TestService_LegacyCompatibility(t *testing.T) {
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer client.Close()
+	local := client.LocalAddr().(*net.UDPAddr)
+
+	t.Run("未启用时忽略没有magic cookie的请求", func(t *testing.T) {
+		_, server := startTestService(t)
+		resp, _ := legacyExchange(t, client, server, newLegacyRequest())
+		assert.Nil(t, resp)
+	})
+
+	t.Run("启用后回复MAPPED-ADDRESS和SOURCE-ADDRESS", func(t *testing.T) {
+		svc := NewService(udp.NewService("127.0.0.1:0", nil))
+		svc.SetLegacyCompatibility(true)
+		require.NoError(t, svc.Start())
+		t.Cleanup(svc.Close)
+		server := svc.LocalAddr()
+
+		req := newLegacyRequest()
+		resp, _ := legacyExchange(t, client, server, req)
+		require.NotNil(t, resp)
+		assert.Equal(t, stun.MessageTypeBindingResponse, resp.Type)
+		assert.True(t, resp.Legacy)
+		assert.Equal(t, req.LegacyPrefix, resp.LegacyPrefix)
+		assert.Equal(t, req.TransactionID, resp.TransactionID)
+		assert.False(t, resp.Attributes.Has(stun.AttributeTypeXORMappedAddress))
+
+		ip, port, err := resp.GetMappedAddress()
+		require.NoError(t, err)
+		assert.True(t, ip.Equal(local.IP))
+		assert.Equal(t, local.Port, port)
+
+		ip, port, err = resp.GetSourceAddress()
+		require.NoError(t, err)
+		assert.True(t, ip.Equal(server.IP))
+		assert.Equal(t, server.Port, port)
+		assert.False(t, resp.Attributes.Has(stun.AttributeTypeChangedAddress))
+
+		// RFC 5389客户端不受影响
+		resp = roundTrip(t, server, stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{4}))
+		assert.False(t, resp.Legacy)
+		assert.True(t, resp.Attributes.Has(stun.AttributeTypeXORMappedAddress))
+	})
+
+	t.Run("启用NAT行为发现时回复CHANGED-ADDRESS并支持CHANGE-REQUEST", func(t *testing.T) {
+		svc := NewService(udp.NewService("127.0.0.1:0", nil))
+		svc.SetLegacyCompatibility(true)
+		require.NoError(t, svc.SetBehaviorDiscovery("127.0.0.2", 0))
+		require.NoError(t, svc.Start())
+		t.Cleanup(svc.Close)
+		alternatePort := svc.behavior.alternatePort
+
+		req := newLegacyRequest()
+		req.SetChangeRequest(true, true)
+		resp, from := legacyExchange(t, client, svc.LocalAddr(), req)
+		require.NotNil(t, resp)
+		assert.True(t, resp.Legacy)
+		assert.True(t, from.IP.Equal(net.ParseIP("127.0.0.2")))
+		assert.Equal(t, alternatePort, from.Port)
+
+		ip, port, err := resp.GetSourceAddress()
+		require.NoError(t, err)
+		assert.Equal(t, from.String(), (&net.UDPAddr{IP: ip, Port: port}).String())
+
+		ip, port, err = resp.GetChangedAddress()
+		require.NoError(t, err)
+		assert.True(t, ip.Equal(net.ParseIP("127.0.0.2")))
+		assert.Equal(t, alternatePort, port)
+		assert.False(t, resp.Attributes.Has(stun.AttributeTypeResponseOrigin))
+		assert.False(t, resp.Attributes.Has(stun.AttributeTypeOtherAddress))
+	})
+
+	t.Run("错误响应同样使用RFC 3489格式", func(t *testing.T) {
+		svc := NewService(udp.NewService("127.0.0.1:0", nil))
+		svc.SetLegacyCompatibility(true)
+		require.NoError(t, svc.Start())
+		t.Cleanup(svc.Close)
+
+		// 未启用NAT行为发现时CHANGE-REQUEST是未知的必须理解属性
+		req := newLegacyRequest()
+		req.SetChangeRequest(false, true)
+		resp, _ := legacyExchange(t, client, svc.LocalAddr(), req)
+		require.NotNil(t, resp)
+		assert.True(t, resp.Legacy)
+		assert.Equal(t, req.LegacyPrefix, resp.LegacyPrefix)
+		assert.Equal(t, stun.ErrorCodeUnknownAttribute, errorCode(resp))
+	})
+}
+
+func TestService_Redirect(t *testing.T) {
+	startRedirecting := func(t *testing.T, alternate string, policy RedirectPolicy) *Service {
+		svc := NewService(udp.NewService("127.0.0.1:0", nil))
+		require.NoError(t, svc.SetAlternateServer(alternate, policy))
+		require.NoError(t, svc.Start())
+		t.Cleanup(svc.Close)
+		return svc
+	}
+	binding := func(id byte) *stun.Message {
+		return stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{id})
+	}
+
+	t.Run("始终重定向", func(t *testing.T) {
+		svc := startRedirecting(t, "127.0.0.1:3479", RedirectAlways)
+		resp := roundTrip(t, svc.LocalAddr(), binding(1))
+		assert.Equal(t, stun.MessageTypeBindingErrorResponse, resp.Type)
+		assert.Equal(t, stun.ErrorCodeTryAlternate, errorCode(resp))
+		ip, port, err := resp.GetAlternateServer()
+		require.NoError(t, err)
+		assert.Equal(t, "127.0.0.1:3479", (&net.UDPAddr{IP: ip, Port: port}).String())
+	})
+
+	t.Run("超过速率上限的请求被重定向", func(t *testing.T) {
+		svc := startRedirecting(t, "127.0.0.1:3479", NewRateRedirect(2))
+		for i := byte(1); i <= 2; i++ {
+			assert.Equal(t, stun.MessageTypeBindingResponse, roundTrip(t, svc.LocalAddr(), binding(i)).Type)
+		}
+		assert.Equal(t, stun.ErrorCodeTryAlternate, errorCode(roundTrip(t, svc.LocalAddr(), binding(3))))
+	})
+
+	t.Run("排空期间重定向", func(t *testing.T) {
+		drain := NewDrainRedirect()
+		svc := startRedirecting(t, "127.0.0.1:3479", drain)
+		assert.Equal(t, stun.MessageTypeBindingResponse, roundTrip(t, svc.LocalAddr(), binding(1)).Type)
+
+		drain.SetDraining(true)
+		assert.Equal(t, stun.ErrorCodeTryAlternate, errorCode(roundTrip(t, svc.LocalAddr(), binding(2))))
+
+		drain.SetDraining(false)
+		assert.Equal(t, stun.MessageTypeBindingResponse, roundTrip(t, svc.LocalAddr(), binding(3)).Type)
+	})
+
+	t.Run("备用服务器地址族不同时不重定向", func(t *testing.T) {
+		svc := startRedirecting(t, "[::1]:3479", RedirectAlways)
+		assert.Equal(t, stun.MessageTypeBindingResponse, roundTrip(t, svc.LocalAddr(), binding(1)).Type)
+	})
+
+	t.Run("认证后的重定向响应带MESSAGE-INTEGRITY", func(t *testing.T) {
+		svc := NewService(udp.NewService("127.0.0.1:0", nil))
+		svc.SetCredentialFunc(func(username string) (string, bool) { return "secret", username == "alice" })
+		require.NoError(t, svc.SetAlternateServer("127.0.0.1:3479", RedirectAlways))
+		require.NoError(t, svc.Start())
+		t.Cleanup(svc.Close)
+
+		req := binding(1)
+		require.NoError(t, req.SetUsername("alice"))
+		req.IntegrityKey = []byte("secret")
+		resp := roundTrip(t, svc.LocalAddr(), req)
+		assert.Equal(t, stun.ErrorCodeTryAlternate, errorCode(resp))
+		assert.NoError(t, resp.CheckIntegrity([]byte("secret")))
+
+		// 认证失败的请求不会得到备用服务器地址
+		req.IntegrityKey = []byte("wrong")
+		assert.Equal(t, stun.ErrorCodeUnauthorized, errorCode(roundTrip(t, svc.LocalAddr(), req)))
+	})
+
+	t.Run("RFC 3489客户端不被重定向", func(t *testing.T) {
+		svc := NewService(udp.NewService("127.0.0.1:0", nil))
+		svc.SetLegacyCompatibility(true)
+		require.NoError(t, svc.SetAlternateServer("127.0.0.1:3479", RedirectAlways))
+		require.NoError(t, svc.Start())
+		t.Cleanup(svc.Close)
+
+		client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+		require.NoError(t, err)
+		defer client.Close()
+		resp, _ := legacyExchange(t, client, svc.LocalAddr(), newLegacyRequest())
+		require.NotNil(t, resp)
+		assert.Equal(t, stun.MessageTypeBindingResponse, resp.Type)
+	})
+
+	t.Run("备用服务器地址必须是具体的IP", func(t *testing.T) {
+		svc := NewService(udp.NewService("127.0.0.1:0", nil))
+		assert.Error(t, svc.SetAlternateServer(":3479", RedirectAlways))
+		assert.Error(t, svc.SetAlternateServer("not an address", RedirectAlways))
+	})
+}
+
+func TestService_ICE(t *testing.T) {
+	const (
+		username = "server:browser"
+		password = "ice-password"
+	)
+	checks := make(chan ICECheck, 10)
+	svc := NewService(udp.NewService("127.0.0.1:0", nil))
+	svc.SetCredentialFunc(func(u string) (string, bool) { return password, u == username })
+	svc.SetICEAgent(stun.RoleControlled, 100)
+	svc.SetICECheckHandler(func(check ICECheck) { checks <- check })
+	require.NoError(t, svc.Start())
+	t.Cleanup(svc.Close)
+	server := svc.LocalAddr()
+
+	newCheck := func(id byte) *stun.Message {
+		req := stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{id})
+		require.NoError(t, req.SetUsername(username))
+		req.SetPriority(0x6e0001ff)
+		req.IntegrityKey = []byte(password)
+		req.Fingerprint = true
+		return req
+	}
+
+	t.Run("controlling对端的提名检查", func(t *testing.T) {
+		req := newCheck(1)
+		req.SetICEControlling(200)
+		req.SetUseCandidate()
+		resp := roundTrip(t, server, req)
+		require.Equal(t, stun.MessageTypeBindingResponse, resp.Type)
+		assert.NoError(t, resp.CheckIntegrity([]byte(password)))
+		assert.True(t, resp.Attributes.Has(stun.AttributeTypeFingerprint))
+
+		check := <-checks
+		assert.Equal(t, username, check.Username)
+		assert.Equal(t, uint32(0x6e0001ff), check.Priority)
+		assert.True(t, check.UseCandidate)
+		assert.Equal(t, stun.RoleControlled, svc.ICERole())
+	})
+
+	t.Run("双方controlled且对端tie-breaker较大，回复487", func(t *testing.T) {
+		req := newCheck(2)
+		req.SetICEControlled(200)
+		resp := roundTrip(t, server, req)
+		assert.Equal(t, stun.ErrorCodeRoleConflict, errorCode(resp))
+		assert.NoError(t, resp.CheckIntegrity([]byte(password)))
+		assert.Equal(t, stun.RoleControlled, svc.ICERole())
+	})
+
+	t.Run("双方controlled且本端tie-breaker较大，切换为controlling", func(t *testing.T) {
+		req := newCheck(3)
+		req.SetICEControlled(50)
+		assert.Equal(t, stun.MessageTypeBindingResponse, roundTrip(t, server, req).Type)
+		assert.Equal(t, stun.RoleControlling, svc.ICERole())
+		<-checks
+	})
+
+	t.Run("没有短期凭证的连通性检查回复400", func(t *testing.T) {
+		req := stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{4})
+		req.SetICEControlling(1)
+		assert.Equal(t, stun.ErrorCodeBadRequest, errorCode(roundTrip(t, server, req)))
+	})
+
+	t.Run("未启用ICE时PRIORITY是未知属性", func(t *testing.T) {
+		_, plain := startTestService(t)
+		req := stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{5})
+		req.SetPriority(1)
+		resp := roundTrip(t, plain, req)
+		assert.Equal(t, stun.ErrorCodeUnknownAttribute, errorCode(resp))
+	})
+}
+
+func TestService_TCP(t *testing.T) {
+	svc := NewService(udp.NewService("127.0.0.1:0", nil))
+	svc.SetTCPServer(tcp.NewService("127.0.0.1:0", stun.SplitMessages, nil))
+	require.NoError(t, svc.SetBehaviorDiscovery("", 0))
+	require.NoError(t, svc.Start())
+	t.Cleanup(svc.Close)
+
+	conn, err := net.Dial("tcp", svc.TCPAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.TCPAddr)
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Split(stun.SplitMessages)
+	readResponse := func() *stun.Message {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		require.True(t, scanner.Scan(), "no response: %v", scanner.Err())
+		resp, err := stun.Decode(scanner.Bytes())
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("一个报文段包含多个请求", func(t *testing.T) {
+		var segment []byte
+		for i := byte(1); i <= 3; i++ {
+			segment = append(segment, stun.Encode(stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{i}))...)
+		}
+		_, err := conn.Write(segment)
+		require.NoError(t, err)
+
+		for i := byte(1); i <= 3; i++ {
+			resp := readResponse()
+			assert.Equal(t, [12]byte{i}, resp.TransactionID)
+			ip, port, err := resp.GetXORMappedAddress()
+			require.NoError(t, err)
+			assert.True(t, ip.Equal(local.IP))
+			assert.Equal(t, local.Port, port)
+		}
+	})
+
+	t.Run("请求分多次到达", func(t *testing.T) {
+		data := stun.Encode(stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{4}))
+		for _, part := range [][]byte{data[:7], data[7:20], data[20:]} {
+			_, err := conn.Write(part)
+			require.NoError(t, err)
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, [12]byte{4}, readResponse().TransactionID)
+	})
+
+	t.Run("TCP上不支持CHANGE-REQUEST", func(t *testing.T) {
+		req := stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{5})
+		req.SetChangeRequest(false, true)
+		_, err := conn.Write(stun.Encode(req))
+		require.NoError(t, err)
+		assert.Equal(t, stun.ErrorCodeUnknownAttribute, errorCode(readResponse()))
+	})
+}
+
+func TestService_TLS(t *testing.T) {
+	cert, err := testutil.SelfSignedCert("127.0.0.1")
+	require.NoError(t, err)
+	tlsSvc := tcp.NewService("127.0.0.1:0", stun.SplitMessages, nil)
+	tlsSvc.SetTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}})
+
+	svc := NewService(udp.NewService("127.0.0.1:0", nil))
+	svc.SetTLSServer(tlsSvc)
+	require.NoError(t, svc.Start())
+	t.Cleanup(svc.Close)
+
+	conn, err := tls.Dial("tcp", svc.TLSAddr().String(), &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	req := stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{1})
+	_, err = conn.Write(stun.Encode(req))
+	require.NoError(t, err)
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Split(stun.SplitMessages)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	require.True(t, scanner.Scan(), "no response: %v", scanner.Err())
+	resp, err := stun.Decode(scanner.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, req.TransactionID, resp.TransactionID)
+
+	ip, port, err := resp.GetXORMappedAddress()
+	require.NoError(t, err)
+	local := conn.LocalAddr().(*net.TCPAddr)
+	assert.True(t, ip.Equal(local.IP))
+	assert.Equal(t, local.Port, port)
+}
+
+func TestService_Demux(t *testing.T) {
+	svc := NewService(udp.NewService("127.0.0.1:0", nil))
+	media := make(chan []byte, 1)
+	svc.Demux().Handle(udp.ProtocolRTP, func(conn *udp.Connection, packet []byte) {
+		media <- append([]byte(nil), packet...)
+		conn.Write([]byte{0x80, 0x00}) // 回写一个RTP包
+	})
+	require.NoError(t, svc.Start())
+	t.Cleanup(svc.Close)
+	server := svc.LocalAddr()
+
+	conn, err := net.DialUDP("udp", nil, server)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// RTP包交给注册的处理函数
+	rtp := []byte{0x80, 0x60, 0x00, 0x01, 0, 0, 0, 0}
+	_, err = conn.Write(rtp)
+	require.NoError(t, err)
+	select {
+	case got := <-media:
+		assert.Equal(t, rtp, got)
+	case <-time.After(2 * time.Second):
+		t.Fatal("RTP packet not delivered")
+	}
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x80, 0x00}, buf[:n])
+
+	// 同一端口上的STUN请求照常应答
+	req := stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{7})
+	resp := roundTrip(t, server, req)
+	assert.Equal(t, stun.MessageTypeBindingResponse, resp.Type)
+
+	// 未注册的DTLS报文被丢弃，不会产生响应
+	assert.Nil(t, exchange(t, server, []byte{22, 0xfe, 0xfd, 0, 0}, 200*time.Millisecond))
+}
+
+func TestService_HandleMethod(t *testing.T) {
+	svc := NewService(udp.NewService("127.0.0.1:0", nil))
+	const method = 0x003
+	requests := make(chan *Request, 1)
+	svc.HandleMethod(method, func(r *Request) {
+		requests <- &Request{Remote: r.Remote, Local: r.Local, UDP: r.UDP}
+		resp := stun.NewMessage(stun.NewMessageType(method, stun.ClassSuccessResponse), r.Message.TransactionID)
+		require.NoError(t, r.Write(resp))
+	})
+	svc.HandleMethod(stun.MethodBinding, func(*Request) { t.Error("Binding handler must not be registered") })
+	require.NoError(t, svc.Start())
+	t.Cleanup(svc.Close)
+	server := svc.LocalAddr()
+
+	req := stun.NewMessage(stun.NewMessageType(method, stun.ClassRequest), [12]byte{3})
+	resp := roundTrip(t, server, req)
+	assert.Equal(t, stun.NewMessageType(method, stun.ClassSuccessResponse), resp.Type)
+	got := <-requests
+	assert.NotNil(t, got.UDP)
+	assert.Equal(t, server.String(), got.Local.String())
+
+	// Binding仍由STUN服务处理，未注册的方法回复400
+	resp = roundTrip(t, server, stun.NewMessage(stun.MessageTypeBindingRequest, [12]byte{4}))
+	assert.Equal(t, stun.MessageTypeBindingResponse, resp.Type)
+	resp = roundTrip(t, server, stun.NewMessage(stun.NewMessageType(0x004, stun.ClassRequest), [12]byte{5}))
+	assert.Equal(t, stun.ErrorCodeBadRequest, errorCode(resp))
+}
@@ -0,0 +1,100 @@
+package stun
+
+import (
+	"net"
+	"webRTCInfra/pkg/network/tcp"
+	"webRTCInfra/pkg/network/udp"
+	"webRTCInfra/pkg/protocol/stun"
+)
+
+// peer 请求的来源连接，UDP和TCP请求共用同一套处理逻辑
+type peer struct {
+	writer
+	remote net.Addr
+	local  net.Addr
+	udp    *udp.Connection // UDP请求时非空，NAT行为发现只支持UDP
+	tcp    *tcp.Connection // TCP或TLS请求时非空
+}
+
+func udpPeer(conn *udp.Connection) peer {
+	return peer{writer: conn, remote: conn.GetRemoteAddr(), local: conn.LocalAddr(), udp: conn}
+}
+
+func tcpPeer(conn *tcp.Connection) peer {
+	return peer{writer: conn, remote: conn.GetRemoteAddr(), local: conn.LocalAddr(), tcp: conn}
+}
+
+// IPPort 取出UDP或TCP地址中的IP和端口，其他类型的地址返回nil和0
+func IPPort(addr net.Addr) (net.IP, int) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP, a.Port
+	case *net.TCPAddr:
+		return a.IP, a.Port
+	default:
+		return nil, 0
+	}
+}
+
+// SetTCPServer 同时通过TCP提供服务（RFC 8489 6.2.2），需在Start之前调用。
+// tcpSvc应使用stun.SplitMessages切分字节流；NAT行为发现只在UDP上提供
+func (s *Service) SetTCPServer(tcpSvc *tcp.Server) {
+	s.tcpSvc = tcpSvc
+	tcpSvc.SetOnMessage(s.handleTCPMessage)
+	tcpSvc.SetOnClose(s.handleTCPClose)
+}
+
+// SetTLSServer 同时通过TLS提供服务（RFC 8489 6.2.3，默认端口5349），需在Start之前调用。
+// tlsSvc需已通过SetTLSConfig配置证书，处理逻辑与TCP相同
+func (s *Service) SetTLSServer(tlsSvc *tcp.Server) {
+	s.tlsSvc = tlsSvc
+	tlsSvc.SetOnMessage(s.handleTCPMessage)
+	tlsSvc.SetOnClose(s.handleTCPClose)
+}
+
+// TCPAddr 返回TCP的监听地址，未启用TCP或尚未Start时返回nil
+func (s *Service) TCPAddr() *net.TCPAddr {
+	if s.tcpSvc == nil {
+		return nil
+	}
+	return s.tcpSvc.LocalAddr()
+}
+
+// TLSAddr 返回TLS的监听地址，未启用TLS或尚未Start时返回nil
+func (s *Service) TLSAddr() *net.TCPAddr {
+	if s.tlsSvc == nil {
+		return nil
+	}
+	return s.tlsSvc.LocalAddr()
+}
+
+// streamServers 返回已启用的TCP和TLS服务器
+func (s *Service) streamServers() []*tcp.Server {
+	var servers []*tcp.Server
+	for _, srv := range []*tcp.Server{s.tcpSvc, s.tlsSvc} {
+		if srv != nil {
+			servers = append(servers, srv)
+		}
+	}
+	return servers
+}
+
+func (s *Service) handlePacket(conn *udp.Connection, data []byte) {
+	s.handleMessage(udpPeer(conn), data)
+}
+
+func (s *Service) handleTCPMessage(conn *tcp.Connection, data []byte) {
+	if stun.IsChannelData(data) {
+		if s.channelData != nil {
+			s.channelData(conn.GetRemoteAddr(), conn.LocalAddr(), data)
+		}
+		return
+	}
+	s.handleMessage(tcpPeer(conn), data)
+}
+
+func (s *Service) handleTCPClose(conn *tcp.Connection) {
+	if s.streamClose != nil {
+		s.streamClose(conn.GetRemoteAddr(), conn.LocalAddr())
+	}
+}
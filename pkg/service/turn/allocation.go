@@ -0,0 +1,208 @@
+package turn
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+	stunservice "webRTCInfra/pkg/service/stun"
+)
+
+// fiveTuple 标识一个分配：客户端地址、服务器地址和传输协议。
+// 使用netip.AddrPort作为键，ChannelData的查找不产生内存分配
+type fiveTuple struct {
+	network string
+	client  netip.AddrPort
+	server  netip.AddrPort
+}
+
+func newFiveTuple(remote, local net.Addr) fiveTuple {
+	return fiveTuple{network: remote.Network(), client: addrPort(remote), server: addrPort(local)}
+}
+
+func requestTuple(r *stunservice.Request) fiveTuple {
+	return newFiveTuple(r.Remote, r.Local)
+}
+
+func (t fiveTuple) String() string {
+	return t.network + " " + t.client.String() + "->" + t.server.String()
+}
+
+// addrPort 将UDP或TCP地址转换为netip.AddrPort，IPv4映射的IPv6地址还原为IPv4
+func addrPort(addr net.Addr) netip.AddrPort {
+	var ap netip.AddrPort
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ap = a.AddrPort()
+	case *net.TCPAddr:
+		ap = a.AddrPort()
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
+
+// allocation 为一个客户端分配的中继
+type allocation struct {
+	tuple         fiveTuple
+	username      string
+	transactionID [12]byte         // 创建分配的Allocate请求，用于识别重传
+	transport     byte             // stun.TransportUDP或stun.TransportTCP
+	relay         *net.UDPConn     // UDP分配的中继套接字
+	listener      *net.TCPListener // TCP分配的中继监听（RFC 6062）
+	relayAddr     netip.AddrPort   // 在XOR-RELAYED-ADDRESS中公布的中继地址
+	ports         *portAllocator   // 中继端口所属的端口范围，未设置端口范围时为nil
+	limiter       *tokenBucket     // 分配的带宽限制，为nil时不限制
+	userLimiter   *tokenBucket     // 用户所有分配共用的带宽限制，为nil时不限制
+	client        writer           // 向客户端发送Data指示、ChannelData和ConnectionAttempt指示
+
+	mu          sync.Mutex
+	closed      bool
+	expires     time.Time
+	permissions map[netip.Addr]time.Time           // 对端IP -> 许可的过期时间
+	channels    map[uint16]*channelBinding         // 通道号 -> 绑定
+	peers       map[netip.AddrPort]*channelBinding // 对端地址 -> 绑定
+	connections map[netip.AddrPort]*tcpConnection  // 对端地址 -> TCP分配中与对端的连接
+}
+
+func newAllocation(tuple fiveTuple, username string, r *stunservice.Request) *allocation {
+	return &allocation{
+		tuple:         tuple,
+		username:      username,
+		transactionID: r.Message.TransactionID,
+		client:        clientWriter(r),
+		permissions:   make(map[netip.Addr]time.Time),
+		channels:      make(map[uint16]*channelBinding),
+		peers:         make(map[netip.AddrPort]*channelBinding),
+		connections:   make(map[netip.AddrPort]*tcpConnection),
+	}
+}
+
+// writer 客户端连接，udp.Connection和tcp.Connection都满足
+type writer interface {
+	Write(data []byte) error
+}
+
+// clientWriter 返回请求来源的连接
+func clientWriter(r *stunservice.Request) writer {
+	if r.UDP != nil {
+		return r.UDP
+	}
+	return r.TCP
+}
+
+// refresh 将有效期延长到从现在起lifetime
+func (a *allocation) refresh(lifetime time.Duration) {
+	a.mu.Lock()
+	a.expires = time.Now().Add(lifetime)
+	a.mu.Unlock()
+}
+
+// remaining 返回剩余的有效期，精确到秒
+func (a *allocation) remaining() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return time.Until(a.expires).Round(time.Second)
+}
+
+func (a *allocation) expired(now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return !now.Before(a.expires)
+}
+
+// close 释放中继套接字或监听，并关闭与对端的所有TCP连接，中继的读取循环随之退出
+func (a *allocation) close() {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return
+	}
+	a.closed = true
+	connections := a.connections
+	a.connections = make(map[netip.AddrPort]*tcpConnection)
+	a.mu.Unlock()
+
+	if a.relay != nil {
+		a.relay.Close()
+	}
+	if a.listener != nil {
+		a.listener.Close()
+	}
+	for _, c := range connections {
+		c.close()
+	}
+	if a.ports != nil {
+		a.ports.release(int(a.relayAddr.Port()), time.Now())
+	}
+}
+
+// allocation 返回5元组对应的分配，不存在时返回nil
+func (s *Service) allocation(tuple fiveTuple) *allocation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.allocations[tuple]
+}
+
+// deleteAllocation 删除分配并释放中继
+func (s *Service) deleteAllocation(a *allocation) {
+	s.mu.Lock()
+	s.removeAllocationLocked(a)
+	s.mu.Unlock()
+	a.close()
+}
+
+// handleStreamClose TCP或TLS控制连接关闭时删除其上的分配（RFC 6062 4.1、RFC 8656 3.1）
+func (s *Service) handleStreamClose(remote, local net.Addr) {
+	if a := s.allocation(newFiveTuple(remote, local)); a != nil {
+		log.Printf("TURN allocation %s deleted: control connection closed", a.tuple)
+		s.deleteAllocation(a)
+	}
+}
+
+// relayIPFor 返回中继监听的IP
+func (s *Service) relayIPFor(local net.Addr) (net.IP, error) {
+	ip := s.relayIP
+	if ip == nil {
+		// 未配置中继IP时使用接收请求的本地IP
+		ip, _ = stunservice.IPPort(local)
+	}
+	if ip == nil || ip.IsUnspecified() {
+		return nil, errNoRelayIP
+	}
+	return ip, nil
+}
+
+// allocateRelay 在中继IP上监听一个UDP端口
+func (s *Service) allocateRelay(local net.Addr) (*net.UDPConn, error) {
+	ip, err := s.relayIPFor(local)
+	if err != nil {
+		return nil, err
+	}
+	var conn *net.UDPConn
+	_, err = s.listenRelay(func(port int) (err error) {
+		conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: port})
+		return err
+	})
+	return conn, err
+}
+
+// allocateTCPRelay 在中继IP上监听一个TCP端口
+func (s *Service) allocateTCPRelay(local net.Addr) (*net.TCPListener, error) {
+	ip, err := s.relayIPFor(local)
+	if err != nil {
+		return nil, err
+	}
+	// Connect发起的连接绑定同一中继地址，监听套接字需设置SO_REUSEPORT
+	lc := net.ListenConfig{Control: reusePortControl}
+	var listener *net.TCPListener
+	_, err = s.listenRelay(func(port int) error {
+		l, err := lc.Listen(context.Background(), "tcp", (&net.TCPAddr{IP: ip, Port: port}).String())
+		if err != nil {
+			return err
+		}
+		listener = l.(*net.TCPListener)
+		return nil
+	})
+	return listener, err
+}
@@ -0,0 +1,85 @@
+package turn
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+	"webRTCInfra/pkg/protocol/stun"
+)
+
+// NonceLifetime NONCE的有效期，过期后请求收到438 Stale Nonce
+var NonceLifetime = 10 * time.Minute
+
+// CredentialFunc 根据USERNAME查找长期凭证的密码，用户不存在时返回false
+type CredentialFunc func(username string) (password string, ok bool)
+
+// nonceSigner 生成和校验无状态的NONCE：过期时间的十六进制 + HMAC-SHA1签名
+type nonceSigner struct {
+	secret []byte
+}
+
+func newNonceSigner() *nonceSigner {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("turn: failed to generate nonce secret: %v", err))
+	}
+	return &nonceSigner{secret: secret}
+}
+
+func (n *nonceSigner) generate(expires time.Time) string {
+	ts := strconv.FormatInt(expires.Unix(), 16)
+	return ts + n.sign(ts)
+}
+
+func (n *nonceSigner) sign(ts string) string {
+	mac := hmac.New(sha1.New, n.secret)
+	mac.Write([]byte(ts))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// valid 判断NONCE是否由本服务签发且未过期
+func (n *nonceSigner) valid(nonce string) bool {
+	const sigLen = sha1.Size * 2
+	if len(nonce) <= sigLen {
+		return false
+	}
+	ts, sig := nonce[:len(nonce)-sigLen], nonce[len(nonce)-sigLen:]
+	if !hmac.Equal([]byte(sig), []byte(n.sign(ts))) {
+		return false
+	}
+	expires, err := strconv.ParseInt(ts, 16, 64)
+	return err == nil && time.Now().Unix() < expires
+}
+
+// authenticate 按RFC 8489 9.2.4校验长期凭证。
+// 返回用户名和用于响应签名的密钥；校验失败时返回对应的错误码，401和438需要携带REALM和NONCE
+func (s *Service) authenticate(msg *stun.Message) (string, []byte, int) {
+	if !msg.Attributes.Has(stun.AttributeTypeMessageIntegrity) {
+		return "", nil, stun.ErrorCodeUnauthorized
+	}
+	username, errUser := msg.GetUsername()
+	realm, errRealm := msg.GetRealm()
+	nonce, errNonce := msg.GetNonce()
+	if errUser != nil || errRealm != nil || errNonce != nil {
+		return "", nil, stun.ErrorCodeBadRequest
+	}
+	if !s.nonces.valid(nonce) {
+		return "", nil, stun.ErrorCodeStaleNonce
+	}
+	if s.credentials == nil || realm != s.realm {
+		return "", nil, stun.ErrorCodeUnauthorized
+	}
+	password, ok := s.credentials(username)
+	if !ok {
+		return "", nil, stun.ErrorCodeUnauthorized
+	}
+	key := stun.LongTermKey(username, s.realm, password)
+	if err := msg.CheckIntegrity(key); err != nil {
+		return "", nil, stun.ErrorCodeUnauthorized
+	}
+	return username, key, 0
+}
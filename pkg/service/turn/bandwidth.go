@@ -0,0 +1,153 @@
+package turn
+
+import (
+	"io"
+	"sync"
+	"time"
+	"webRTCInfra/pkg/metrics"
+)
+
+// minBurst 令牌桶的最小容量，至少能通过一个以太网MTU大小的数据包
+const minBurst = 1500
+
+var (
+	// bandwidthDroppedPackets 超出带宽限制而被丢弃的中继数据包数
+	bandwidthDroppedPackets = metrics.NewCounter("turn_bandwidth_dropped_packets")
+	// bandwidthDroppedBytes 超出带宽限制而被丢弃的中继字节数
+	bandwidthDroppedBytes = metrics.NewCounter("turn_bandwidth_dropped_bytes")
+)
+
+// BandwidthFunc 根据用户名查找凭证指定的每个分配的带宽上限（字节/秒），未指定时返回false。
+// 与配额一样，临时凭证以其中的用户ID查找
+type BandwidthFunc func(username string) (bytesPerSecond int, ok bool)
+
+// tokenBucket 按字节计数的令牌桶，容量为1秒的流量。nil表示不限制
+type tokenBucket struct {
+	rate  float64 // 每秒补充的字节数
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket 创建每秒rate字节的令牌桶，rate不大于0时返回nil
+func newTokenBucket(rate int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := float64(max(rate, minBurst))
+	return &tokenBucket{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) refillLocked(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+// take 令牌足够时取出n字节的令牌并返回true，否则不取出
+func (b *tokenBucket) take(n int, now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refillLocked(now)
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// refund 归还take取出的令牌
+func (b *tokenBucket) refund(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.tokens = min(b.burst, b.tokens+float64(n))
+	b.mu.Unlock()
+}
+
+// wait 取出n字节的令牌，令牌不足时允许透支，返回需要等待令牌补足的时间
+func (b *tokenBucket) wait(n int, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refillLocked(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// SetBandwidthLimit 设置每个分配和每个用户所有分配合计的带宽上限（字节/秒，双向合计），0表示不限制，需在Start之前调用。
+// UDP中继超出上限的数据包被丢弃，TCP中继降低转发速度
+func (s *Service) SetBandwidthLimit(perAllocation, perUser int) {
+	s.allocationRate = perAllocation
+	s.userRate = perUser
+}
+
+// SetBandwidthFunc 设置凭证指定的分配带宽上限查找函数，查找到时覆盖SetBandwidthLimit的每个分配上限
+func (s *Service) SetBandwidthFunc(fn BandwidthFunc) {
+	s.bandwidth = fn
+}
+
+// allocationLimiter 返回新分配的令牌桶
+func (s *Service) allocationLimiter(username string) *tokenBucket {
+	rate := s.allocationRate
+	if s.bandwidth != nil {
+		if r, ok := s.bandwidth(quotaUser(username)); ok {
+			rate = r
+		}
+	}
+	return newTokenBucket(rate)
+}
+
+// userLimiterLocked 返回用户所有分配共用的令牌桶，调用者需持有s.mu并已为该用户占用配额
+func (s *Service) userLimiterLocked(username string) *tokenBucket {
+	if s.userRate <= 0 {
+		return nil
+	}
+	user := quotaUser(username)
+	b, ok := s.userLimiters[user]
+	if !ok {
+		b = newTokenBucket(s.userRate)
+		s.userLimiters[user] = b
+	}
+	return b
+}
+
+// allowRelay 判断n字节能否在分配和用户的带宽限制内转发，超出时计数并返回false
+func (a *allocation) allowRelay(n int) bool {
+	now := time.Now()
+	if a.limiter.take(n, now) {
+		if a.userLimiter.take(n, now) {
+			return true
+		}
+		a.limiter.refund(n)
+	}
+	bandwidthDroppedPackets.Add(1)
+	bandwidthDroppedBytes.Add(int64(n))
+	return false
+}
+
+// throttledWriter TCP中继的字节流不能丢弃，超出带宽限制时等待令牌补足后再写入
+type throttledWriter struct {
+	w io.Writer
+	a *allocation
+}
+
+func (t throttledWriter) Write(p []byte) (int, error) {
+	now := time.Now()
+	if d := max(t.a.limiter.wait(len(p), now), t.a.userLimiter.wait(len(p), now)); d > 0 {
+		time.Sleep(d)
+	}
+	return t.w.Write(p)
+}
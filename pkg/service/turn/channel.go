@@ -0,0 +1,169 @@
+package turn
+
+import (
+	"net"
+	"net/netip"
+	"time"
+	"webRTCInfra/pkg/metrics"
+	"webRTCInfra/pkg/protocol/stun"
+	stunservice "webRTCInfra/pkg/service/stun"
+)
+
+// ChannelBindingLifetime 通道绑定的有效期，重新发送ChannelBind可以刷新（RFC 8656 12）
+const ChannelBindingLifetime = 10 * time.Minute
+
+// channelDropped 没有对应分配、通道绑定或许可而被丢弃的ChannelData数
+var channelDropped = metrics.NewCounter("turn_channel_data_dropped")
+
+// channelBindAttributes ChannelBind请求中能够理解的必须理解属性
+var channelBindAttributes = []uint16{
+	stun.AttributeTypeUsername,
+	stun.AttributeTypeMessageIntegrity,
+	stun.AttributeTypeRealm,
+	stun.AttributeTypeNonce,
+	stun.AttributeTypeChannelNumber,
+	stun.AttributeTypeXORPeerAddress,
+}
+
+// channelBinding 通道号与对端地址的绑定
+type channelBinding struct {
+	number  uint16
+	peer    netip.AddrPort
+	expires time.Time
+}
+
+// bind 创建或刷新通道绑定，同时刷新对端IP的许可。
+// 通道号已绑定其他对端，或对端已绑定其他通道号时返回false
+func (a *allocation) bind(number uint16, peer netip.AddrPort, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	b, byNumber := a.channels[number]
+	_, byPeer := a.peers[peer]
+	if byNumber && b.peer != peer || !byNumber && byPeer {
+		return false
+	}
+	if !byNumber {
+		b = &channelBinding{number: number, peer: peer}
+		a.channels[number] = b
+		a.peers[peer] = b
+	}
+	b.expires = now.Add(ChannelBindingLifetime)
+	a.permitLocked(peer.Addr(), now)
+	return true
+}
+
+// channelPeer 返回通道号绑定的对端，要求绑定和许可都未过期
+func (a *allocation) channelPeer(number uint16) (netip.AddrPort, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	b, ok := a.channels[number]
+	if !ok || !now.Before(b.expires) || !a.permittedLocked(b.peer.Addr(), now) {
+		return netip.AddrPort{}, false
+	}
+	return b.peer, true
+}
+
+// route 判断对端发来的数据能否转发给客户端，可以时返回对端绑定的通道号，未绑定时为0
+func (a *allocation) route(peer netip.AddrPort) (uint16, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if !a.permittedLocked(peer.Addr(), now) {
+		return 0, false
+	}
+	if b, ok := a.peers[peer]; ok && now.Before(b.expires) {
+		return b.number, true
+	}
+	return 0, true
+}
+
+// sweepChannels 删除在now之前过期的通道绑定
+func (a *allocation) sweepChannels(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for number, b := range a.channels {
+		if !now.Before(b.expires) {
+			delete(a.channels, number)
+			delete(a.peers, b.peer)
+		}
+	}
+}
+
+// handleChannelBind 处理ChannelBind请求（RFC 8656 11.2），绑定或刷新通道并安装对端IP的许可
+func (s *Service) handleChannelBind(r *stunservice.Request) {
+	msg := r.Message
+	if stun.ClassOf(msg.Type) != stun.ClassRequest {
+		return
+	}
+
+	username, key, code := s.authenticate(msg)
+	if code != 0 {
+		s.sendErrorResponse(r, code, nil)
+		return
+	}
+	a := s.allocation(requestTuple(r))
+	if a == nil {
+		s.sendErrorResponse(r, stun.ErrorCodeAllocationMismatch, key)
+		return
+	}
+	if a.username != username {
+		s.sendErrorResponse(r, stun.ErrorCodeWrongCredentials, key)
+		return
+	}
+	if unknown := msg.Attributes.UnknownRequired(channelBindAttributes...); len(unknown) > 0 {
+		sendUnknownAttributes(r, unknown, key)
+		return
+	}
+	// TCP分配不使用通道（RFC 6062 5.5）
+	if a.transport != stun.TransportUDP {
+		s.sendErrorResponse(r, stun.ErrorCodeBadRequest, key)
+		return
+	}
+	number, err := msg.GetChannelNumber()
+	if err != nil || number < stun.MinChannelNumber || number > stun.MaxChannelNumber {
+		s.sendErrorResponse(r, stun.ErrorCodeBadRequest, key)
+		return
+	}
+	ip, port, err := msg.GetXORPeerAddress()
+	if err != nil {
+		s.sendErrorResponse(r, stun.ErrorCodeBadRequest, key)
+		return
+	}
+	peer := peerAddrPort(ip, port)
+	if !a.sameFamily(peer.Addr()) {
+		s.sendErrorResponse(r, stun.ErrorCodePeerAddressFamilyMismatch, key)
+		return
+	}
+	if !a.bind(number, peer, time.Now()) {
+		s.sendErrorResponse(r, stun.ErrorCodeBadRequest, key)
+		return
+	}
+
+	resp := stun.NewResponse(msg, stun.ClassSuccessResponse)
+	resp.IntegrityKey = key
+	sendMessage(r, resp)
+}
+
+// handleChannelData 将客户端发来的ChannelData转发给通道绑定的对端（RFC 8656 12.6）
+func (s *Service) handleChannelData(remote, local net.Addr, data []byte) {
+	a := s.allocation(newFiveTuple(remote, local))
+	if a == nil {
+		channelDropped.Add(1)
+		return
+	}
+	number, payload, err := stun.DecodeChannelData(data)
+	if err != nil {
+		channelDropped.Add(1)
+		return
+	}
+	peer, ok := a.channelPeer(number)
+	if !ok {
+		channelDropped.Add(1)
+		return
+	}
+	if !a.allowRelay(len(payload)) {
+		return
+	}
+	a.sendToPeer(payload, peer)
+}
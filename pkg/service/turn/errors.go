@@ -0,0 +1,33 @@
+package turn
+
+import (
+	"log"
+	"time"
+	"webRTCInfra/pkg/protocol/stun"
+	stunservice "webRTCInfra/pkg/service/stun"
+)
+
+// sendErrorResponse 发送错误响应，认证通过后的错误响应用key签名。
+// 401和438响应携带REALM和新的NONCE，客户端据此重新计算MESSAGE-INTEGRITY
+func (s *Service) sendErrorResponse(r *stunservice.Request, code int, key []byte) {
+	resp := stun.NewErrorResponse(r.Message, code)
+	if code == stun.ErrorCodeUnauthorized || code == stun.ErrorCodeStaleNonce {
+		resp.SetRealm(s.realm)
+		resp.SetNonce(s.nonces.generate(time.Now().Add(NonceLifetime)))
+	}
+	resp.IntegrityKey = key
+	sendMessage(r, resp)
+}
+
+// sendUnknownAttributes 发送420错误响应，列出无法理解的必须理解属性
+func sendUnknownAttributes(r *stunservice.Request, unknown []uint16, key []byte) {
+	resp := stun.NewUnknownAttributesResponse(r.Message, unknown)
+	resp.IntegrityKey = key
+	sendMessage(r, resp)
+}
+
+func sendMessage(r *stunservice.Request, msg *stun.Message) {
+	if err := r.Write(msg); err != nil {
+		log.Printf("failed to send TURN message: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package turn
+
+import (
+	"log"
+	"time"
+	"webRTCInfra/pkg/protocol/stun"
+	stunservice "webRTCInfra/pkg/service/stun"
+)
+
+const (
+	// DefaultLifetime 分配的默认有效期，请求的有效期小于该值时使用该值（RFC 8656 3.2）
+	DefaultLifetime = 10 * time.Minute
+	// DefaultMaxLifetime 分配有效期的默认上限
+	DefaultMaxLifetime = time.Hour
+)
+
+// SweepInterval 后台清理过期分配的间隔
+var SweepInterval = time.Second
+
+// refreshAttributes Refresh请求中能够理解的必须理解属性
+var refreshAttributes = []uint16{
+	stun.AttributeTypeUsername,
+	stun.AttributeTypeMessageIntegrity,
+	stun.AttributeTypeRealm,
+	stun.AttributeTypeNonce,
+	stun.AttributeTypeLifetime,
+}
+
+// SetMaxLifetime 设置分配有效期的上限，不小于DefaultLifetime，需在Start之前调用
+func (s *Service) SetMaxLifetime(lifetime time.Duration) {
+	s.maxLifetime = max(lifetime, DefaultLifetime)
+}
+
+// requestedLifetime 读取请求的LIFETIME，未携带时返回ok为false
+func requestedLifetime(msg *stun.Message) (lifetime time.Duration, ok bool, err error) {
+	if !msg.Attributes.Has(stun.AttributeTypeLifetime) {
+		return 0, false, nil
+	}
+	lifetime, err = msg.GetLifetime()
+	return lifetime, err == nil, err
+}
+
+// negotiateLifetime 按RFC 8656 7.2计算分配的有效期：未携带或小于默认值时使用默认值，超过上限时使用上限
+func (s *Service) negotiateLifetime(requested time.Duration, ok bool) time.Duration {
+	if !ok || requested < DefaultLifetime {
+		return DefaultLifetime
+	}
+	return min(requested, s.maxLifetime)
+}
+
+// handleRefresh 处理Refresh请求（RFC 8656 7.3），LIFETIME为0时删除分配
+func (s *Service) handleRefresh(r *stunservice.Request) {
+	msg := r.Message
+	if stun.ClassOf(msg.Type) != stun.ClassRequest {
+		return
+	}
+
+	username, key, code := s.authenticate(msg)
+	if code != 0 {
+		s.sendErrorResponse(r, code, nil)
+		return
+	}
+	a := s.allocation(requestTuple(r))
+	if a == nil {
+		s.sendErrorResponse(r, stun.ErrorCodeAllocationMismatch, key)
+		return
+	}
+	if a.username != username {
+		s.sendErrorResponse(r, stun.ErrorCodeWrongCredentials, key)
+		return
+	}
+	if unknown := msg.Attributes.UnknownRequired(refreshAttributes...); len(unknown) > 0 {
+		sendUnknownAttributes(r, unknown, key)
+		return
+	}
+	requested, ok, err := requestedLifetime(msg)
+	if err != nil {
+		s.sendErrorResponse(r, stun.ErrorCodeBadRequest, key)
+		return
+	}
+
+	var lifetime time.Duration
+	if ok && requested == 0 {
+		s.deleteAllocation(a)
+		log.Printf("TURN allocation %s deleted by client", a.tuple)
+	} else {
+		lifetime = s.negotiateLifetime(requested, ok)
+		a.refresh(lifetime)
+	}
+
+	resp := stun.NewResponse(msg, stun.ClassSuccessResponse)
+	resp.SetLifetime(lifetime)
+	resp.IntegrityKey = key
+	sendMessage(r, resp)
+}
+
+// sweepLoop 定期释放过期的分配，直到Close
+func (s *Service) sweepLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.sweep(now)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// sweep 删除在now之前过期的分配并释放其中继套接字，同时清理过期的许可和通道绑定
+func (s *Service) sweep(now time.Time) {
+	var expired []*allocation
+	s.mu.Lock()
+	for _, a := range s.allocations {
+		if a.expired(now) {
+			expired = append(expired, a)
+			s.removeAllocationLocked(a)
+			continue
+		}
+		a.sweepPermissions(now)
+		a.sweepChannels(now)
+	}
+	s.mu.Unlock()
+
+	for _, a := range expired {
+		a.close()
+		log.Printf("TURN allocation %s expired", a.tuple)
+	}
+}
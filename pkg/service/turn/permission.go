@@ -0,0 +1,208 @@
+package turn
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/netip"
+	"time"
+	"webRTCInfra/pkg/metrics"
+	"webRTCInfra/pkg/protocol/stun"
+	stunservice "webRTCInfra/pkg/service/stun"
+)
+
+// PermissionLifetime 许可的有效期，CreatePermission可以刷新（RFC 8656 9）
+const PermissionLifetime = 300 * time.Second
+
+var (
+	// peerDenied 来自没有许可的对端、被中继丢弃的数据包数
+	peerDenied = metrics.NewCounter("turn_peer_packets_denied")
+	// sendDenied 发往没有许可的对端、被丢弃的Send指示数
+	sendDenied = metrics.NewCounter("turn_send_denied")
+)
+
+// createPermissionAttributes CreatePermission请求中能够理解的必须理解属性
+var createPermissionAttributes = []uint16{
+	stun.AttributeTypeUsername,
+	stun.AttributeTypeMessageIntegrity,
+	stun.AttributeTypeRealm,
+	stun.AttributeTypeNonce,
+	stun.AttributeTypeXORPeerAddress,
+}
+
+// sendAttributes Send指示中能够理解的必须理解属性
+var sendAttributes = []uint16{
+	stun.AttributeTypeXORPeerAddress,
+	stun.AttributeTypeData,
+}
+
+// peerAddrPort 将属性中解析出的对端地址转换为netip.AddrPort
+func peerAddrPort(ip net.IP, port int) netip.AddrPort {
+	addr, _ := netip.AddrFromSlice(ip)
+	return netip.AddrPortFrom(addr.Unmap(), uint16(port))
+}
+
+// permit 为对端IP安装或刷新许可，许可只比较IP不比较端口
+func (a *allocation) permit(ip netip.Addr, now time.Time) {
+	a.mu.Lock()
+	a.permitLocked(ip, now)
+	a.mu.Unlock()
+}
+
+func (a *allocation) permitLocked(ip netip.Addr, now time.Time) {
+	a.permissions[ip] = now.Add(PermissionLifetime)
+}
+
+// permitted 判断对端IP是否有未过期的许可
+func (a *allocation) permitted(ip netip.Addr) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.permittedLocked(ip, time.Now())
+}
+
+func (a *allocation) permittedLocked(ip netip.Addr, now time.Time) bool {
+	expires, ok := a.permissions[ip]
+	return ok && now.Before(expires)
+}
+
+// sweepPermissions 删除在now之前过期的许可
+func (a *allocation) sweepPermissions(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for ip, expires := range a.permissions {
+		if !now.Before(expires) {
+			delete(a.permissions, ip)
+		}
+	}
+}
+
+// sameFamily 判断对端地址与中继地址是否属于同一地址族
+func (a *allocation) sameFamily(ip netip.Addr) bool {
+	return ip.Is4() == a.relayAddr.Addr().Is4()
+}
+
+// handleCreatePermission 处理CreatePermission请求（RFC 8656 9.2），一个请求可以为多个对端IP安装许可
+func (s *Service) handleCreatePermission(r *stunservice.Request) {
+	msg := r.Message
+	if stun.ClassOf(msg.Type) != stun.ClassRequest {
+		return
+	}
+
+	username, key, code := s.authenticate(msg)
+	if code != 0 {
+		s.sendErrorResponse(r, code, nil)
+		return
+	}
+	a := s.allocation(requestTuple(r))
+	if a == nil {
+		s.sendErrorResponse(r, stun.ErrorCodeAllocationMismatch, key)
+		return
+	}
+	if a.username != username {
+		s.sendErrorResponse(r, stun.ErrorCodeWrongCredentials, key)
+		return
+	}
+	if unknown := msg.Attributes.UnknownRequired(createPermissionAttributes...); len(unknown) > 0 {
+		sendUnknownAttributes(r, unknown, key)
+		return
+	}
+	peers, err := msg.GetXORPeerAddresses()
+	if err != nil {
+		s.sendErrorResponse(r, stun.ErrorCodeBadRequest, key)
+		return
+	}
+	// 任一地址不合法时整个请求失败，不安装任何许可
+	for _, peer := range peers {
+		if !a.sameFamily(peerAddrPort(peer.IP, peer.Port).Addr()) {
+			s.sendErrorResponse(r, stun.ErrorCodePeerAddressFamilyMismatch, key)
+			return
+		}
+	}
+
+	now := time.Now()
+	for _, peer := range peers {
+		a.permit(peerAddrPort(peer.IP, peer.Port).Addr(), now)
+	}
+
+	resp := stun.NewResponse(msg, stun.ClassSuccessResponse)
+	resp.IntegrityKey = key
+	sendMessage(r, resp)
+}
+
+// handleSend 处理Send指示（RFC 8656 11.2），将DATA通过中继发往有许可的对端。
+// 指示没有响应，任何错误都直接丢弃
+func (s *Service) handleSend(r *stunservice.Request) {
+	msg := r.Message
+	if stun.ClassOf(msg.Type) != stun.ClassIndication {
+		return
+	}
+	a := s.allocation(requestTuple(r))
+	if a == nil || a.relay == nil {
+		return
+	}
+	if unknown := msg.Attributes.UnknownRequired(sendAttributes...); len(unknown) > 0 {
+		return
+	}
+	ip, port, err := msg.GetXORPeerAddress()
+	if err != nil {
+		return
+	}
+	data, err := msg.GetData()
+	if err != nil {
+		return
+	}
+	peer := peerAddrPort(ip, port)
+	if !a.permitted(peer.Addr()) {
+		sendDenied.Add(1)
+		return
+	}
+	if !a.allowRelay(len(data)) {
+		return
+	}
+	a.sendToPeer(data, peer)
+}
+
+// sendToPeer 通过中继套接字向对端发送数据
+func (a *allocation) sendToPeer(data []byte, peer netip.AddrPort) {
+	if _, err := a.relay.WriteToUDPAddrPort(data, peer); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("TURN allocation %s failed to send to peer: %v", a.tuple, err)
+	}
+}
+
+// relayLoop 读取中继套接字上对端发来的数据，有许可时转发给客户端，直到分配被释放。
+// 对端绑定了通道时使用ChannelData，否则使用Data指示
+func (s *Service) relayLoop(a *allocation) {
+	defer s.wg.Done()
+	buf := make([]byte, 65536)
+	out := make([]byte, 0, 1500)
+	for {
+		n, peer, err := a.relay.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("TURN allocation %s relay read error: %v", a.tuple, err)
+			}
+			return
+		}
+		peer = netip.AddrPortFrom(peer.Addr().Unmap(), peer.Port())
+		number, ok := a.route(peer)
+		if !ok {
+			peerDenied.Add(1)
+			continue
+		}
+		if !a.allowRelay(n) {
+			continue
+		}
+
+		if number != 0 {
+			out = stun.AppendChannelData(out[:0], number, buf[:n])
+		} else {
+			ind := stun.NewMessage(stun.NewMessageType(stun.MethodData, stun.ClassIndication), stun.NewTransactionID())
+			ind.SetXORPeerAddress(peer.Addr().AsSlice(), int(peer.Port()))
+			ind.SetData(buf[:n])
+			out = stun.AppendEncode(out[:0], ind)
+		}
+		if err := a.client.Write(out); err != nil {
+			log.Printf("TURN allocation %s failed to relay to client: %v", a.tuple, err)
+		}
+	}
+}
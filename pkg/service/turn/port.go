@@ -0,0 +1,98 @@
+package turn
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// RelayPortCooldown 释放的中继端口在冷却期内不会再分配，避免对端发往旧分配的数据被新的分配收到
+var RelayPortCooldown = time.Minute
+
+// maxListenAttempts 端口被其他进程占用时，一次分配最多尝试的端口数
+const maxListenAttempts = 16
+
+var errNoRelayPort = errors.New("turn: no relay port available")
+
+// portAllocator 在端口范围内随机分配中继端口，UDP和TCP分配共用
+type portAllocator struct {
+	min, max int
+	cooldown time.Duration
+
+	mu      sync.Mutex
+	used    map[int]struct{}
+	cooling map[int]time.Time // 端口 -> 冷却结束的时间
+}
+
+func newPortAllocator(min, max int, cooldown time.Duration) *portAllocator {
+	return &portAllocator{
+		min:      min,
+		max:      max,
+		cooldown: cooldown,
+		used:     make(map[int]struct{}),
+		cooling:  make(map[int]time.Time),
+	}
+}
+
+// acquire 从随机位置开始查找未使用且不在冷却期的端口，端口耗尽时返回false
+func (p *portAllocator) acquire(now time.Time) (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := p.max - p.min + 1
+	start := rand.IntN(n)
+	for i := 0; i < n; i++ {
+		port := p.min + (start+i)%n
+		if _, ok := p.used[port]; ok {
+			continue
+		}
+		if until, ok := p.cooling[port]; ok {
+			if now.Before(until) {
+				continue
+			}
+			delete(p.cooling, port)
+		}
+		p.used[port] = struct{}{}
+		return port, true
+	}
+	return 0, false
+}
+
+// release 归还端口，端口进入冷却期
+func (p *portAllocator) release(port int, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.used, port)
+	p.cooling[port] = now.Add(p.cooldown)
+}
+
+// SetRelayPortRange 设置中继端口的范围（如49152-65535），需在Start之前调用。
+// 未设置时由系统分配临时端口
+func (s *Service) SetRelayPortRange(min, max int) error {
+	if min <= 0 || max > 65535 || min > max {
+		return fmt.Errorf("turn: invalid relay port range %d-%d", min, max)
+	}
+	s.ports = newPortAllocator(min, max, RelayPortCooldown)
+	return nil
+}
+
+// listenRelay 在端口范围内分配端口并调用listen监听，端口被占用时换用其他端口。
+// 返回分配的端口，未设置端口范围时以端口0调用listen并返回0
+func (s *Service) listenRelay(listen func(port int) error) (int, error) {
+	if s.ports == nil {
+		return 0, listen(0)
+	}
+	var err error
+	for i := 0; i < maxListenAttempts; i++ {
+		port, ok := s.ports.acquire(time.Now())
+		if !ok {
+			return 0, errNoRelayPort
+		}
+		if err = listen(port); err == nil {
+			return port, nil
+		}
+		s.ports.release(port, time.Now())
+	}
+	return 0, err
+}
@@ -0,0 +1,67 @@
+package turn
+
+import (
+	"net/netip"
+	"strconv"
+	"strings"
+	"webRTCInfra/pkg/metrics"
+)
+
+// quotaRejected 超出用户或来源IP的并发分配上限而被拒绝的Allocate请求数
+var quotaRejected = metrics.NewCounter("turn_allocation_quota_rejected")
+
+// SetAllocationQuota 设置每个用户和每个来源IP同时持有的分配数上限，0表示不限制，需在Start之前调用。
+// 超出上限的Allocate请求收到486 Allocation Quota Reached
+func (s *Service) SetAllocationQuota(perUser, perIP int) {
+	s.userQuota = perUser
+	s.ipQuota = perIP
+}
+
+// quotaUser 返回计入用户配额的用户名。临时凭证按其中的用户ID计数，换用新签发的凭证不能绕过上限
+func quotaUser(username string) string {
+	ts, userID, ok := strings.Cut(username, ":")
+	if !ok {
+		return username
+	}
+	if _, err := strconv.ParseInt(ts, 10, 64); err != nil {
+		return username
+	}
+	return userID
+}
+
+// reserveQuota 检查并占用用户和来源IP的分配配额，超出上限时返回false
+func (s *Service) reserveQuota(username string, ip netip.Addr) bool {
+	user := quotaUser(username)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.userQuota > 0 && s.userAllocations[user] >= s.userQuota ||
+		s.ipQuota > 0 && s.ipAllocations[ip] >= s.ipQuota {
+		return false
+	}
+	s.userAllocations[user]++
+	s.ipAllocations[ip]++
+	return true
+}
+
+// releaseQuotaLocked 归还分配占用的配额，调用者需持有s.mu
+func (s *Service) releaseQuotaLocked(username string, ip netip.Addr) {
+	user := quotaUser(username)
+	if s.userAllocations[user]--; s.userAllocations[user] <= 0 {
+		delete(s.userAllocations, user)
+		delete(s.userLimiters, user)
+	}
+	if s.ipAllocations[ip]--; s.ipAllocations[ip] <= 0 {
+		delete(s.ipAllocations, ip)
+	}
+}
+
+// removeAllocationLocked 从服务中删除分配并归还配额，调用者需持有s.mu。
+// 分配已被删除时返回false
+func (s *Service) removeAllocationLocked(a *allocation) bool {
+	if s.allocations[a.tuple] != a {
+		return false
+	}
+	delete(s.allocations, a.tuple)
+	s.releaseQuotaLocked(a.username, a.tuple.client.Addr())
+	return true
+}
@@ -0,0 +1,35 @@
+package turn
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EphemeralCredentials 按draft-uberti-behave-turn-rest生成在expires过期的临时凭证：
+// 用户名为"过期时间的Unix时间戳:userID"，密码为以secret为密钥对用户名计算的HMAC-SHA1的base64编码
+func EphemeralCredentials(secret, userID string, expires time.Time) (username, password string) {
+	username = strconv.FormatInt(expires.Unix(), 10) + ":" + userID
+	return username, ephemeralPassword(secret, username)
+}
+
+func ephemeralPassword(secret, username string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// EphemeralCredentialFunc 返回校验临时凭证的CredentialFunc，用户名中的时间戳无法解析或已过期时视为用户不存在
+func EphemeralCredentialFunc(secret string) CredentialFunc {
+	return func(username string) (string, bool) {
+		ts, _, _ := strings.Cut(username, ":")
+		expires, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil || time.Now().Unix() >= expires {
+			return "", false
+		}
+		return ephemeralPassword(secret, username), true
+	}
+}
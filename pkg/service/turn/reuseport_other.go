@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package turn
+
+import "syscall"
+
+// reusePort 不支持SO_REUSEPORT的平台上，Connect发起的连接使用中继IP上的临时端口
+const reusePort = false
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,221 @@
+package turn
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+	"webRTCInfra/pkg/protocol/stun"
+	stunservice "webRTCInfra/pkg/service/stun"
+)
+
+var errNoRelayIP = errors.New("turn: relay IP is not configured")
+
+// allocateAttributes Allocate请求中能够理解的必须理解属性
+var allocateAttributes = []uint16{
+	stun.AttributeTypeUsername,
+	stun.AttributeTypeMessageIntegrity,
+	stun.AttributeTypeRealm,
+	stun.AttributeTypeNonce,
+	stun.AttributeTypeRequestedTransport,
+	stun.AttributeTypeLifetime,
+}
+
+// Service TURN服务（RFC 8656），与STUN服务共用监听端口，使用长期凭证认证
+type Service struct {
+	realm       string
+	credentials CredentialFunc
+	nonces      *nonceSigner
+	relayIP     net.IP         // 中继套接字监听和公布的IP，为nil时使用接收请求的本地IP
+	ports       *portAllocator // 中继端口范围，为nil时由系统分配临时端口
+	maxLifetime time.Duration
+	userQuota   int // 每个用户同时持有的分配数上限，0表示不限制
+	ipQuota     int // 每个来源IP同时持有的分配数上限，0表示不限制
+
+	allocationRate int // 每个分配的带宽上限（字节/秒），0表示不限制
+	userRate       int // 每个用户所有分配合计的带宽上限（字节/秒），0表示不限制
+	bandwidth      BandwidthFunc
+
+	mu              sync.Mutex
+	allocations     map[fiveTuple]*allocation
+	connections     map[uint32]*tcpConnection // CONNECTION-ID -> 等待ConnectionBind的连接
+	userAllocations map[string]int            // 用户 -> 持有的分配数
+	ipAllocations   map[netip.Addr]int        // 来源IP -> 持有的分配数
+	userLimiters    map[string]*tokenBucket   // 用户 -> 所有分配共用的令牌桶
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewService 创建TURN服务，并在stunSvc上注册TURN方法的处理函数，需在stunSvc.Start之前调用
+func NewService(stunSvc *stunservice.Service, realm string) *Service {
+	service := &Service{
+		realm:           realm,
+		nonces:          newNonceSigner(),
+		maxLifetime:     DefaultMaxLifetime,
+		allocations:     make(map[fiveTuple]*allocation),
+		connections:     make(map[uint32]*tcpConnection),
+		userAllocations: make(map[string]int),
+		ipAllocations:   make(map[netip.Addr]int),
+		userLimiters:    make(map[string]*tokenBucket),
+		done:            make(chan struct{}),
+	}
+	stunSvc.HandleMethod(stun.MethodAllocate, service.handleAllocate)
+	stunSvc.HandleMethod(stun.MethodRefresh, service.handleRefresh)
+	stunSvc.HandleMethod(stun.MethodCreatePermission, service.handleCreatePermission)
+	stunSvc.HandleMethod(stun.MethodSend, service.handleSend)
+	stunSvc.HandleMethod(stun.MethodChannelBind, service.handleChannelBind)
+	stunSvc.HandleMethod(stun.MethodConnect, service.handleConnect)
+	stunSvc.HandleMethod(stun.MethodConnectionBind, service.handleConnectionBind)
+	stunSvc.HandleChannelData(service.handleChannelData)
+	stunSvc.HandleStreamClose(service.handleStreamClose)
+	return service
+}
+
+// SetCredentialFunc 设置长期凭证查找函数，未设置时所有请求都无法通过认证
+func (s *Service) SetCredentialFunc(fn CredentialFunc) {
+	s.credentials = fn
+}
+
+// SetRelayIP 设置中继地址的IP。未设置时使用接收请求的本地IP，此时STUN服务需监听具体的IP
+func (s *Service) SetRelayIP(ip string) error {
+	relayIP := net.ParseIP(ip)
+	if relayIP == nil || relayIP.IsUnspecified() {
+		return fmt.Errorf("turn: invalid relay IP %q", ip)
+	}
+	s.relayIP = relayIP
+	return nil
+}
+
+// Start 启动后台清理，过期的分配被删除并释放中继
+func (s *Service) Start() {
+	s.wg.Add(1)
+	go s.sweepLoop()
+}
+
+// Close 停止后台清理并释放所有分配
+func (s *Service) Close() {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+
+	s.mu.Lock()
+	for _, a := range s.allocations {
+		a.close()
+		s.removeAllocationLocked(a)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// handleAllocate 处理Allocate请求（RFC 8656 7.2）
+func (s *Service) handleAllocate(r *stunservice.Request) {
+	msg := r.Message
+	if stun.ClassOf(msg.Type) != stun.ClassRequest {
+		return
+	}
+
+	username, key, code := s.authenticate(msg)
+	if code != 0 {
+		s.sendErrorResponse(r, code, nil)
+		return
+	}
+
+	// 同一5元组上已存在分配：相同事务ID视为重传，重新回复成功响应
+	tuple := requestTuple(r)
+	if a := s.allocation(tuple); a != nil {
+		if a.transactionID == msg.TransactionID {
+			s.sendAllocateSuccess(r, a, key)
+			return
+		}
+		s.sendErrorResponse(r, stun.ErrorCodeAllocationMismatch, key)
+		return
+	}
+
+	if unknown := msg.Attributes.UnknownRequired(allocateAttributes...); len(unknown) > 0 {
+		log.Printf("TURN allocate from %s has unknown attributes: %v", r.Remote, unknown)
+		sendUnknownAttributes(r, unknown, key)
+		return
+	}
+	requested, ok, err := requestedLifetime(msg)
+	if err != nil {
+		s.sendErrorResponse(r, stun.ErrorCodeBadRequest, key)
+		return
+	}
+	transport, err := msg.GetRequestedTransport()
+	if err != nil {
+		s.sendErrorResponse(r, stun.ErrorCodeBadRequest, key)
+		return
+	}
+	if transport != stun.TransportUDP && transport != stun.TransportTCP {
+		s.sendErrorResponse(r, stun.ErrorCodeUnsupportedTransport, key)
+		return
+	}
+	if transport == stun.TransportTCP && r.TCP == nil {
+		// TCP分配只能在TCP或TLS控制连接上创建（RFC 6062 5.1）
+		s.sendErrorResponse(r, stun.ErrorCodeBadRequest, key)
+		return
+	}
+	if !s.reserveQuota(username, tuple.client.Addr()) {
+		quotaRejected.Add(1)
+		log.Printf("TURN allocation quota reached for user %s from %s", username, tuple.client.Addr())
+		s.sendErrorResponse(r, stun.ErrorCodeAllocationQuotaReached, key)
+		return
+	}
+
+	a := newAllocation(tuple, username, r)
+	a.transport = transport
+	a.ports = s.ports
+	a.limiter = s.allocationLimiter(username)
+	s.mu.Lock()
+	a.userLimiter = s.userLimiterLocked(username)
+	s.mu.Unlock()
+	if transport == stun.TransportUDP {
+		a.relay, err = s.allocateRelay(r.Local)
+		if err == nil {
+			a.relayAddr = addrPort(a.relay.LocalAddr())
+		}
+	} else {
+		a.listener, err = s.allocateTCPRelay(r.Local)
+		if err == nil {
+			a.relayAddr = addrPort(a.listener.Addr())
+		}
+	}
+	if err != nil {
+		log.Printf("failed to allocate relay for %s: %v", tuple, err)
+		s.mu.Lock()
+		s.releaseQuotaLocked(username, tuple.client.Addr())
+		s.mu.Unlock()
+		s.sendErrorResponse(r, stun.ErrorCodeInsufficientCapacity, key)
+		return
+	}
+	a.refresh(s.negotiateLifetime(requested, ok))
+	s.mu.Lock()
+	s.allocations[tuple] = a
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	if a.listener != nil {
+		go s.acceptLoop(a)
+	} else {
+		go s.relayLoop(a)
+	}
+
+	log.Printf("TURN allocation %s for user %s relayed at %s", tuple, username, a.relayAddr)
+	s.sendAllocateSuccess(r, a, key)
+}
+
+func (s *Service) sendAllocateSuccess(r *stunservice.Request, a *allocation, key []byte) {
+	resp := stun.NewResponse(r.Message, stun.ClassSuccessResponse)
+	resp.SetXORRelayedAddress(a.relayAddr.Addr().AsSlice(), int(a.relayAddr.Port()))
+	resp.SetLifetime(a.remaining())
+	clientIP, clientPort := stunservice.IPPort(r.Remote)
+	resp.SetXORMappedAddress(clientIP, clientPort)
+	resp.IntegrityKey = key
+	sendMessage(r, resp)
+}
@@ -0,0 +1,1333 @@
+package turn
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+	"webRTCInfra/pkg/network/tcp"
+	"webRTCInfra/pkg/network/udp"
+	"webRTCInfra/pkg/protocol/stun"
+	stunservice "webRTCInfra/pkg/service/stun"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testRealm    = "webrtcinfra.test"
+	testUser     = "alice"
+	testPassword = "secret"
+)
+
+var allocateRequest = stun.NewMessageType(stun.MethodAllocate, stun.ClassRequest)
+
+// startTestService 启动TURN服务，setup在启动之前修改配置
+func startTestService(t *testing.T, setup ...func(*Service)) (*Service, *net.UDPAddr) {
+	t.Helper()
+	stunSvc := stunservice.NewService(udp.NewService("127.0.0.1:0", nil))
+	return startService(t, stunSvc, setup...), stunSvc.LocalAddr()
+}
+
+// startTCPTestService 启动同时通过TCP提供服务的TURN服务，返回TCP监听地址
+func startTCPTestService(t *testing.T, setup ...func(*Service)) (*Service, *net.TCPAddr) {
+	t.Helper()
+	stunSvc := stunservice.NewService(udp.NewService("127.0.0.1:0", nil))
+	stunSvc.SetTCPServer(tcp.NewService("127.0.0.1:0", stun.SplitMessages, nil))
+	return startService(t, stunSvc, setup...), stunSvc.TCPAddr()
+}
+
+func startService(t *testing.T, stunSvc *stunservice.Service, setup ...func(*Service)) *Service {
+	t.Helper()
+	svc := NewService(stunSvc, testRealm)
+	svc.SetCredentialFunc(func(username string) (string, bool) {
+		return testPassword, username == testUser
+	})
+	for _, fn := range setup {
+		fn(svc)
+	}
+	svc.Start()
+	require.NoError(t, stunSvc.Start())
+	t.Cleanup(func() {
+		svc.Close()
+		stunSvc.Close()
+	})
+	return svc
+}
+
+// testClient 一个客户端5元组上的请求收发，TCP连接按stun.SplitMessages切分
+type testClient struct {
+	t       *testing.T
+	conn    net.Conn
+	scanner *bufio.Scanner // 仅TCP
+	nonce   string
+}
+
+func newTestClient(t *testing.T, server *net.UDPAddr) *testClient {
+	conn, err := net.DialUDP("udp", nil, server)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn}
+}
+
+func newTCPTestClient(t *testing.T, server *net.TCPAddr) *testClient {
+	conn, err := net.DialTCP("tcp", nil, server)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	scanner := bufio.NewScanner(conn)
+	scanner.Split(stun.SplitMessages)
+	return &testClient{t: t, conn: conn, scanner: scanner}
+}
+
+// tuple 返回客户端在服务端的5元组
+func (c *testClient) tuple() fiveTuple {
+	return newFiveTuple(c.conn.LocalAddr(), c.conn.RemoteAddr())
+}
+
+func (c *testClient) write(data []byte) {
+	c.t.Helper()
+	_, err := c.conn.Write(data)
+	require.NoError(c.t, err)
+}
+
+// readRaw 读取一个数据包或TCP上的一条消息，超时返回nil
+func (c *testClient) readRaw(timeout time.Duration) []byte {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	if c.scanner != nil {
+		if !c.scanner.Scan() {
+			require.NoError(c.t, c.scanner.Err())
+			c.t.Fatal("connection closed")
+		}
+		return c.scanner.Bytes()
+	}
+	buf := make([]byte, 1500)
+	n, err := c.conn.Read(buf)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return nil
+	}
+	require.NoError(c.t, err)
+	return buf[:n]
+}
+
+// read 读取一条STUN消息，超时返回nil
+func (c *testClient) read(timeout time.Duration) *stun.Message {
+	c.t.Helper()
+	data := c.readRaw(timeout)
+	if data == nil {
+		return nil
+	}
+	msg, err := stun.Decode(data)
+	require.NoError(c.t, err)
+	return msg
+}
+
+func (c *testClient) roundTrip(req *stun.Message) *stun.Message {
+	c.t.Helper()
+	c.write(stun.Encode(req))
+	resp := c.read(2 * time.Second)
+	require.NotNil(c.t, resp, "no response received")
+	assert.Equal(c.t, req.TransactionID, resp.TransactionID)
+	return resp
+}
+
+// signed 构造携带长期凭证的请求，nonce为空时先发送未认证的请求获取NONCE
+func (c *testClient) signed(msgType uint16, password string, setup func(*stun.Message)) *stun.Message {
+	c.t.Helper()
+	if c.nonce == "" {
+		resp := c.roundTrip(stun.NewMessage(msgType, stun.NewTransactionID()))
+		require.Equal(c.t, stun.ErrorCodeUnauthorized, errorCode(resp))
+		c.nonce, _ = resp.GetNonce()
+	}
+	req := stun.NewMessage(msgType, stun.NewTransactionID())
+	if setup != nil {
+		setup(req)
+	}
+	req.SetUsername(testUser)
+	req.SetRealm(testRealm)
+	req.SetNonce(c.nonce)
+	req.IntegrityKey = stun.LongTermKey(testUser, testRealm, password)
+	return req
+}
+
+func (c *testClient) allocate() *stun.Message {
+	c.t.Helper()
+	req := c.signed(allocateRequest, testPassword, func(m *stun.Message) {
+		m.SetRequestedTransport(stun.TransportUDP)
+	})
+	resp := c.roundTrip(req)
+	require.Equal(c.t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type), "error %d", errorCode(resp))
+	return resp
+}
+
+func errorCode(msg *stun.Message) int {
+	code, _, _ := msg.GetErrorCode()
+	return code
+}
+
+var testKey = stun.LongTermKey(testUser, testRealm, testPassword)
+
+func TestService_Allocate(t *testing.T) {
+	svc, server := startTestService(t)
+
+	t.Run("未认证的请求收到401和REALM、NONCE", func(t *testing.T) {
+		c := newTestClient(t, server)
+		req := stun.NewMessage(allocateRequest, stun.NewTransactionID())
+		req.SetRequestedTransport(stun.TransportUDP)
+		resp := c.roundTrip(req)
+		assert.Equal(t, stun.ErrorCodeUnauthorized, errorCode(resp))
+		realm, err := resp.GetRealm()
+		require.NoError(t, err)
+		assert.Equal(t, testRealm, realm)
+		nonce, err := resp.GetNonce()
+		require.NoError(t, err)
+		assert.NotEmpty(t, nonce)
+		assert.False(t, resp.Attributes.Has(stun.AttributeTypeMessageIntegrity))
+	})
+
+	t.Run("分配中继地址", func(t *testing.T) {
+		c := newTestClient(t, server)
+		resp := c.allocate()
+		assert.NoError(t, resp.CheckIntegrity(testKey))
+
+		relayIP, relayPort, err := resp.GetXORRelayedAddress()
+		require.NoError(t, err)
+		assert.True(t, relayIP.Equal(net.IPv4(127, 0, 0, 1)))
+		assert.NotZero(t, relayPort)
+		assert.NotEqual(t, server.Port, relayPort)
+
+		lifetime, err := resp.GetLifetime()
+		require.NoError(t, err)
+		assert.Equal(t, DefaultLifetime, lifetime)
+
+		mappedIP, mappedPort, err := resp.GetXORMappedAddress()
+		require.NoError(t, err)
+		local := c.conn.LocalAddr().(*net.UDPAddr)
+		assert.True(t, mappedIP.Equal(local.IP))
+		assert.Equal(t, local.Port, mappedPort)
+
+		// 中继端口已被占用
+		_, err = net.ListenUDP("udp", &net.UDPAddr{IP: relayIP, Port: relayPort})
+		assert.Error(t, err)
+	})
+
+	t.Run("重传返回同一分配，新的请求收到437", func(t *testing.T) {
+		c := newTestClient(t, server)
+		req := c.signed(allocateRequest, testPassword, func(m *stun.Message) {
+			m.SetRequestedTransport(stun.TransportUDP)
+		})
+		first := c.roundTrip(req)
+		require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(first.Type))
+		retransmit := c.roundTrip(req)
+		require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(retransmit.Type))
+		_, port1, _ := first.GetXORRelayedAddress()
+		_, port2, _ := retransmit.GetXORRelayedAddress()
+		assert.Equal(t, port1, port2)
+
+		resp := c.roundTrip(c.signed(allocateRequest, testPassword, func(m *stun.Message) {
+			m.SetRequestedTransport(stun.TransportUDP)
+		}))
+		assert.Equal(t, stun.ErrorCodeAllocationMismatch, errorCode(resp))
+		assert.NoError(t, resp.CheckIntegrity(testKey))
+	})
+
+	t.Run("密码错误收到401", func(t *testing.T) {
+		c := newTestClient(t, server)
+		resp := c.roundTrip(c.signed(allocateRequest, "wrong", func(m *stun.Message) {
+			m.SetRequestedTransport(stun.TransportUDP)
+		}))
+		assert.Equal(t, stun.ErrorCodeUnauthorized, errorCode(resp))
+		assert.True(t, resp.Attributes.Has(stun.AttributeTypeNonce))
+	})
+
+	t.Run("NONCE过期收到438，使用新NONCE后成功", func(t *testing.T) {
+		c := newTestClient(t, server)
+		c.nonce = svc.nonces.generate(time.Now().Add(-time.Second))
+		resp := c.roundTrip(c.signed(allocateRequest, testPassword, func(m *stun.Message) {
+			m.SetRequestedTransport(stun.TransportUDP)
+		}))
+		require.Equal(t, stun.ErrorCodeStaleNonce, errorCode(resp))
+		realm, _ := resp.GetRealm()
+		assert.Equal(t, testRealm, realm)
+		c.nonce, _ = resp.GetNonce()
+		require.True(t, svc.nonces.valid(c.nonce))
+
+		c.allocate()
+	})
+
+	t.Run("伪造的NONCE收到438", func(t *testing.T) {
+		c := newTestClient(t, server)
+		c.nonce = "ffffffff0000000000000000000000000000000000000000"
+		resp := c.roundTrip(c.signed(allocateRequest, testPassword, func(m *stun.Message) {
+			m.SetRequestedTransport(stun.TransportUDP)
+		}))
+		assert.Equal(t, stun.ErrorCodeStaleNonce, errorCode(resp))
+	})
+
+	t.Run("请求错误", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			setup func(*stun.Message)
+			code  int
+		}{
+			{"缺少REQUESTED-TRANSPORT", nil, stun.ErrorCodeBadRequest},
+			{"不支持的传输协议", func(m *stun.Message) { m.SetRequestedTransport(132) }, stun.ErrorCodeUnsupportedTransport},
+			{"UDP上请求TCP中继", func(m *stun.Message) { m.SetRequestedTransport(stun.TransportTCP) }, stun.ErrorCodeBadRequest},
+			{"不支持EVEN-PORT", func(m *stun.Message) {
+				m.SetRequestedTransport(stun.TransportUDP)
+				m.Attributes.Add(0x0018, []byte{0x80, 0, 0, 0})
+			}, stun.ErrorCodeUnknownAttribute},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				c := newTestClient(t, server)
+				resp := c.roundTrip(c.signed(allocateRequest, testPassword, tt.setup))
+				assert.Equal(t, tt.code, errorCode(resp))
+				assert.NoError(t, resp.CheckIntegrity(testKey))
+			})
+		}
+	})
+}
+
+func TestService_AllocateRelayIP(t *testing.T) {
+	// STUN服务监听通配地址时必须配置中继IP
+	start := func(t *testing.T, relayIP string) *net.UDPAddr {
+		stunSvc := stunservice.NewService(udp.NewService(":0", nil))
+		svc := NewService(stunSvc, testRealm)
+		svc.SetCredentialFunc(func(string) (string, bool) { return testPassword, true })
+		if relayIP != "" {
+			require.NoError(t, svc.SetRelayIP(relayIP))
+		}
+		require.NoError(t, stunSvc.Start())
+		t.Cleanup(func() {
+			svc.Close()
+			stunSvc.Close()
+		})
+		return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: stunSvc.LocalAddr().Port}
+	}
+
+	t.Run("未配置中继IP收到508", func(t *testing.T) {
+		c := newTestClient(t, start(t, ""))
+		resp := c.roundTrip(c.signed(allocateRequest, testPassword, func(m *stun.Message) {
+			m.SetRequestedTransport(stun.TransportUDP)
+		}))
+		assert.Equal(t, stun.ErrorCodeInsufficientCapacity, errorCode(resp))
+	})
+
+	t.Run("使用配置的中继IP", func(t *testing.T) {
+		c := newTestClient(t, start(t, "127.0.0.1"))
+		relayIP, _, err := c.allocate().GetXORRelayedAddress()
+		require.NoError(t, err)
+		assert.True(t, relayIP.Equal(net.IPv4(127, 0, 0, 1)))
+	})
+
+	assert.Error(t, NewService(stunservice.NewService(udp.NewService(":0", nil)), testRealm).SetRelayIP("0.0.0.0"))
+}
+
+var refreshRequest = stun.NewMessageType(stun.MethodRefresh, stun.ClassRequest)
+
+func TestService_Lifetime(t *testing.T) {
+	_, server := startTestService(t, func(s *Service) {
+		s.SetMaxLifetime(30 * time.Minute)
+	})
+
+	allocateWithLifetime := func(c *testClient, lifetime time.Duration) *stun.Message {
+		return c.roundTrip(c.signed(allocateRequest, testPassword, func(m *stun.Message) {
+			m.SetRequestedTransport(stun.TransportUDP)
+			m.SetLifetime(lifetime)
+		}))
+	}
+	refresh := func(c *testClient, setup func(*stun.Message)) *stun.Message {
+		return c.roundTrip(c.signed(refreshRequest, testPassword, setup))
+	}
+	lifetimeOf := func(t *testing.T, resp *stun.Message) time.Duration {
+		require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type), "error %d", errorCode(resp))
+		lifetime, err := resp.GetLifetime()
+		require.NoError(t, err)
+		return lifetime
+	}
+
+	t.Run("Allocate协商有效期", func(t *testing.T) {
+		tests := []struct {
+			name      string
+			requested time.Duration
+			want      time.Duration
+		}{
+			{"小于默认值时使用默认值", time.Minute, DefaultLifetime},
+			{"在范围内时使用请求值", 20 * time.Minute, 20 * time.Minute},
+			{"超过上限时使用上限", 2 * time.Hour, 30 * time.Minute},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				c := newTestClient(t, server)
+				assert.Equal(t, tt.want, lifetimeOf(t, allocateWithLifetime(c, tt.requested)))
+			})
+		}
+	})
+
+	t.Run("Refresh延长有效期", func(t *testing.T) {
+		c := newTestClient(t, server)
+		c.allocate()
+		resp := refresh(c, func(m *stun.Message) { m.SetLifetime(25 * time.Minute) })
+		assert.Equal(t, 25*time.Minute, lifetimeOf(t, resp))
+		assert.NoError(t, resp.CheckIntegrity(testKey))
+
+		// 未携带LIFETIME时使用默认值
+		assert.Equal(t, DefaultLifetime, lifetimeOf(t, refresh(c, nil)))
+	})
+
+	t.Run("LIFETIME为0删除分配", func(t *testing.T) {
+		c := newTestClient(t, server)
+		relayIP, relayPort, err := c.allocate().GetXORRelayedAddress()
+		require.NoError(t, err)
+
+		resp := refresh(c, func(m *stun.Message) { m.SetLifetime(0) })
+		assert.Equal(t, time.Duration(0), lifetimeOf(t, resp))
+
+		// 中继端口已释放
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: relayIP, Port: relayPort})
+		require.NoError(t, err)
+		conn.Close()
+
+		// 分配已不存在
+		resp = refresh(c, nil)
+		assert.Equal(t, stun.ErrorCodeAllocationMismatch, errorCode(resp))
+		// 删除后可以在同一5元组上重新分配
+		c.allocate()
+	})
+
+	t.Run("没有分配时收到437", func(t *testing.T) {
+		c := newTestClient(t, server)
+		assert.Equal(t, stun.ErrorCodeAllocationMismatch, errorCode(refresh(c, nil)))
+	})
+
+	t.Run("未认证的Refresh收到401", func(t *testing.T) {
+		c := newTestClient(t, server)
+		c.allocate()
+		resp := c.roundTrip(stun.NewMessage(refreshRequest, stun.NewTransactionID()))
+		assert.Equal(t, stun.ErrorCodeUnauthorized, errorCode(resp))
+	})
+}
+
+func TestService_WrongCredentials(t *testing.T) {
+	_, server := startTestService(t, func(s *Service) {
+		s.SetCredentialFunc(func(string) (string, bool) { return testPassword, true })
+	})
+
+	c := newTestClient(t, server)
+	c.allocate()
+
+	// 同一5元组上换用其他用户名刷新
+	req := stun.NewMessage(refreshRequest, stun.NewTransactionID())
+	req.SetUsername("mallory")
+	req.SetRealm(testRealm)
+	req.SetNonce(c.nonce)
+	req.IntegrityKey = stun.LongTermKey("mallory", testRealm, testPassword)
+	assert.Equal(t, stun.ErrorCodeWrongCredentials, errorCode(c.roundTrip(req)))
+}
+
+func TestEphemeralCredentials(t *testing.T) {
+	username, password := EphemeralCredentials("north", "alice", time.Unix(1700000000, 0))
+	assert.Equal(t, "1700000000:alice", username)
+	assert.Equal(t, "Cd/49soE35ICqcJF/bCTn8Z4OyE=", password)
+
+	_, server := startTestService(t, func(s *Service) {
+		s.SetCredentialFunc(EphemeralCredentialFunc("north"))
+	})
+	allocate := func(username, password string) *stun.Message {
+		c := newTestClient(t, server)
+		req := c.signed(allocateRequest, password, func(m *stun.Message) {
+			m.SetRequestedTransport(stun.TransportUDP)
+		})
+		req.SetUsername(username)
+		req.IntegrityKey = stun.LongTermKey(username, testRealm, password)
+		return c.roundTrip(req)
+	}
+
+	t.Run("有效的临时凭证", func(t *testing.T) {
+		username, password := EphemeralCredentials("north", "alice", time.Now().Add(time.Hour))
+		resp := allocate(username, password)
+		assert.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type), "error %d", errorCode(resp))
+	})
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"凭证已过期", username, password},
+		{"其他密钥签发的凭证", "", ""},
+		{"用户名不含时间戳", "alice", testPassword},
+	}
+	tests[1].username, tests[1].password = EphemeralCredentials("south", "alice", time.Now().Add(time.Hour))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, stun.ErrorCodeUnauthorized, errorCode(allocate(tt.username, tt.password)))
+		})
+	}
+}
+
+func TestService_Sweep(t *testing.T) {
+	svc, server := startTestService(t)
+	c := newTestClient(t, server)
+	relayIP, relayPort, err := c.allocate().GetXORRelayedAddress()
+	require.NoError(t, err)
+
+	tuple := c.tuple()
+	a := svc.allocation(tuple)
+	require.NotNil(t, a)
+
+	svc.sweep(time.Now().Add(DefaultLifetime - time.Minute))
+	assert.NotNil(t, svc.allocation(tuple), "allocation must not expire early")
+
+	// 将过期时间提前，由后台清理释放
+	a.refresh(0)
+	assert.Eventually(t, func() bool {
+		if svc.allocation(tuple) != nil {
+			return false
+		}
+		// 中继端口已释放
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: relayIP, Port: relayPort})
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}, 3*SweepInterval, 10*time.Millisecond)
+
+	assert.Equal(t, stun.ErrorCodeAllocationMismatch, errorCode(c.roundTrip(c.signed(refreshRequest, testPassword, nil))))
+}
+
+var (
+	createPermissionRequest = stun.NewMessageType(stun.MethodCreatePermission, stun.ClassRequest)
+	sendIndication          = stun.NewMessageType(stun.MethodSend, stun.ClassIndication)
+	dataIndication          = stun.NewMessageType(stun.MethodData, stun.ClassIndication)
+)
+
+func (c *testClient) createPermission(peers ...*net.UDPAddr) *stun.Message {
+	c.t.Helper()
+	return c.roundTrip(c.signed(createPermissionRequest, testPassword, func(m *stun.Message) {
+		for _, peer := range peers {
+			m.AddXORPeerAddress(peer.IP, peer.Port)
+		}
+	}))
+}
+
+func (c *testClient) send(peer *net.UDPAddr, data []byte) {
+	c.t.Helper()
+	ind := stun.NewMessage(sendIndication, stun.NewTransactionID())
+	ind.SetXORPeerAddress(peer.IP, peer.Port)
+	ind.SetData(data)
+	c.write(stun.Encode(ind))
+}
+
+// readPeer 在对端套接字上读取一个数据包，超时返回nil
+func readPeer(t *testing.T, conn *net.UDPConn, timeout time.Duration) ([]byte, *net.UDPAddr) {
+	t.Helper()
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	n, from, err := conn.ReadFromUDP(buf)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return nil, nil
+	}
+	require.NoError(t, err)
+	return buf[:n], from
+}
+
+func TestService_Relay(t *testing.T) {
+	svc, server := startTestService(t)
+	c := newTestClient(t, server)
+	relayIP, relayPort, err := c.allocate().GetXORRelayedAddress()
+	require.NoError(t, err)
+	relayAddr := &net.UDPAddr{IP: relayIP, Port: relayPort}
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer peer.Close()
+	peerAddr := peer.LocalAddr().(*net.UDPAddr)
+
+	t.Run("没有许可时双向丢弃并计数", func(t *testing.T) {
+		peerBefore, sendBefore := peerDenied.Value(), sendDenied.Value()
+
+		_, err := peer.WriteToUDP([]byte("from peer"), relayAddr)
+		require.NoError(t, err)
+		assert.Nil(t, c.read(200*time.Millisecond))
+
+		c.send(peerAddr, []byte("to peer"))
+		data, _ := readPeer(t, peer, 200*time.Millisecond)
+		assert.Nil(t, data)
+
+		assert.Equal(t, int64(1), peerDenied.Value()-peerBefore)
+		assert.Equal(t, int64(1), sendDenied.Value()-sendBefore)
+	})
+
+	t.Run("安装许可后转发Send和Data指示", func(t *testing.T) {
+		resp := c.createPermission(peerAddr)
+		require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type), "error %d", errorCode(resp))
+		assert.NoError(t, resp.CheckIntegrity(testKey))
+
+		c.send(peerAddr, []byte("to peer"))
+		data, from := readPeer(t, peer, 2*time.Second)
+		assert.Equal(t, []byte("to peer"), data)
+		assert.Equal(t, relayAddr.String(), from.String())
+
+		// 许可只比较IP，同一IP的其他端口也可以发来数据
+		other, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		defer other.Close()
+		for _, conn := range []*net.UDPConn{peer, other} {
+			_, err = conn.WriteToUDP([]byte("from peer"), relayAddr)
+			require.NoError(t, err)
+
+			ind := c.read(2 * time.Second)
+			require.NotNil(t, ind)
+			assert.Equal(t, dataIndication, ind.Type)
+			ip, port, err := ind.GetXORPeerAddress()
+			require.NoError(t, err)
+			assert.Equal(t, conn.LocalAddr().String(), (&net.UDPAddr{IP: ip, Port: port}).String())
+			data, err := ind.GetData()
+			require.NoError(t, err)
+			assert.Equal(t, []byte("from peer"), data)
+		}
+	})
+
+	t.Run("许可过期后丢弃", func(t *testing.T) {
+		a := svc.allocation(c.tuple())
+		require.NotNil(t, a)
+		a.permit(peerAddrPort(peerAddr.IP, peerAddr.Port).Addr(), time.Now().Add(-PermissionLifetime))
+
+		before := peerDenied.Value()
+		_, err := peer.WriteToUDP([]byte("late"), relayAddr)
+		require.NoError(t, err)
+		assert.Nil(t, c.read(200*time.Millisecond))
+		assert.Equal(t, int64(1), peerDenied.Value()-before)
+
+		svc.sweep(time.Now())
+		a.mu.Lock()
+		assert.Empty(t, a.permissions)
+		a.mu.Unlock()
+	})
+
+	t.Run("CreatePermission错误", func(t *testing.T) {
+		resp := c.roundTrip(c.signed(createPermissionRequest, testPassword, nil))
+		assert.Equal(t, stun.ErrorCodeBadRequest, errorCode(resp))
+
+		resp = c.createPermission(peerAddr, &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1})
+		assert.Equal(t, stun.ErrorCodePeerAddressFamilyMismatch, errorCode(resp))
+		assert.False(t, svc.allocation(c.tuple()).permitted(peerAddrPort(peerAddr.IP, peerAddr.Port).Addr()))
+
+		noAllocation := newTestClient(t, server)
+		resp = noAllocation.createPermission(peerAddr)
+		assert.Equal(t, stun.ErrorCodeAllocationMismatch, errorCode(resp))
+	})
+}
+
+var channelBindRequest = stun.NewMessageType(stun.MethodChannelBind, stun.ClassRequest)
+
+func (c *testClient) channelBind(number uint16, peer *net.UDPAddr) *stun.Message {
+	c.t.Helper()
+	return c.roundTrip(c.signed(channelBindRequest, testPassword, func(m *stun.Message) {
+		m.SetChannelNumber(number)
+		m.SetXORPeerAddress(peer.IP, peer.Port)
+	}))
+}
+
+// newTestPeer 在127.0.0.1上创建一个对端
+func newTestPeer(t *testing.T) (*net.UDPConn, *net.UDPAddr) {
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { peer.Close() })
+	return peer, peer.LocalAddr().(*net.UDPAddr)
+}
+
+// testChannel 通过通道与对端双向收发数据
+func testChannel(t *testing.T, c *testClient, number uint16, peer *net.UDPConn, relayAddr *net.UDPAddr) {
+	t.Helper()
+	c.write(stun.AppendChannelData(nil, number, []byte("to peer")))
+	data, from := readPeer(t, peer, 2*time.Second)
+	assert.Equal(t, []byte("to peer"), data)
+	assert.Equal(t, relayAddr.String(), from.String())
+
+	_, err := peer.WriteToUDP([]byte("from peer"), relayAddr)
+	require.NoError(t, err)
+	raw := c.readRaw(2 * time.Second)
+	require.True(t, stun.IsChannelData(raw), "expected ChannelData, got %X", raw)
+	got, payload, err := stun.DecodeChannelData(raw)
+	require.NoError(t, err)
+	assert.Equal(t, number, got)
+	assert.Equal(t, []byte("from peer"), payload)
+}
+
+func TestService_Channel(t *testing.T) {
+	svc, server := startTestService(t)
+	c := newTestClient(t, server)
+	relayIP, relayPort, err := c.allocate().GetXORRelayedAddress()
+	require.NoError(t, err)
+	relayAddr := &net.UDPAddr{IP: relayIP, Port: relayPort}
+	peer, peerAddr := newTestPeer(t)
+	_, otherAddr := newTestPeer(t)
+
+	t.Run("未绑定的通道被丢弃", func(t *testing.T) {
+		before := channelDropped.Value()
+		c.write(stun.AppendChannelData(nil, 0x4000, []byte("to peer")))
+		data, _ := readPeer(t, peer, 200*time.Millisecond)
+		assert.Nil(t, data)
+		assert.Equal(t, int64(1), channelDropped.Value()-before)
+	})
+
+	t.Run("绑定通道后双向转发ChannelData", func(t *testing.T) {
+		resp := c.channelBind(0x4000, peerAddr)
+		require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type), "error %d", errorCode(resp))
+		assert.NoError(t, resp.CheckIntegrity(testKey))
+		// ChannelBind同时安装了许可
+		assert.True(t, svc.allocation(c.tuple()).permitted(peerAddrPort(peerAddr.IP, peerAddr.Port).Addr()))
+
+		testChannel(t, c, 0x4000, peer, relayAddr)
+	})
+
+	t.Run("刷新和冲突的绑定", func(t *testing.T) {
+		resp := c.channelBind(0x4000, peerAddr)
+		assert.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type))
+
+		tests := []struct {
+			name   string
+			number uint16
+			peer   *net.UDPAddr
+		}{
+			{"通道号已绑定其他对端", 0x4000, otherAddr},
+			{"对端已绑定其他通道号", 0x4001, peerAddr},
+			{"通道号小于0x4000", 0x3FFF, otherAddr},
+			{"通道号大于0x7FFF", 0x8000, otherAddr},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				assert.Equal(t, stun.ErrorCodeBadRequest, errorCode(c.channelBind(tt.number, tt.peer)))
+			})
+		}
+
+		resp = c.channelBind(0x7FFF, otherAddr)
+		assert.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type))
+	})
+
+	t.Run("绑定过期后回退到Data指示", func(t *testing.T) {
+		a := svc.allocation(c.tuple())
+		a.mu.Lock()
+		a.channels[0x4000].expires = time.Now()
+		a.mu.Unlock()
+
+		before := channelDropped.Value()
+		c.write(stun.AppendChannelData(nil, 0x4000, []byte("to peer")))
+		data, _ := readPeer(t, peer, 200*time.Millisecond)
+		assert.Nil(t, data)
+		assert.Equal(t, int64(1), channelDropped.Value()-before)
+
+		_, err := peer.WriteToUDP([]byte("from peer"), relayAddr)
+		require.NoError(t, err)
+		ind := c.read(2 * time.Second)
+		require.NotNil(t, ind)
+		assert.Equal(t, dataIndication, ind.Type)
+
+		svc.sweep(time.Now())
+		a.mu.Lock()
+		assert.NotContains(t, a.channels, uint16(0x4000))
+		assert.NotContains(t, a.peers, peerAddrPort(peerAddr.IP, peerAddr.Port))
+		a.mu.Unlock()
+
+		// 过期后可以重新绑定
+		resp := c.channelBind(0x4000, peerAddr)
+		assert.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type))
+	})
+}
+
+func TestService_ChannelOverTCP(t *testing.T) {
+	_, server := startTCPTestService(t)
+	c := newTCPTestClient(t, server)
+	relayIP, relayPort, err := c.allocate().GetXORRelayedAddress()
+	require.NoError(t, err)
+	peer, peerAddr := newTestPeer(t)
+
+	// 数据长度不是4的倍数，TCP上需要填充
+	resp := c.channelBind(0x4123, peerAddr)
+	require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type), "error %d", errorCode(resp))
+	testChannel(t, c, 0x4123, peer, &net.UDPAddr{IP: relayIP, Port: relayPort})
+
+	// 填充后的字节流仍能继续处理STUN请求
+	resp = c.channelBind(0x4123, peerAddr)
+	assert.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type))
+}
+
+var (
+	connectRequest              = stun.NewMessageType(stun.MethodConnect, stun.ClassRequest)
+	connectionBindRequest       = stun.NewMessageType(stun.MethodConnectionBind, stun.ClassRequest)
+	connectionAttemptIndication = stun.NewMessageType(stun.MethodConnectionAttempt, stun.ClassIndication)
+)
+
+// TestService_RelayLargePayload 超过1024字节的RTP大小数据包通过Send指示和ChannelData完整转发
+func TestService_RelayLargePayload(t *testing.T) {
+	_, server := startTestService(t)
+	c := newTestClient(t, server)
+	relayIP, relayPort, err := c.allocate().GetXORRelayedAddress()
+	require.NoError(t, err)
+	relayAddr := &net.UDPAddr{IP: relayIP, Port: relayPort}
+	peer, peerAddr := newTestPeer(t)
+
+	resp := c.channelBind(0x4000, peerAddr)
+	require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type), "error %d", errorCode(resp))
+
+	for _, size := range []int{1000, 1200, 1400} {
+		payload := bytes.Repeat([]byte{byte(size)}, size)
+
+		c.send(peerAddr, payload)
+		data, _ := readPeer(t, peer, 2*time.Second)
+		assert.Equal(t, payload, data, "Send indication with %d bytes", size)
+
+		c.write(stun.AppendChannelData(nil, 0x4000, payload))
+		data, _ = readPeer(t, peer, 2*time.Second)
+		assert.Equal(t, payload, data, "ChannelData with %d bytes", size)
+
+		_, err := peer.WriteToUDP(payload, relayAddr)
+		require.NoError(t, err)
+		raw := c.readRaw(2 * time.Second)
+		_, got, err := stun.DecodeChannelData(raw)
+		require.NoError(t, err)
+		assert.Equal(t, payload, got, "ChannelData from peer with %d bytes", size)
+	}
+}
+
+func (c *testClient) allocateTCP() *net.TCPAddr {
+	c.t.Helper()
+	resp := c.roundTrip(c.signed(allocateRequest, testPassword, func(m *stun.Message) {
+		m.SetRequestedTransport(stun.TransportTCP)
+	}))
+	require.Equal(c.t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type), "error %d", errorCode(resp))
+	ip, port, err := resp.GetXORRelayedAddress()
+	require.NoError(c.t, err)
+	return &net.TCPAddr{IP: ip, Port: port}
+}
+
+func (c *testClient) connect(peer *net.TCPAddr) *stun.Message {
+	c.t.Helper()
+	return c.roundTrip(c.signed(connectRequest, testPassword, func(m *stun.Message) {
+		m.SetXORPeerAddress(peer.IP, peer.Port)
+	}))
+}
+
+// connectionBind 新建数据连接并发送ConnectionBind，extra与请求在同一报文段中发送
+func connectionBind(t *testing.T, server *net.TCPAddr, id uint32, extra []byte) (*testClient, *stun.Message) {
+	t.Helper()
+	d := newTCPTestClient(t, server)
+	req := d.signed(connectionBindRequest, testPassword, func(m *stun.Message) {
+		m.SetConnectionID(id)
+	})
+	d.write(append(stun.Encode(req), extra...))
+	resp := d.read(2 * time.Second)
+	require.NotNil(t, resp, "no response received")
+	return d, resp
+}
+
+// readFull 读取len(want)字节并与want比较
+func readFull(t *testing.T, conn net.Conn, want string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, len(want))
+	_, err := io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, want, string(buf))
+}
+
+// assertClosed 断言连接已被对方关闭
+func assertClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err := conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestService_TCPAllocation(t *testing.T) {
+	svc, server := startTCPTestService(t)
+	c := newTCPTestClient(t, server)
+	relayAddr := c.allocateTCP()
+	assert.True(t, relayAddr.IP.Equal(net.IPv4(127, 0, 0, 1)))
+
+	peer, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { peer.Close() })
+	peerAddr := peer.Addr().(*net.TCPAddr)
+
+	t.Run("没有许可时拒绝对端连接和Connect", func(t *testing.T) {
+		before := peerDenied.Value()
+		conn, err := net.DialTCP("tcp", nil, relayAddr)
+		require.NoError(t, err)
+		defer conn.Close()
+		assertClosed(t, conn)
+		assert.Equal(t, int64(1), peerDenied.Value()-before)
+
+		assert.Equal(t, stun.ErrorCodeForbidden, errorCode(c.connect(peerAddr)))
+	})
+
+	resp := c.createPermission(&net.UDPAddr{IP: peerAddr.IP, Port: peerAddr.Port})
+	require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type))
+
+	t.Run("Connect后通过数据连接与对端双向转发", func(t *testing.T) {
+		resp := c.connect(peerAddr)
+		require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type), "error %d", errorCode(resp))
+		assert.NoError(t, resp.CheckIntegrity(testKey))
+		id, err := resp.GetConnectionID()
+		require.NoError(t, err)
+		peer.SetDeadline(time.Now().Add(2 * time.Second))
+		peerConn, err := peer.Accept()
+		require.NoError(t, err)
+		defer peerConn.Close()
+		// 出站连接使用中继地址
+		assert.Equal(t, relayAddr.String(), peerConn.RemoteAddr().String())
+
+		// 到同一对端的连接已存在
+		assert.Equal(t, stun.ErrorCodeConnectionAlreadyExists, errorCode(c.connect(peerAddr)))
+
+		d, resp := connectionBind(t, server, id, nil)
+		require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type), "error %d", errorCode(resp))
+		assert.NoError(t, resp.CheckIntegrity(testKey))
+
+		d.write([]byte("to peer"))
+		readFull(t, peerConn, "to peer")
+		_, err = peerConn.Write([]byte("from peer"))
+		require.NoError(t, err)
+		readFull(t, d.conn, "from peer")
+
+		// CONNECTION-ID只能绑定一次
+		_, resp = connectionBind(t, server, id, nil)
+		assert.Equal(t, stun.ErrorCodeBadRequest, errorCode(resp))
+
+		// 对端关闭后数据连接随之关闭，可以再次连接同一对端
+		peerConn.Close()
+		assertClosed(t, d.conn)
+		assert.Eventually(t, func() bool {
+			return stun.ClassOf(c.connect(peerAddr).Type) == stun.ClassSuccessResponse
+		}, time.Second, 20*time.Millisecond)
+		peerConn, err = peer.Accept()
+		require.NoError(t, err)
+		peerConn.Close()
+	})
+
+	t.Run("对端主动连接时发送ConnectionAttempt", func(t *testing.T) {
+		conn, err := net.DialTCP("tcp", nil, relayAddr)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		ind := c.read(2 * time.Second)
+		require.NotNil(t, ind)
+		require.Equal(t, connectionAttemptIndication, ind.Type)
+		ip, port, err := ind.GetXORPeerAddress()
+		require.NoError(t, err)
+		local := conn.LocalAddr().(*net.TCPAddr)
+		assert.True(t, ip.Equal(local.IP))
+		assert.Equal(t, local.Port, port)
+		id, err := ind.GetConnectionID()
+		require.NoError(t, err)
+
+		// 与ConnectionBind请求同一报文段中的数据也转发给对端
+		d, resp := connectionBind(t, server, id, []byte("early"))
+		require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type), "error %d", errorCode(resp))
+		readFull(t, conn, "early")
+		_, err = conn.Write([]byte("from peer"))
+		require.NoError(t, err)
+		readFull(t, d.conn, "from peer")
+
+		// 释放分配时关闭所有连接
+		refresh := c.roundTrip(c.signed(refreshRequest, testPassword, func(m *stun.Message) {
+			m.SetLifetime(0)
+		}))
+		require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(refresh.Type))
+		assertClosed(t, conn)
+		assertClosed(t, d.conn)
+		assert.Nil(t, svc.allocation(c.tuple()))
+	})
+}
+
+func TestService_ControlConnectionClose(t *testing.T) {
+	svc, server := startTCPTestService(t)
+	peer, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { peer.Close() })
+	peerAddr := peer.Addr().(*net.TCPAddr)
+
+	c := newTCPTestClient(t, server)
+	relayAddr := c.allocateTCP()
+	resp := c.createPermission(&net.UDPAddr{IP: peerAddr.IP, Port: peerAddr.Port})
+	require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type))
+	resp = c.connect(peerAddr)
+	require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type), "error %d", errorCode(resp))
+	peer.SetDeadline(time.Now().Add(2 * time.Second))
+	peerConn, err := peer.Accept()
+	require.NoError(t, err)
+	defer peerConn.Close()
+
+	// UDP中继的分配同样随TCP控制连接删除
+	u := newTCPTestClient(t, server)
+	require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(u.allocate().Type))
+
+	c.conn.Close()
+	u.conn.Close()
+	require.Eventually(t, func() bool {
+		svc.mu.Lock()
+		defer svc.mu.Unlock()
+		return len(svc.allocations) == 0 && len(svc.userAllocations) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	_, err = net.DialTCP("tcp", nil, relayAddr)
+	assert.Error(t, err, "relay listener should be closed")
+	assertClosed(t, peerConn)
+}
+
+func TestService_TCPAllocationErrors(t *testing.T) {
+	_, server := startTCPTestService(t, func(*Service) {
+		timeout := ConnectionBindTimeout
+		ConnectionBindTimeout = 100 * time.Millisecond
+		t.Cleanup(func() { ConnectionBindTimeout = timeout })
+	})
+	c := newTCPTestClient(t, server)
+	relayAddr := c.allocateTCP()
+	c.createPermission(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+
+	t.Run("连接对端失败收到447", func(t *testing.T) {
+		closed, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		closed.Close()
+		assert.Equal(t, stun.ErrorCodeConnectionTimeoutOrFailure, errorCode(c.connect(closed.Addr().(*net.TCPAddr))))
+	})
+
+	t.Run("未及时ConnectionBind的连接被关闭", func(t *testing.T) {
+		conn, err := net.DialTCP("tcp", nil, relayAddr)
+		require.NoError(t, err)
+		defer conn.Close()
+		ind := c.read(2 * time.Second)
+		require.NotNil(t, ind)
+		id, err := ind.GetConnectionID()
+		require.NoError(t, err)
+
+		assertClosed(t, conn)
+		_, resp := connectionBind(t, server, id, nil)
+		assert.Equal(t, stun.ErrorCodeBadRequest, errorCode(resp))
+	})
+
+	t.Run("TCP分配不支持通道", func(t *testing.T) {
+		resp := c.channelBind(0x4000, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9})
+		assert.Equal(t, stun.ErrorCodeBadRequest, errorCode(resp))
+	})
+
+	t.Run("UDP分配不支持Connect", func(t *testing.T) {
+		_, udpServer := startTestService(t)
+		u := newTestClient(t, udpServer)
+		u.allocate()
+		resp := u.roundTrip(u.signed(connectRequest, testPassword, func(m *stun.Message) {
+			m.SetXORPeerAddress(net.IPv4(127, 0, 0, 1), 9)
+		}))
+		assert.Equal(t, stun.ErrorCodeBadRequest, errorCode(resp))
+	})
+
+	t.Run("控制连接上的ConnectionBind收到400", func(t *testing.T) {
+		resp := c.roundTrip(c.signed(connectionBindRequest, testPassword, func(m *stun.Message) {
+			m.SetConnectionID(1)
+		}))
+		assert.Equal(t, stun.ErrorCodeBadRequest, errorCode(resp))
+	})
+}
+
+func TestPortAllocator(t *testing.T) {
+	now := time.Now()
+	p := newPortAllocator(50000, 50002, time.Minute)
+	got := make(map[int]bool)
+	for i := 0; i < 3; i++ {
+		port, ok := p.acquire(now)
+		require.True(t, ok)
+		assert.True(t, port >= 50000 && port <= 50002, "port %d out of range", port)
+		got[port] = true
+	}
+	assert.Len(t, got, 3)
+	_, ok := p.acquire(now)
+	assert.False(t, ok, "range exhausted")
+
+	// 释放的端口在冷却期内不会再分配
+	p.release(50001, now)
+	_, ok = p.acquire(now.Add(time.Minute - time.Second))
+	assert.False(t, ok)
+	port, ok := p.acquire(now.Add(time.Minute))
+	require.True(t, ok)
+	assert.Equal(t, 50001, port)
+}
+
+func TestService_RelayPortRange(t *testing.T) {
+	// 取一段空闲端口作为中继端口范围
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	base := probe.LocalAddr().(*net.UDPAddr).Port
+	probe.Close()
+
+	svc, server := startTestService(t, func(s *Service) {
+		require.Error(t, s.SetRelayPortRange(0, 10))
+		require.Error(t, s.SetRelayPortRange(20, 10))
+		require.Error(t, s.SetRelayPortRange(65535, 65536))
+		require.NoError(t, s.SetRelayPortRange(base, base+1))
+	})
+
+	var clients []*testClient
+	for i := 0; i < 2; i++ {
+		c := newTestClient(t, server)
+		_, port, err := c.allocate().GetXORRelayedAddress()
+		require.NoError(t, err)
+		assert.True(t, port == base || port == base+1, "port %d out of range", port)
+		clients = append(clients, c)
+	}
+
+	// 端口耗尽
+	c := newTestClient(t, server)
+	resp := c.roundTrip(c.signed(allocateRequest, testPassword, func(m *stun.Message) {
+		m.SetRequestedTransport(stun.TransportUDP)
+	}))
+	assert.Equal(t, stun.ErrorCodeInsufficientCapacity, errorCode(resp))
+
+	// 删除的分配归还端口，冷却期内仍然不可用
+	svc.deleteAllocation(svc.allocation(clients[0].tuple()))
+	resp = c.roundTrip(c.signed(allocateRequest, testPassword, func(m *stun.Message) {
+		m.SetRequestedTransport(stun.TransportUDP)
+	}))
+	assert.Equal(t, stun.ErrorCodeInsufficientCapacity, errorCode(resp))
+}
+
+func TestService_AllocationQuota(t *testing.T) {
+	svc, server := startTestService(t, func(s *Service) {
+		s.SetAllocationQuota(2, 3)
+		s.SetCredentialFunc(func(username string) (string, bool) { return testPassword, true })
+	})
+	allocate := func(username string) *stun.Message {
+		c := newTestClient(t, server)
+		req := c.signed(allocateRequest, testPassword, func(m *stun.Message) {
+			m.SetRequestedTransport(stun.TransportUDP)
+		})
+		req.SetUsername(username)
+		req.IntegrityKey = stun.LongTermKey(username, testRealm, testPassword)
+		resp := c.roundTrip(req)
+		if stun.ClassOf(resp.Type) == stun.ClassSuccessResponse {
+			t.Cleanup(func() {
+				if a := svc.allocation(c.tuple()); a != nil {
+					svc.deleteAllocation(a)
+				}
+			})
+		}
+		return resp
+	}
+
+	t.Run("超出用户配额收到486", func(t *testing.T) {
+		before := quotaRejected.Value()
+		first := allocate("alice")
+		require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(first.Type))
+		require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(allocate("alice").Type))
+		resp := allocate("alice")
+		assert.Equal(t, stun.ErrorCodeAllocationQuotaReached, errorCode(resp))
+		assert.NoError(t, resp.CheckIntegrity(stun.LongTermKey("alice", testRealm, testPassword)))
+		assert.Equal(t, int64(1), quotaRejected.Value()-before)
+
+		// 同一用户ID的临时凭证共用配额
+		username, _ := EphemeralCredentials("north", "alice", time.Now().Add(time.Hour))
+		assert.Equal(t, stun.ErrorCodeAllocationQuotaReached, errorCode(allocate(username)))
+	})
+
+	t.Run("超出来源IP配额收到486", func(t *testing.T) {
+		// alice已持有2个分配，再有1个后127.0.0.1达到上限
+		require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(allocate("bob").Type))
+		assert.Equal(t, stun.ErrorCodeAllocationQuotaReached, errorCode(allocate("carol")))
+	})
+
+	t.Run("释放分配后归还配额", func(t *testing.T) {
+		svc.mu.Lock()
+		var alice *allocation
+		for _, a := range svc.allocations {
+			if a.username == "alice" {
+				alice = a
+				break
+			}
+		}
+		svc.mu.Unlock()
+		require.NotNil(t, alice)
+		svc.deleteAllocation(alice)
+		// 重复删除不会重复归还
+		svc.deleteAllocation(alice)
+
+		assert.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(allocate("carol").Type))
+		assert.Equal(t, stun.ErrorCodeAllocationQuotaReached, errorCode(allocate("alice")))
+
+		svc.mu.Lock()
+		assert.Equal(t, map[string]int{"alice": 1, "bob": 1, "carol": 1}, svc.userAllocations)
+		svc.mu.Unlock()
+	})
+}
+
+func TestTokenBucket(t *testing.T) {
+	var unlimited *tokenBucket
+	assert.Nil(t, newTokenBucket(0))
+	assert.True(t, unlimited.take(1<<20, time.Now()))
+	assert.Zero(t, unlimited.wait(1<<20, time.Now()))
+
+	b := newTokenBucket(10000)
+	now := b.last
+	assert.True(t, b.take(6000, now))
+	assert.False(t, b.take(6000, now), "only 4000 tokens left")
+	// 100ms补充1000字节
+	assert.True(t, b.take(5000, now.Add(100*time.Millisecond)))
+	assert.False(t, b.take(1, now.Add(100*time.Millisecond)))
+	b.refund(5000)
+	assert.True(t, b.take(5000, now.Add(100*time.Millisecond)))
+
+	// 容量不超过1秒的流量
+	assert.True(t, b.take(10000, now.Add(time.Hour)))
+	assert.False(t, b.take(1, now.Add(time.Hour)))
+
+	// 透支后返回补足需要的时间
+	assert.Equal(t, 500*time.Millisecond, b.wait(5000, now.Add(time.Hour)))
+
+	// 容量至少能通过一个MTU大小的数据包
+	assert.True(t, newTokenBucket(100).take(1500, time.Now()))
+}
+
+// sendBurst 客户端通过通道连续发送count个size字节的数据包
+func sendBurst(c *testClient, count, size int) {
+	for i := 0; i < count; i++ {
+		c.write(stun.AppendChannelData(nil, 0x4000, make([]byte, size)))
+	}
+}
+
+// countReceived 返回对端收到的数据包数，200ms内没有新的数据包时结束
+func countReceived(t *testing.T, peer *net.UDPConn) int {
+	t.Helper()
+	received := 0
+	for {
+		data, _ := readPeer(t, peer, 200*time.Millisecond)
+		if data == nil {
+			return received
+		}
+		received++
+	}
+}
+
+func TestService_BandwidthLimit(t *testing.T) {
+	svc, server := startTestService(t, func(s *Service) {
+		s.SetBandwidthLimit(10000, 15000)
+		s.SetBandwidthFunc(func(username string) (int, bool) {
+			return 100000, username == "premium"
+		})
+		ephemeral := EphemeralCredentialFunc("north")
+		s.SetCredentialFunc(func(username string) (string, bool) {
+			if password, ok := ephemeral(username); ok {
+				return password, true
+			}
+			return testPassword, true
+		})
+	})
+	newChannelClientWith := func(username, password string, peerAddr *net.UDPAddr) *testClient {
+		c := newTestClient(t, server)
+		req := c.signed(allocateRequest, password, func(m *stun.Message) {
+			m.SetRequestedTransport(stun.TransportUDP)
+		})
+		req.SetUsername(username)
+		req.IntegrityKey = stun.LongTermKey(username, testRealm, password)
+		require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(c.roundTrip(req).Type))
+		a := svc.allocation(c.tuple())
+		require.True(t, a.bind(0x4000, peerAddrPort(peerAddr.IP, peerAddr.Port), time.Now()))
+		return c
+	}
+	newChannelClient := func(username string, peerAddr *net.UDPAddr) *testClient {
+		return newChannelClientWith(username, testPassword, peerAddr)
+	}
+
+	t.Run("超出分配的带宽上限时丢弃并计数", func(t *testing.T) {
+		peer, peerAddr := newTestPeer(t)
+		c := newChannelClient("alice", peerAddr)
+		packets, bytes := bandwidthDroppedPackets.Value(), bandwidthDroppedBytes.Value()
+
+		// 容量10000字节，补充速度每毫秒10字节
+		sendBurst(c, 20, 1000)
+		received := countReceived(t, peer)
+		assert.GreaterOrEqual(t, received, 9)
+		assert.LessOrEqual(t, received, 11)
+		dropped := bandwidthDroppedPackets.Value() - packets
+		assert.Equal(t, int64(20-received), dropped)
+		assert.Equal(t, dropped*1000, bandwidthDroppedBytes.Value()-bytes)
+
+		// 对端到客户端方向共用同一限制：取空令牌后对端的数据包被丢弃
+		a := svc.allocation(c.tuple())
+		for a.limiter.take(1000, time.Now()) {
+		}
+		for i := 0; i < 3; i++ {
+			_, err := peer.WriteToUDP(make([]byte, 1000), net.UDPAddrFromAddrPort(a.relayAddr))
+			require.NoError(t, err)
+		}
+		assert.Eventually(t, func() bool {
+			return bandwidthDroppedPackets.Value()-packets == dropped+3
+		}, time.Second, 10*time.Millisecond)
+		assert.Nil(t, c.readRaw(100*time.Millisecond))
+	})
+
+	t.Run("同一用户的分配共用用户带宽上限", func(t *testing.T) {
+		peer, peerAddr := newTestPeer(t)
+		c1 := newChannelClient("bob", peerAddr)
+		c2 := newChannelClient("bob", peerAddr)
+		sendBurst(c1, 9, 1000)
+		sendBurst(c2, 9, 1000)
+		received := countReceived(t, peer)
+		assert.GreaterOrEqual(t, received, 15)
+		assert.LessOrEqual(t, received, 17)
+	})
+
+	t.Run("凭证指定的分配带宽上限", func(t *testing.T) {
+		peer, peerAddr := newTestPeer(t)
+		c := newChannelClient("premium", peerAddr)
+		// 仍受用户合计15000字节的限制
+		sendBurst(c, 20, 1000)
+		received := countReceived(t, peer)
+		assert.GreaterOrEqual(t, received, 15)
+		assert.LessOrEqual(t, received, 17)
+	})
+
+	t.Run("临时凭证按用户ID查找凭证指定的上限", func(t *testing.T) {
+		peer, peerAddr := newTestPeer(t)
+		username, password := EphemeralCredentials("north", "premium", time.Now().Add(time.Hour))
+		c := newChannelClientWith(username, password, peerAddr)
+		assert.Equal(t, float64(100000), svc.allocation(c.tuple()).limiter.rate)
+		// 与静态凭证的premium共用用户合计的限制，等待上一个子测试取出的令牌补足
+		time.Sleep(time.Second)
+		sendBurst(c, 20, 1000)
+		received := countReceived(t, peer)
+		assert.GreaterOrEqual(t, received, 15)
+		assert.LessOrEqual(t, received, 17)
+	})
+}
+
+func TestService_TCPBandwidthLimit(t *testing.T) {
+	_, server := startTCPTestService(t, func(s *Service) {
+		s.SetBandwidthLimit(20000, 0)
+	})
+	c := newTCPTestClient(t, server)
+	relayAddr := c.allocateTCP()
+	c.createPermission(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+
+	conn, err := net.DialTCP("tcp", nil, relayAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+	ind := c.read(2 * time.Second)
+	require.NotNil(t, ind)
+	id, err := ind.GetConnectionID()
+	require.NoError(t, err)
+	d, resp := connectionBind(t, server, id, nil)
+	require.Equal(t, stun.ClassSuccessResponse, stun.ClassOf(resp.Type))
+
+	// TCP中继不丢弃数据：容量20000字节，剩余10000字节需要约500ms
+	start := time.Now()
+	_, err = conn.Write(make([]byte, 30000))
+	require.NoError(t, err)
+	d.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = io.ReadFull(d.conn, make([]byte, 30000))
+	require.NoError(t, err)
+	assert.Greater(t, time.Since(start), 350*time.Millisecond)
+}
@@ -0,0 +1,336 @@
+package turn
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+	"webRTCInfra/pkg/protocol/stun"
+	stunservice "webRTCInfra/pkg/service/stun"
+)
+
+var (
+	// ConnectTimeout Connect请求连接对端的超时时间（RFC 6062 5.2）
+	ConnectTimeout = 30 * time.Second
+	// ConnectionBindTimeout 与对端的连接等待客户端ConnectionBind的时间，超时后关闭（RFC 6062 5.3）
+	ConnectionBindTimeout = 30 * time.Second
+)
+
+// connectAttributes Connect请求中能够理解的必须理解属性
+var connectAttributes = []uint16{
+	stun.AttributeTypeUsername,
+	stun.AttributeTypeMessageIntegrity,
+	stun.AttributeTypeRealm,
+	stun.AttributeTypeNonce,
+	stun.AttributeTypeXORPeerAddress,
+}
+
+// connectionBindAttributes ConnectionBind请求中能够理解的必须理解属性
+var connectionBindAttributes = []uint16{
+	stun.AttributeTypeUsername,
+	stun.AttributeTypeMessageIntegrity,
+	stun.AttributeTypeRealm,
+	stun.AttributeTypeNonce,
+	stun.AttributeTypeConnectionID,
+}
+
+// tcpConnection TCP分配中与对端的一条连接，客户端通过ConnectionBind为其建立数据连接后双向转发
+type tcpConnection struct {
+	id    uint32
+	alloc *allocation
+	peer  netip.AddrPort
+	conn  net.Conn    // 与对端的连接
+	timer *time.Timer // 等待ConnectionBind的超时
+
+	client net.Conn // 客户端的数据连接，ConnectionBind之前为nil，由alloc.mu保护
+}
+
+// close 关闭与对端的连接和客户端的数据连接
+func (c *tcpConnection) close() {
+	c.conn.Close()
+	c.alloc.mu.Lock()
+	client := c.client
+	c.alloc.mu.Unlock()
+	if client != nil {
+		client.Close()
+	}
+}
+
+// addConnection 为与对端的连接分配CONNECTION-ID并等待ConnectionBind。
+// 分配已释放或已存在到同一对端的连接时返回nil
+func (s *Service) addConnection(a *allocation, peer netip.AddrPort, conn net.Conn) *tcpConnection {
+	c := &tcpConnection{alloc: a, peer: peer, conn: conn}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c.id == 0 || s.connections[c.id] != nil {
+		c.id = rand.Uint32()
+	}
+
+	a.mu.Lock()
+	if a.closed || a.connections[peer] != nil {
+		a.mu.Unlock()
+		return nil
+	}
+	a.connections[peer] = c
+	a.mu.Unlock()
+
+	s.connections[c.id] = c
+	c.timer = time.AfterFunc(ConnectionBindTimeout, func() {
+		s.mu.Lock()
+		pending := s.connections[c.id] == c
+		s.mu.Unlock()
+		if pending {
+			log.Printf("TURN allocation %s connection to %s was not bound in time", a.tuple, peer)
+			s.removeConnection(c)
+		}
+	})
+	return c
+}
+
+// bindConnection 将等待中的连接与客户端的数据连接关联，连接已被绑定、超时或分配已释放时返回false
+func (s *Service) bindConnection(c *tcpConnection, client net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.connections[c.id] != c {
+		return false
+	}
+	delete(s.connections, c.id)
+	c.timer.Stop()
+
+	a := c.alloc
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.connections[c.peer] != c {
+		return false
+	}
+	c.client = client
+	return true
+}
+
+// removeConnection 关闭连接并从服务和分配中删除
+func (s *Service) removeConnection(c *tcpConnection) {
+	s.mu.Lock()
+	if s.connections[c.id] == c {
+		delete(s.connections, c.id)
+	}
+	s.mu.Unlock()
+
+	a := c.alloc
+	a.mu.Lock()
+	if a.connections[c.peer] == c {
+		delete(a.connections, c.peer)
+	}
+	a.mu.Unlock()
+	c.close()
+}
+
+// handleConnect 处理Connect请求（RFC 6062 5.2），由中继向有许可的对端发起TCP连接
+func (s *Service) handleConnect(r *stunservice.Request) {
+	msg := r.Message
+	if stun.ClassOf(msg.Type) != stun.ClassRequest {
+		return
+	}
+
+	username, key, code := s.authenticate(msg)
+	if code != 0 {
+		s.sendErrorResponse(r, code, nil)
+		return
+	}
+	a := s.allocation(requestTuple(r))
+	if a == nil {
+		s.sendErrorResponse(r, stun.ErrorCodeAllocationMismatch, key)
+		return
+	}
+	if a.username != username {
+		s.sendErrorResponse(r, stun.ErrorCodeWrongCredentials, key)
+		return
+	}
+	if unknown := msg.Attributes.UnknownRequired(connectAttributes...); len(unknown) > 0 {
+		sendUnknownAttributes(r, unknown, key)
+		return
+	}
+	if a.transport != stun.TransportTCP {
+		s.sendErrorResponse(r, stun.ErrorCodeBadRequest, key)
+		return
+	}
+	ip, port, err := msg.GetXORPeerAddress()
+	if err != nil {
+		s.sendErrorResponse(r, stun.ErrorCodeBadRequest, key)
+		return
+	}
+	peer := peerAddrPort(ip, port)
+	if !a.sameFamily(peer.Addr()) {
+		s.sendErrorResponse(r, stun.ErrorCodePeerAddressFamilyMismatch, key)
+		return
+	}
+	if !a.permitted(peer.Addr()) {
+		s.sendErrorResponse(r, stun.ErrorCodeForbidden, key)
+		return
+	}
+	a.mu.Lock()
+	_, exists := a.connections[peer]
+	a.mu.Unlock()
+	if exists {
+		s.sendErrorResponse(r, stun.ErrorCodeConnectionAlreadyExists, key)
+		return
+	}
+
+	// 消息引用接收缓冲区，在连接对端之前构造好响应
+	resp := stun.NewResponse(msg, stun.ClassSuccessResponse)
+	resp.IntegrityKey = key
+	failure := stun.NewErrorResponse(msg, stun.ErrorCodeConnectionTimeoutOrFailure)
+	failure.IntegrityKey = key
+	s.wg.Add(1)
+	go s.connect(r, a, peer, resp, failure)
+}
+
+// connect 连接对端并回复Connect请求，不阻塞控制连接上后续请求的处理。
+// 出站连接绑定中继地址，对端看到的地址与XOR-RELAYED-ADDRESS一致（RFC 6062 5.2）
+func (s *Service) connect(r *stunservice.Request, a *allocation, peer netip.AddrPort, resp, failure *stun.Message) {
+	defer s.wg.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), ConnectTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	local := &net.TCPAddr{IP: a.relayAddr.Addr().AsSlice()}
+	if reusePort {
+		local.Port = int(a.relayAddr.Port())
+	}
+	dialer := net.Dialer{LocalAddr: local, Control: reusePortControl}
+	conn, err := dialer.DialContext(ctx, "tcp", peer.String())
+	if err != nil {
+		log.Printf("TURN allocation %s failed to connect to %s: %v", a.tuple, peer, err)
+		sendMessage(r, failure)
+		return
+	}
+	c := s.addConnection(a, peer, conn)
+	if c == nil {
+		// 分配已释放，或并发的Connect已建立到同一对端的连接
+		conn.Close()
+		sendMessage(r, failure)
+		return
+	}
+	resp.SetConnectionID(c.id)
+	sendMessage(r, resp)
+}
+
+// acceptLoop 接受对端发往TCP中继的连接，有许可时通过ConnectionAttempt指示通知客户端（RFC 6062 5.3）
+func (s *Service) acceptLoop(a *allocation) {
+	defer s.wg.Done()
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("TURN allocation %s relay accept error: %v", a.tuple, err)
+			}
+			return
+		}
+		peer := addrPort(conn.RemoteAddr())
+		if !a.permitted(peer.Addr()) {
+			peerDenied.Add(1)
+			conn.Close()
+			continue
+		}
+		c := s.addConnection(a, peer, conn)
+		if c == nil {
+			conn.Close()
+			continue
+		}
+
+		ind := stun.NewMessage(stun.NewMessageType(stun.MethodConnectionAttempt, stun.ClassIndication), stun.NewTransactionID())
+		ind.SetXORPeerAddress(peer.Addr().AsSlice(), int(peer.Port()))
+		ind.SetConnectionID(c.id)
+		if err := a.client.Write(stun.Encode(ind)); err != nil {
+			log.Printf("TURN allocation %s failed to send ConnectionAttempt: %v", a.tuple, err)
+		}
+	}
+}
+
+// handleConnectionBind 处理ConnectionBind请求（RFC 6062 5.4）。
+// 请求在客户端新建的数据连接上发送，成功响应之后该连接不再承载STUN消息，与对端连接双向转发
+func (s *Service) handleConnectionBind(r *stunservice.Request) {
+	msg := r.Message
+	if stun.ClassOf(msg.Type) != stun.ClassRequest {
+		return
+	}
+
+	username, key, code := s.authenticate(msg)
+	if code != 0 {
+		s.sendErrorResponse(r, code, nil)
+		return
+	}
+	if unknown := msg.Attributes.UnknownRequired(connectionBindAttributes...); len(unknown) > 0 {
+		sendUnknownAttributes(r, unknown, key)
+		return
+	}
+	// 数据连接必须是TCP或TLS连接，且不能是已有分配的控制连接
+	if r.TCP == nil || s.allocation(requestTuple(r)) != nil {
+		s.sendErrorResponse(r, stun.ErrorCodeBadRequest, key)
+		return
+	}
+	id, err := msg.GetConnectionID()
+	if err != nil {
+		s.sendErrorResponse(r, stun.ErrorCodeBadRequest, key)
+		return
+	}
+	s.mu.Lock()
+	c := s.connections[id]
+	s.mu.Unlock()
+	if c == nil {
+		s.sendErrorResponse(r, stun.ErrorCodeBadRequest, key)
+		return
+	}
+	if c.alloc.username != username {
+		s.sendErrorResponse(r, stun.ErrorCodeWrongCredentials, key)
+		return
+	}
+	if !s.bindConnection(c, r.TCP.Conn) {
+		s.sendErrorResponse(r, stun.ErrorCodeBadRequest, key)
+		return
+	}
+
+	resp := stun.NewResponse(msg, stun.ClassSuccessResponse)
+	resp.IntegrityKey = key
+	sendMessage(r, resp)
+
+	s.wg.Add(1)
+	r.TCP.Hijack(func(client net.Conn, buffered []byte) {
+		s.bridge(c, client, buffered)
+	})
+}
+
+// bridge 在客户端数据连接与对端连接之间双向转发，任一方向结束时关闭两条连接。
+// 两个方向都受分配的带宽限制
+func (s *Service) bridge(c *tcpConnection, client net.Conn, buffered []byte) {
+	defer s.wg.Done()
+	defer s.removeConnection(c)
+	toPeer := throttledWriter{w: c.conn, a: c.alloc}
+	toClient := throttledWriter{w: client, a: c.alloc}
+	if len(buffered) > 0 {
+		if _, err := toPeer.Write(buffered); err != nil {
+			return
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		io.Copy(toPeer, client)
+		s.removeConnection(c)
+	}()
+	io.Copy(toClient, c.conn)
+	s.removeConnection(c)
+	wg.Wait()
+}